@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"diploma/client/session"
+	"diploma/crdt"
+
+	"github.com/nsf/termbox-go"
+	"github.com/sirupsen/logrus"
+)
+
+// action is a named editor command that keys can be bound to.
+type action func(ev termbox.Event, conn *session.Conn) error
+
+var actions = map[string]action{
+	"exit":                actionExit,
+	"save":                actionSave,
+	"search":              actionSearch,
+	"search-next":         actionSearchNext,
+	"search-prev":         actionSearchPrev,
+	"replace":             actionReplace,
+	"toggle-line-numbers": actionToggleLineNumbers,
+	"toggle-wrap":         actionToggleWrap,
+	"move-left":           actionMoveLeft,
+	"move-right":          actionMoveRight,
+	"move-up":             actionMoveUp,
+	"move-down":           actionMoveDown,
+	"move-home":           actionMoveHome,
+	"move-end":            actionMoveEnd,
+	"delete":              actionDelete,
+	"indent":              actionIndent,
+	"newline":             actionNewline,
+	"space":               actionSpace,
+	"file-tree":           actionFileTree,
+	"next-buffer":         actionNextBuffer,
+	"prev-buffer":         actionPrevBuffer,
+	"split-vertical":      actionSplitVertical,
+	"split-horizontal":    actionSplitHorizontal,
+	"close-pane":          actionClosePane,
+	"next-pane":           actionNextPane,
+	"new-tab":             actionNewTab,
+	"next-tab":            actionNextTab,
+	"prev-tab":            actionPrevTab,
+	"chat":                actionChat,
+	"comment":             actionComment,
+	"comments":            actionComments,
+	"blame":               actionBlame,
+	"export-blame":        actionExportBlame,
+	"history":             actionHistory,
+	"snapshot":            actionSnapshot,
+	"snapshots":           actionSnapshots,
+}
+
+// keymap holds the active key bindings.
+var keymap = DefaultKeymap()
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// exit session
+func actionExit(termbox.Event, *session.Conn) error {
+	// Return an error with the prefix "pairpad", so that it gets treated as an exit "event".
+	return errors.New("pairpad: exiting")
+}
+
+// save file contents, asking for a name if the document has none
+func actionSave(termbox.Event, *session.Conn) error {
+	if current == "" {
+		openSaveAsPrompt()
+		return nil
+	}
+	fileName := savePath(current)
+
+	err := os.MkdirAll(filepath.Dir(fileName), 0755)
+	if err == nil {
+		err = crdt.Save(fileName, doc)
+	}
+	if err == nil {
+		// the whole document goes next to the text, to load it back with
+		// the same IDs
+		err = crdt.SaveState(statePath(fileName), doc)
+	}
+	if err != nil {
+		logrus.Errorf("Failed to save to %s", fileName)
+		e.StatusChan <- fmt.Sprintf("Failed to save to %s", fileName)
+		return err
+	}
+
+	for _, ed := range editors() {
+		if ed.FileName == current {
+			ed.Modified, ed.RemoteModified = false, false
+		}
+	}
+	clearRecovery(current)
+	e.StatusChan <- fmt.Sprintf("Saved document to %s", fileName)
+	return nil
+}
+
+// The default key for loading content from a file was Ctrl+L.
+/* func actionLoad(ev termbox.Event, conn *websocket.Conn) error {
+	if fileName != "" {
+		logger.Log(logrus.InfoLevel, "LOADING DOCUMENT")
+		newDoc, err := crdt.Load(fileName)
+		if err != nil {
+			logrus.Errorf("failed to load file %s", fileName)
+			e.StatusChan <- fmt.Sprintf("Failed to load %s", fileName)
+			return err
+		}
+		e.StatusChan <- fmt.Sprintf("Loading %s", fileName)
+		doc = newDoc
+		e.SetX(0)
+		e.SetText(crdt.Content(doc))
+
+		logger.Log(logrus.InfoLevel, "SENDING DOCUMENT")
+		docMsg := commons.Message{Type: commons.DocSyncMessage, Document: doc}
+		_ = conn.WriteJSON(&docMsg)
+	} else {
+		e.StatusChan <- "No file to load!"
+	}
+	return nil
+} */
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// search the document
+func actionSearch(termbox.Event, *session.Conn) error {
+	openSearchPrompt()
+	return nil
+}
+
+func actionSearchNext(termbox.Event, *session.Conn) error {
+	e.NextMatch(1)
+	return nil
+}
+
+func actionSearchPrev(termbox.Event, *session.Conn) error {
+	e.NextMatch(-1)
+	return nil
+}
+
+// replace all matches of the current search
+func actionReplace(termbox.Event, *session.Conn) error {
+	openReplacePrompt()
+	return nil
+}
+
+// toggle the line number gutter
+func actionToggleLineNumbers(termbox.Event, *session.Conn) error {
+	e.ToggleLineNumbers()
+	return nil
+}
+
+// toggle soft wrap
+func actionToggleWrap(termbox.Event, *session.Conn) error {
+	e.ToggleWrap()
+	e.MoveCursor(0, 0)
+	return nil
+}
+
+// show the file tree and focus it, or hide it when it's focused
+func actionFileTree(termbox.Event, *session.Conn) error {
+	if tree.Visible && tree.Focused {
+		tree.Visible, tree.Focused = false, false
+	} else {
+		tree.Visible, tree.Focused = true, true
+		tree.Select(current)
+	}
+	layout()
+	return nil
+}
+
+// switch between the documents of the session
+func actionNextBuffer(termbox.Event, *session.Conn) error {
+	cycleDocument(1)
+	return nil
+}
+
+func actionPrevBuffer(termbox.Event, *session.Conn) error {
+	cycleDocument(-1)
+	return nil
+}
+
+// show the chat and focus its input, or hide it when it's focused
+func actionChat(termbox.Event, *session.Conn) error {
+	if chat.Visible && chat.Focused {
+		chat.Visible, chat.Focused = false, false
+	} else {
+		chat.Show()
+		chat.Focused = true
+	}
+	layout()
+	return nil
+}
+
+// comment on the selection or the current line
+func actionComment(termbox.Event, *session.Conn) error {
+	openCommentPrompt()
+	return nil
+}
+
+// show the comments of the document and focus them, or hide them when
+// they're focused
+func actionComments(termbox.Event, *session.Conn) error {
+	if comments.Visible && comments.Focused {
+		comments.Visible, comments.Focused = false, false
+	} else {
+		comments.Visible, comments.Focused = true, true
+		comments.SetItems(commentItems(doc))
+	}
+	layout()
+	return nil
+}
+
+// tint the text by author
+func actionBlame(termbox.Event, *session.Conn) error {
+	e.Blame = !e.Blame
+	return nil
+}
+
+// write who wrote each line of the document to a file
+func actionExportBlame(termbox.Event, *session.Conn) error {
+	fileName, err := exportBlame()
+	if err != nil {
+		logrus.Errorf("Failed to write blame to %s", fileName)
+		e.StatusChan <- fmt.Sprintf("Failed to write blame to %s", fileName)
+		return nil
+	}
+	e.StatusChan <- fmt.Sprintf("Wrote blame to %s", fileName)
+	return nil
+}
+
+// browse the history of the document
+func actionHistory(_ termbox.Event, conn *session.Conn) error {
+	requestHistory(conn)
+	return nil
+}
+
+// tag the document as it is now
+func actionSnapshot(termbox.Event, *session.Conn) error {
+	openSnapshotPrompt()
+	return nil
+}
+
+// list the snapshots of the document to compare them
+func actionSnapshots(_ termbox.Event, conn *session.Conn) error {
+	requestSnapshots(conn)
+	return nil
+}
+
+// split the focused pane, showing the current document in both halves
+func actionSplitVertical(termbox.Event, *session.Conn) error {
+	splitPane(true)
+	return nil
+}
+
+func actionSplitHorizontal(termbox.Event, *session.Conn) error {
+	splitPane(false)
+	return nil
+}
+
+func actionClosePane(termbox.Event, *session.Conn) error {
+	closePane()
+	return nil
+}
+
+func actionNextPane(termbox.Event, *session.Conn) error {
+	cyclePane(1)
+	return nil
+}
+
+// open the current document in a new tab
+func actionNewTab(termbox.Event, *session.Conn) error {
+	newTab()
+	return nil
+}
+
+func actionNextTab(termbox.Event, *session.Conn) error {
+	cycleTab(1)
+	return nil
+}
+
+func actionPrevTab(termbox.Event, *session.Conn) error {
+	cycleTab(-1)
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// move cursor
+func actionMoveLeft(termbox.Event, *session.Conn) error {
+	e.MoveCursor(-1, 0)
+	return nil
+}
+
+func actionMoveRight(termbox.Event, *session.Conn) error {
+	e.MoveCursor(1, 0)
+	return nil
+}
+
+func actionMoveUp(termbox.Event, *session.Conn) error {
+	e.MoveCursor(0, -1)
+	return nil
+}
+
+func actionMoveDown(termbox.Event, *session.Conn) error {
+	e.MoveCursor(0, 1)
+	return nil
+}
+
+func actionMoveHome(termbox.Event, *session.Conn) error {
+	e.SetX(0)
+	return nil
+}
+
+func actionMoveEnd(termbox.Event, *session.Conn) error {
+	e.SetX(len(e.Text))
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// delete symbol or selected text
+func actionDelete(ev termbox.Event, conn *session.Conn) error {
+	if !deleteSelection(conn) {
+		performOperation(OperationDelete, ev, conn)
+	}
+	return nil
+}
+
+func actionIndent(ev termbox.Event, conn *session.Conn) error {
+	deleteSelection(conn)
+	for i := 0; i < keymap.TabWidth; i++ {
+		ev.Ch = ' '
+		performOperation(OperationInsert, ev, conn)
+	}
+	return nil
+}
+
+func actionNewline(ev termbox.Event, conn *session.Conn) error {
+	deleteSelection(conn)
+	ev.Ch = '\n'
+	performOperation(OperationInsert, ev, conn)
+	return nil
+}
+
+func actionSpace(ev termbox.Event, conn *session.Conn) error {
+	deleteSelection(conn)
+	ev.Ch = ' '
+	performOperation(OperationInsert, ev, conn)
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"diploma/crdt"
+)
+
+// siteNames maps site IDs to the names of their users, as sent by the
+// server.
+var siteNames = map[string]string{}
+
+// authors returns the author of every rune of d. Text loaded from a file
+// has no author.
+func authors(d *crdt.Document) []string {
+	names := d.Authors()
+	for i, site := range names {
+		switch name, ok := siteNames[site]; {
+		case ok:
+			names[i] = name
+		case site == "0" || site == "":
+			names[i] = ""
+		default:
+			names[i] = "site " + site
+		}
+	}
+	return names
+}
+
+// refreshBlame updates the authors of the editors showing blame.
+func refreshBlame() {
+	for _, ed := range editors() {
+		if d, ok := docs[ed.FileName]; ok && ed.Blame && (ed != e || history == nil) {
+			ed.Authors = authors(d)
+		}
+	}
+}
+
+// blameLines returns the lines of d along with the user who wrote most of
+// each line.
+func blameLines(d *crdt.Document) ([]string, []string) {
+	text := []rune(crdt.Content(*d))
+	names := authors(d)
+
+	var lines, blame []string
+	start := 0
+	for i := 0; i <= len(text); i++ {
+		if i < len(text) && text[i] != '\n' {
+			continue
+		}
+
+		count := map[string]int{}
+		author := ""
+		for _, name := range names[start:min(i, len(names))] {
+			count[name]++
+			if count[name] > count[author] || (count[name] == count[author] && name < author) {
+				author = name
+			}
+		}
+
+		lines = append(lines, string(text[start:i]))
+		blame = append(blame, author)
+		start = i + 1
+	}
+	return lines, blame
+}
+
+// exportBlame writes the per line blame of the current document next to
+// the file it's saved to.
+func exportBlame() (string, error) {
+	lines, blame := blameLines(doc)
+
+	width := 1
+	for _, name := range blame {
+		width = max(width, len(name))
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		name := blame[i]
+		if name == "" {
+			name = "-"
+		}
+		fmt.Fprintf(&b, "%-*s %4d | %s\n", width, name, i+1, line)
+	}
+
+	fileName := savePath(current) + ".blame"
+	return fileName, os.WriteFile(fileName, []byte(b.String()), 0644)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"diploma/client/editor"
+	"diploma/client/session"
+	"diploma/commons"
+
+	"github.com/nsf/termbox-go"
+)
+
+var chat = editor.NewChat(32)
+
+// handleChatEvent handles keys while the chat input has the focus.
+func handleChatEvent(ev termbox.Event, conn *session.Conn) {
+	if name, ok := keymap.Lookup(ev); ok && name == "chat" {
+		_ = actionChat(ev, conn)
+		return
+	}
+
+	switch ev.Key {
+	case termbox.KeyEnter:
+		sendChat(conn)
+
+	// give the focus back to the editor
+	case termbox.KeyEsc, termbox.KeyTab:
+		chat.Focused = false
+
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		chat.Backspace()
+
+	case termbox.KeySpace:
+		chat.Insert(' ')
+
+	// scrollback
+	case termbox.KeyArrowUp:
+		chat.ScrollBy(1)
+
+	case termbox.KeyArrowDown:
+		chat.ScrollBy(-1)
+
+	case termbox.KeyPgup:
+		chat.ScrollBy(e.Height / 2)
+
+	case termbox.KeyPgdn:
+		chat.ScrollBy(-e.Height / 2)
+
+	default:
+		if ev.Ch != 0 {
+			chat.Insert(ev.Ch)
+		}
+	}
+}
+
+// sendChat sends the chat input to the session. The server sends the
+// message back to everyone, so it's only added locally while offline.
+func sendChat(conn *session.Conn) {
+	text := strings.TrimSpace(string(chat.Input))
+	if text == "" {
+		return
+	}
+	chat.Input = nil
+	chat.Scroll = 0
+
+	if !e.IsConnected {
+		chat.Add(commons.ChatEntry{Username: e.Username, Text: text, Time: time.Now()})
+		return
+	}
+
+	msg := commons.Message{Username: e.Username, Type: commons.ChatMessage, Text: text}
+	if err := conn.Send(msg); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
+// handleChatMessage adds chat received from the server.
+func handleChatMessage(msg commons.Message) {
+	switch msg.Type {
+	case commons.ChatHistoryMessage:
+		chat.Entries = append(msg.Chat, chat.Entries...)
+
+	case commons.ChatMessage:
+		chat.Add(msg.Chat...)
+		if chat.Visible {
+			return
+		}
+		for _, entry := range msg.Chat {
+			if entry.Username != e.Username {
+				e.StatusChan <- fmt.Sprintf("%s: %s", entry.Username, entry.Text)
+			}
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"diploma/client/editor"
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/google/uuid"
+	"github.com/nsf/termbox-go"
+)
+
+var (
+	comments = editor.NewCommentList(32)
+
+	// commentAnchor is the text the comment being written is about.
+	commentAnchor crdt.Comment
+)
+
+// openCommentPrompt asks for a comment on the selection, or on the line of
+// the cursor if nothing is selected.
+func openCommentPrompt() {
+	text := e.GetText()
+
+	start, end, ok := e.SelectionRange()
+	if !ok {
+		start, end = lineBounds(text, e.Cursor)
+	}
+	if start >= end {
+		e.StatusChan <- "Nothing to comment on"
+		return
+	}
+
+	first, err := crdt.IthVisible(*doc, start+1)
+	if err != nil {
+		e.StatusChan <- "Nothing to comment on"
+		return
+	}
+	last, err := crdt.IthVisible(*doc, end)
+	if err != nil {
+		e.StatusChan <- "Nothing to comment on"
+		return
+	}
+	commentAnchor = crdt.Comment{Start: first.ID, End: last.ID}
+
+	promptKind = promptComment
+	e.OpenPrompt("Comment: ")
+}
+
+// addComment adds a comment on the text chosen by openCommentPrompt.
+func addComment(text string, conn *session.Conn) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return
+	}
+
+	c := commentAnchor
+	c.ID = uuid.NewString()
+	c.Author = e.Username
+	c.Text = text
+	touchComment(&c)
+
+	doc.PutComment(c)
+	e.ClearSelection()
+	sendComment(commons.CommentMessage, c, conn)
+}
+
+// resolveComment marks a comment of the current document as resolved, or
+// opens it again.
+func resolveComment(id string, conn *session.Conn) {
+	for _, c := range doc.Comments {
+		if c.ID == id {
+			c.Resolved = !c.Resolved
+			touchComment(&c)
+			doc.PutComment(c)
+			sendComment(commons.CommentMessage, c, conn)
+			return
+		}
+	}
+}
+
+// touchComment stamps a change of c by this site, later than the version
+// it replaces even if that came from a clock ahead of ours.
+func touchComment(c *crdt.Comment) {
+	now := time.Now()
+	if !now.After(c.Time) {
+		now = c.Time.Add(time.Nanosecond)
+	}
+	c.Time, c.Site = now, crdt.SiteID
+}
+
+func deleteComment(id string, conn *session.Conn) {
+	doc.DeleteComment(id)
+	sendComment(commons.CommentDeleteMessage, crdt.Comment{ID: id}, conn)
+}
+
+func sendComment(msgType commons.MessageType, c crdt.Comment, conn *session.Conn) {
+	if !e.IsConnected {
+		return
+	}
+
+	msg := commons.Message{Username: e.Username, Type: msgType, Path: current, Comment: &c}
+	if err := conn.Send(msg); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
+// handleCommentMessage applies a comment change made by another user.
+func handleCommentMessage(msg commons.Message) {
+	d, ok := docs[msg.Path]
+	if !ok || msg.Comment == nil {
+		return
+	}
+
+	switch msg.Type {
+	case commons.CommentMessage:
+		d.PutComment(*msg.Comment)
+		if !comments.Visible && !msg.Comment.Resolved {
+			e.StatusChan <- fmt.Sprintf("%s commented: %s", msg.Username, msg.Comment.Text)
+		}
+
+	case commons.CommentDeleteMessage:
+		d.DeleteComment(msg.Comment.ID)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// refreshComments updates the underlined ranges of every editor and the
+// comments pane after the text or the comments changed.
+func refreshComments() {
+	for _, ed := range editors() {
+		d, ok := docs[ed.FileName]
+		if !ok {
+			continue
+		}
+
+		ed.Annotations = ed.Annotations[:0]
+		if ed == e && history != nil {
+			continue
+		}
+		for _, c := range d.Comments {
+			if start, end, ok := d.CommentSpan(c); ok && !c.Resolved {
+				ed.Annotations = append(ed.Annotations, editor.Match{Start: start, End: end})
+			}
+		}
+	}
+
+	if comments.Visible {
+		comments.SetItems(commentItems(doc))
+	}
+}
+
+// commentItems lists the comments of d along with their lines.
+func commentItems(d *crdt.Document) []editor.CommentItem {
+	text := []rune(crdt.Content(*d))
+
+	items := make([]editor.CommentItem, 0, len(d.Comments))
+	for _, c := range d.Comments {
+		item := editor.CommentItem{Comment: c}
+		if start, _, ok := d.CommentSpan(c); ok {
+			item.Line = strings.Count(string(text[:start]), "\n") + 1
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// handleCommentsEvent handles keys while the comments pane has the focus.
+func handleCommentsEvent(ev termbox.Event, conn *session.Conn) {
+	if name, ok := keymap.Lookup(ev); ok && name == "comments" {
+		_ = actionComments(ev, conn)
+		return
+	}
+
+	selected := comments.Selected()
+
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Key == termbox.KeyCtrlP || ev.Ch == 'k':
+		comments.Move(-1)
+
+	case ev.Key == termbox.KeyArrowDown || ev.Key == termbox.KeyCtrlN || ev.Ch == 'j':
+		comments.Move(1)
+
+	// select the commented text
+	case ev.Key == termbox.KeyEnter && selected != nil:
+		if start, end, ok := doc.CommentSpan(selected.Comment); ok {
+			e.SetX(start)
+			e.Selection = &editor.Selection{Anchor: end}
+			e.MoveCursor(0, 0)
+		}
+
+	// give the focus back to the editor
+	case ev.Key == termbox.KeyEsc || ev.Key == termbox.KeyTab:
+		comments.Focused = false
+
+	case ev.Ch == 'r' && selected != nil:
+		resolveComment(selected.ID, conn)
+
+	case ev.Ch == 'd' && selected != nil:
+		deleteComment(selected.ID, conn)
+
+	// show or hide resolved comments
+	case ev.Ch == 'a':
+		comments.ShowResolved = !comments.ShowResolved
+	}
+}
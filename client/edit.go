@@ -0,0 +1,92 @@
+package main
+
+import (
+	"diploma/client/editor"
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+)
+
+// deleteSelection removes the selected text and reports whether there was
+// anything to remove.
+func deleteSelection(conn *session.Conn) bool {
+	start, end, ok := e.SelectionRange()
+	if !ok {
+		return false
+	}
+
+	deleteRange(start, end, conn)
+	e.ClearSelection()
+	return true
+}
+
+// deleteRange removes the runes [start, end) as one batch and moves the
+// cursor to start.
+func deleteRange(start, end int, conn *session.Conn) {
+	if start >= end {
+		return
+	}
+
+	var ops []commons.Operation
+	for i := start; i < end; i++ {
+		op, err := localDelete(start + 1)
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			break
+		}
+		ops = append(ops, op)
+	}
+
+	for name, user := range e.UsersPos {
+		if name != e.Username && end <= user.Pos {
+			e.UsersPos[name] = editor.CursorColPos{Pos: user.Pos - (end - start), Col: user.Col}
+		}
+	}
+
+	e.SetText(crdt.Content(*doc))
+	e.SetX(start)
+	e.Modified = true
+
+	sendOperations(ops, conn)
+}
+
+// insertText inserts text before the rune at index at as one batch. The
+// cursor is left unchanged.
+func insertText(at int, text string, conn *session.Conn) {
+	var ops []commons.Operation
+	for i, r := range []rune(text) {
+		op, err := localInsert(at+1+i, string(r))
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			break
+		}
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return
+	}
+
+	n := len(ops)
+	for name, user := range e.UsersPos {
+		if name != e.Username && at < user.Pos {
+			e.UsersPos[name] = editor.CursorColPos{Pos: user.Pos + n, Col: user.Col}
+		}
+	}
+
+	e.SetText(crdt.Content(*doc))
+	e.Modified = true
+
+	sendOperations(ops, conn)
+}
+
+// localInsert inserts value at the 1-based position of the current document
+// and returns the operation repeating it at other sites.
+func localInsert(pos int, value string) (commons.Operation, error) {
+	return commons.Insert(doc, pos, value)
+}
+
+// localDelete deletes the rune at the 1-based position of the current
+// document and returns the operation repeating it at other sites.
+func localDelete(pos int) (commons.Operation, error) {
+	return commons.Delete(doc, pos)
+}
@@ -0,0 +1,132 @@
+package editor
+
+import (
+	"diploma/commons"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// Chat is a side pane showing the chat of the session above an input line.
+type Chat struct {
+	Width   int
+	Visible bool
+	Focused bool
+	Unread  int
+
+	Entries []commons.ChatEntry
+	Input   []rune
+
+	// Scroll is the number of lines the view is scrolled up from the
+	// newest message.
+	Scroll int
+}
+
+func NewChat(width int) *Chat {
+	return &Chat{Width: width}
+}
+
+// Add appends messages to the chat, counting them as unread while the pane
+// is hidden.
+func (c *Chat) Add(entries ...commons.ChatEntry) {
+	c.Entries = append(c.Entries, entries...)
+	if !c.Visible {
+		c.Unread += len(entries)
+	}
+}
+
+func (c *Chat) Show() {
+	c.Visible = true
+	c.Unread = 0
+}
+
+func (c *Chat) Insert(r rune) {
+	c.Input = append(c.Input, r)
+}
+
+func (c *Chat) Backspace() {
+	if len(c.Input) > 0 {
+		c.Input = c.Input[:len(c.Input)-1]
+	}
+}
+
+// ScrollBy scrolls the view d lines back in history, or forward if d is
+// negative.
+func (c *Chat) ScrollBy(d int) {
+	c.Scroll += d
+	if c.Scroll < 0 {
+		c.Scroll = 0
+	}
+}
+
+// chatLine is a row of the wrapped chat.
+type chatLine struct {
+	text []rune
+	fg   termbox.Attribute
+}
+
+// lines wraps the messages to width columns.
+func (c *Chat) lines(width int, users []string) []chatLine {
+	var lines []chatLine
+	for _, entry := range c.Entries {
+		header := entry.Time.Local().Format("15:04") + " " + entry.Username
+		lines = append(lines, chatLine{text: []rune(header), fg: GetColorForUsername(entry.Username, users) | termbox.AttrBold})
+
+		for _, line := range wrap(entry.Text, width-2) {
+			lines = append(lines, chatLine{text: []rune(line), fg: termbox.ColorDefault})
+		}
+	}
+	return lines
+}
+
+// Draw renders the chat in the column range [x, x+Width) and rows [y, y+h).
+// The first column holds the border to the editor, the last row the input.
+func (c *Chat) Draw(x, y, h int, users []string) {
+	for row := 0; row < h; row++ {
+		termbox.SetCell(x, y+row, '│', termbox.ColorDarkGray, termbox.ColorDefault)
+	}
+
+	x++
+	width := c.Width - 1
+
+	put := func(col, row int, text []rune, fg, bg termbox.Attribute) int {
+		for _, r := range text {
+			if col >= x+width {
+				break
+			}
+			termbox.SetCell(col, row, r, fg, bg)
+			col += runewidth.RuneWidth(r)
+		}
+		return col
+	}
+
+	// title
+	title := " Chat "
+	if c.Scroll > 0 {
+		title = " Chat (scrolled) "
+	}
+	put(x, y, []rune(title), termbox.ColorDefault|termbox.AttrBold, termbox.ColorDefault)
+
+	// messages, newest at the bottom
+	lines := c.lines(width, users)
+	rows := h - 2
+	if c.Scroll > len(lines)-rows {
+		c.Scroll = max(len(lines)-rows, 0)
+	}
+	end := len(lines) - c.Scroll
+	start := max(end-rows, 0)
+	for i, line := range lines[start:end] {
+		put(x+1, y+1+i, line.text, line.fg, termbox.ColorDefault)
+	}
+
+	// input line
+	col := put(x, y+h-1, []rune("> "), termbox.ColorDefault|termbox.AttrBold, termbox.ColorDefault)
+	input := c.Input
+	for runewidth.StringWidth(string(input)) > width-3 {
+		input = input[1:]
+	}
+	col = put(col, y+h-1, input, termbox.ColorDefault, termbox.ColorDefault)
+	if c.Focused {
+		termbox.SetCursor(col, y+h-1)
+	}
+}
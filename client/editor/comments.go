@@ -0,0 +1,152 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+
+	"diploma/crdt"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// CommentList is a side pane listing the comments of a document.
+type CommentList struct {
+	Width   int
+	Visible bool
+	Focused bool
+
+	// ShowResolved also lists resolved comments.
+	ShowResolved bool
+
+	Items    []CommentItem
+	selected int
+}
+
+// CommentItem is a comment along with the line it starts on. Line is 0 if
+// the commented text was deleted.
+type CommentItem struct {
+	crdt.Comment
+	Line int
+}
+
+func NewCommentList(width int) *CommentList {
+	return &CommentList{Width: width}
+}
+
+// SetItems replaces the listed comments, keeping the selected one where
+// possible.
+func (l *CommentList) SetItems(items []CommentItem) {
+	selected := l.Selected()
+
+	l.Items = l.Items[:0]
+	for _, item := range items {
+		if !item.Resolved || l.ShowResolved {
+			l.Items = append(l.Items, item)
+		}
+	}
+
+	l.selected = 0
+	for i, item := range l.Items {
+		if selected != nil && item.ID == selected.ID {
+			l.selected = i
+		}
+	}
+}
+
+// Selected returns the selected comment, or nil if there are none.
+func (l *CommentList) Selected() *CommentItem {
+	if l.selected < 0 || l.selected >= len(l.Items) {
+		return nil
+	}
+	return &l.Items[l.selected]
+}
+
+func (l *CommentList) Move(d int) {
+	l.selected += d
+	if l.selected >= len(l.Items) {
+		l.selected = len(l.Items) - 1
+	}
+	if l.selected < 0 {
+		l.selected = 0
+	}
+}
+
+// Draw renders the list in the column range [x, x+Width) and rows
+// [y, y+h). The first column holds the border to the editor.
+func (l *CommentList) Draw(x, y, h int, users []string) {
+	for row := 0; row < h; row++ {
+		termbox.SetCell(x, y+row, '│', termbox.ColorDarkGray, termbox.ColorDefault)
+	}
+
+	x++
+	width := l.Width - 1
+
+	put := func(col, row int, text string, fg, bg termbox.Attribute) {
+		for _, r := range text {
+			if col >= x+width {
+				break
+			}
+			termbox.SetCell(col, row, r, fg, bg)
+			col += runewidth.RuneWidth(r)
+		}
+	}
+
+	title := fmt.Sprintf(" Comments (%d) ", len(l.Items))
+	put(x, y, title, termbox.ColorDefault|termbox.AttrBold, termbox.ColorDefault)
+
+	row := y + 1
+	for i, item := range l.Items {
+		if row >= y+h {
+			break
+		}
+
+		fg, bg := GetColorForUsername(item.Author, users)|termbox.AttrBold, termbox.ColorDefault
+		if i == l.selected && l.Focused {
+			fg, bg = termbox.ColorBlack|termbox.AttrBold, termbox.ColorCyan
+		}
+
+		where := "deleted text"
+		if item.Line > 0 {
+			where = fmt.Sprintf("line %d", item.Line)
+		}
+		header := fmt.Sprintf("%s · %s", item.Author, where)
+		if item.Resolved {
+			header += " ✓"
+		}
+		put(x, row, header+strings.Repeat(" ", width), fg, bg)
+		row++
+
+		textFg := termbox.ColorDefault
+		if item.Resolved {
+			textFg = termbox.ColorDarkGray
+		}
+		for _, line := range wrap(item.Text, width-2) {
+			if row >= y+h {
+				break
+			}
+			put(x+1, row, line, textFg, termbox.ColorDefault)
+			row++
+		}
+	}
+}
+
+// wrap splits text into lines of at most width columns.
+func wrap(text string, width int) []string {
+	var lines []string
+	var line []rune
+	w := 0
+	for _, r := range text {
+		rw := runewidth.RuneWidth(r)
+		if r == '\n' || w+rw > width {
+			lines = append(lines, string(line))
+			line, w = nil, 0
+			if r == '\n' {
+				continue
+			}
+		}
+		line = append(line, r)
+		w += rw
+	}
+	return append(lines, string(line))
+}
@@ -0,0 +1,105 @@
+package editor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// DiffView takes the whole screen to list snapshots or show a unified diff
+// between two of them.
+type DiffView struct {
+	Title string
+	Lines []string
+
+	// Selectable views have a selected line, the others only scroll.
+	Selectable bool
+	Selected   int
+
+	// Marked is the line marked as the base of a diff, or -1.
+	Marked int
+
+	top int
+}
+
+func NewDiffView(title string, lines []string, selectable bool) *DiffView {
+	return &DiffView{Title: title, Lines: lines, Selectable: selectable, Marked: -1}
+}
+
+// Move moves the selection, or scrolls if nothing can be selected.
+func (v *DiffView) Move(d int) {
+	if v.Selectable {
+		v.Selected = max(0, min(v.Selected+d, len(v.Lines)-1))
+	} else {
+		v.top = max(0, min(v.top+d, len(v.Lines)-1))
+	}
+}
+
+// Draw renders the view in the rectangle of size w×h at (x, y). The title
+// goes on the first row, the keys on the last one.
+func (v *DiffView) Draw(x, y, w, h int, keys string) {
+	put := func(col, row int, text string, fg, bg termbox.Attribute) {
+		for _, r := range text {
+			if col >= x+w {
+				break
+			}
+			termbox.SetCell(col, row, r, fg, bg)
+			col += runewidth.RuneWidth(r)
+		}
+	}
+
+	put(x, y, " "+v.Title+strings.Repeat(" ", w), termbox.ColorBlack|termbox.AttrBold, termbox.ColorCyan)
+
+	rows := h - 2
+	if v.Selectable {
+		if v.Selected < v.top {
+			v.top = v.Selected
+		}
+		if v.Selected >= v.top+rows {
+			v.top = v.Selected - rows + 1
+		}
+	}
+
+	for i := 0; i < rows && v.top+i < len(v.Lines); i++ {
+		n := v.top + i
+		line := v.Lines[n]
+
+		fg, bg := diffColor(line), termbox.ColorDefault
+		if v.Selectable {
+			fg = termbox.ColorDefault
+			if n == v.Marked {
+				line = "* " + line
+			} else {
+				line = "  " + line
+			}
+			if n == v.Selected {
+				fg, bg = termbox.ColorBlack|termbox.AttrBold, termbox.ColorCyan
+				line += strings.Repeat(" ", w)
+			}
+		}
+		put(x, y+1+i, line, fg, bg)
+	}
+
+	status := keys
+	if !v.Selectable && len(v.Lines) > rows {
+		status = fmt.Sprintf("%s  %d/%d", keys, v.top+1, len(v.Lines))
+	}
+	put(x, y+h-1, status, termbox.ColorDarkGray, termbox.ColorDefault)
+}
+
+// diffColor colors the lines of a unified diff.
+func diffColor(line string) termbox.Attribute {
+	switch {
+	case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		return termbox.ColorDefault | termbox.AttrBold
+	case strings.HasPrefix(line, "@@"):
+		return termbox.ColorCyan
+	case strings.HasPrefix(line, "+"):
+		return termbox.ColorGreen
+	case strings.HasPrefix(line, "-"):
+		return termbox.ColorRed
+	}
+	return termbox.ColorDefault
+}
@@ -2,8 +2,8 @@ package editor
 
 import (
 	"fmt"
-	"hash/fnv"
 	"sort"
+	"strconv"
 	"sync"
 
 	"github.com/mattn/go-runewidth"
@@ -13,6 +13,10 @@ import (
 type EditorConfig struct {
 	ScrollEnabled bool
 	Username      string
+	FileName      string
+	Lang          string
+	LineNumbers   bool
+	Wrap          bool
 }
 
 type Editor struct {
@@ -22,6 +26,8 @@ type Editor struct {
 	Height int
 	ColOff int
 	RowOff int
+	Left   int
+	Top    int
 
 	ShowMsg    bool
 	StatusMsg  string
@@ -32,6 +38,30 @@ type Editor struct {
 	Users    []string
 	UsersPos map[string]CursorColPos
 
+	Highlighter *Highlighter
+	FileName    string
+
+	// Modified is set by local edits since the document was saved,
+	// RemoteModified by the edits of other users.
+	Modified       bool
+	RemoteModified bool
+
+	LineNumbers bool
+	Wrap        bool
+
+	Mode      string
+	Search    *Search
+	Prompt    *Prompt
+	Selection *Selection
+
+	// Annotations are the ranges of the text with open comments.
+	Annotations []Match
+
+	// Blame tints the text by the user who wrote it. Authors holds the
+	// author of every rune, empty for text loaded from a file.
+	Blame   bool
+	Authors []string
+
 	ScrollEnabled bool
 	IsConnected   bool
 	DrawChan      chan int
@@ -43,6 +73,9 @@ type CursorColPos struct {
 	Col termbox.Attribute
 }
 
+// encoding is the encoding of the edited text, shown in the status bar.
+const encoding = "UTF-8"
+
 var userColors = []termbox.Attribute{
 	termbox.ColorGreen,
 	termbox.ColorYellow,
@@ -79,6 +112,10 @@ func NewEditor(conf EditorConfig) *Editor {
 		DrawChan:      make(chan int, 10000),
 		UsersPos:      make(map[string]CursorColPos),
 		Username:      conf.Username,
+		Highlighter:   NewHighlighter(conf.Lang, conf.FileName),
+		FileName:      conf.FileName,
+		LineNumbers:   conf.LineNumbers,
+		Wrap:          conf.Wrap,
 	}
 }
 
@@ -93,6 +130,12 @@ func (e *Editor) GetText() []rune {
 func (e *Editor) SetText(text string) {
 	e.mu.Lock()
 	e.Text = []rune(text)
+	if e.Highlighter != nil {
+		e.Highlighter.Update(e.Text)
+	}
+	if e.Search != nil {
+		e.Search.update(e.Text)
+	}
 	e.mu.Unlock()
 }
 
@@ -125,6 +168,12 @@ func (e *Editor) SetSize(w, h int) {
 	e.Height = h
 }
 
+// SetPosition moves the top left corner of the editor on the screen.
+func (e *Editor) SetPosition(left, top int) {
+	e.Left = left
+	e.Top = top
+}
+
 func (e *Editor) GetRowOff() int {
 	return e.RowOff
 }
@@ -141,6 +190,42 @@ func (e *Editor) IncColOff(inc int) {
 	e.ColOff += inc
 }
 
+func (e *Editor) ToggleLineNumbers() {
+	e.LineNumbers = !e.LineNumbers
+}
+
+func (e *Editor) ToggleWrap() {
+	e.Wrap = !e.Wrap
+	e.ColOff = 0
+}
+
+// LineCount returns the number of lines in the text.
+func (e *Editor) LineCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	lines := 1
+	for _, r := range e.Text {
+		if r == '\n' {
+			lines++
+		}
+	}
+	return lines
+}
+
+// GutterWidth returns the number of columns taken by the line number gutter.
+func (e *Editor) GutterWidth() int {
+	if !e.LineNumbers {
+		return 0
+	}
+	return len(strconv.Itoa(e.LineCount())) + 1
+}
+
+// textWidth returns the number of columns available for text.
+func (e *Editor) textWidth() int {
+	return e.GetWidth() - e.GutterWidth()
+}
+
 // ////////////////////////////////////////////////////////////////////
 // ////////////////////////////////////////////////////////////////////
 func (e *Editor) SendDraw() {
@@ -149,25 +234,31 @@ func (e *Editor) SendDraw() {
 
 func (e *Editor) Draw() {
 	_ = termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+	e.Render()
+	termbox.Flush()
+}
 
+// Render draws the editor into its rectangle of the screen without
+// clearing or flushing it, so several panes can share the screen.
+func (e *Editor) Render() {
 	e.mu.RLock()
 	cursor := e.Cursor
 	e.mu.RUnlock()
 
 	cx, cy := e.calcXY(cursor)
 
-	// draw cursor x position relative to row offset
-	if cx-e.GetColOff() > 0 {
-		cx -= e.GetColOff()
-	}
+	// draw cursor position relative to the window offset, hiding it when
+	// the window is scrolled away from it
+	cx -= e.GetColOff()
+	cy -= e.GetRowOff()
 
-	// draw cursor y position relative to row offset
-	if cy-e.GetRowOff() > 0 {
-		cy -= e.GetRowOff()
+	gutter := e.GutterWidth()
+	if cx < 1 || cy < 1 || cy > e.GetHeight()-1 {
+		termbox.HideCursor()
+	} else {
+		e.setCursor(cx-1+gutter, cy-1)
 	}
 
-	termbox.SetCursor(cx-1, cy-1)
-
 	// find the starting and ending row of the termbox window.
 	yStart := e.GetRowOff()
 	yEnd := yStart + e.GetHeight() - 1 // -1 for StatusBar
@@ -175,36 +266,154 @@ func (e *Editor) Draw() {
 	// find the starting ending column of the termbox window.
 	xStart := e.GetColOff()
 
-	x, y := 0, 0
-	for i := 0; i < len(e.Text) && y < yEnd; i++ {
+	// visual row on which each line starts, used by the gutter
+	lineRows := []int{0}
+
+	selStart, selEnd, _ := e.SelectionRange()
+
+	e.StatusMu.Lock()
+	users := e.Users
+	e.StatusMu.Unlock()
+
+	e.layout(func(i, x, y int) bool {
+		if i == len(e.Text) || y-1 >= yEnd {
+			return false
+		}
+
 		if e.Text[i] == rune('\n') {
-			x = 0
-			y++
-		} else {
+			lineRows = append(lineRows, y)
+			return true
+		}
+
+		bg := termbox.ColorDefault
+		for _, user := range e.UsersPos {
+			if user.Pos == i {
+				bg = user.Col
+				break
+			}
+		}
+
+		fg := termbox.ColorDefault
+		if e.Highlighter != nil {
+			fg = e.Highlighter.Color(i)
+		}
+
+		if e.Blame && i < len(e.Authors) && e.Authors[i] != "" {
+			fg = GetColorForUsername(e.Authors[i], users)
+		}
 
-			bg := termbox.ColorDefault
-			for _, user := range e.UsersPos {
-				if user.Pos == i {
-					bg = user.Col
-					break
+		if e.Search != nil {
+			if m := e.Search.matchAt(i); m != -1 {
+				fg = termbox.ColorBlack
+				bg = termbox.ColorYellow
+				if m == e.Search.Current {
+					bg = termbox.ColorLightRed
 				}
 			}
+		}
 
-			// Set cell content. setX and setY account for the window offset.
-			setY := y - yStart
-			setX := x - xStart
-			termbox.SetCell(setX, setY, e.Text[i], termbox.ColorDefault, bg)
+		for _, a := range e.Annotations {
+			if i >= a.Start && i < a.End {
+				fg |= termbox.AttrUnderline
+				break
+			}
+		}
 
-			// Update x by rune's width.
-			x = x + runewidth.RuneWidth(e.Text[i])
+		if i >= selStart && i < selEnd {
+			fg |= termbox.AttrReverse
 		}
-	}
+
+		// Set cell content. setX and setY account for the window offset.
+		setY := y - 1 - yStart
+		setX := x - 1 - xStart + gutter
+		if setX >= gutter {
+			e.setCell(setX, setY, e.Text[i], fg, bg)
+		}
+		return true
+	})
+
+	e.drawGutter(gutter, e.cursorLine(cursor), lineRows)
 
 	e.DrawStatusBar()
-	termbox.Flush()
+}
+
+// setCell sets a cell relative to the editor, clipped to its rectangle.
+func (e *Editor) setCell(x, y int, r rune, fg, bg termbox.Attribute) {
+	if x < 0 || y < 0 || x >= e.Width || y >= e.Height {
+		return
+	}
+	termbox.SetCell(e.Left+x, e.Top+y, r, fg, bg)
+}
+
+func (e *Editor) setCursor(x, y int) {
+	termbox.SetCursor(e.Left+x, e.Top+y)
+}
+
+// drawGutter renders the numbers of the visible lines. lineRows holds the
+// visual row each line starts on. The line holding the cursor is highlighted
+// and lines where comments start are marked.
+func (e *Editor) drawGutter(width, cursorLine int, lineRows []int) {
+	if width == 0 {
+		return
+	}
+
+	commented := make(map[int]bool)
+	for _, a := range e.Annotations {
+		commented[e.cursorLine(a.Start)] = true
+	}
+
+	for line, row := range lineRows {
+		row -= e.GetRowOff()
+		if row < 0 {
+			continue
+		}
+		if row >= e.GetHeight()-1 {
+			break
+		}
+
+		fg := termbox.ColorDarkGray
+		if line == cursorLine {
+			fg = termbox.ColorYellow | termbox.AttrBold
+		}
+
+		num := fmt.Sprintf("%*d", width-1, line+1)
+		for i, r := range num {
+			e.setCell(i, row, r, fg, termbox.ColorDefault)
+		}
+		if commented[line] {
+			e.setCell(width-1, row, '•', termbox.ColorMagenta, termbox.ColorDefault)
+		}
+	}
+}
+
+// lineStart returns the index of the first rune on the line of index.
+func (e *Editor) lineStart(index int) int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return lineStart(e.Text, index)
+}
+
+// cursorLine returns the 0-based line of the text index.
+func (e *Editor) cursorLine(index int) int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	line := 0
+	for i := 0; i < index && i < len(e.Text); i++ {
+		if e.Text[i] == '\n' {
+			line++
+		}
+	}
+	return line
 }
 
 func (e *Editor) DrawStatusBar() {
+	if e.Prompt != nil {
+		e.DrawPrompt()
+		return
+	}
+
 	e.StatusMu.Lock()
 	showMsg := e.ShowMsg
 	e.StatusMu.Unlock()
@@ -216,9 +425,9 @@ func (e *Editor) DrawStatusBar() {
 
 	// Render connection-indicator
 	if e.IsConnected {
-		termbox.SetBg(e.Width-1, e.Height-1, termbox.ColorGreen)
+		termbox.SetBg(e.Left+e.Width-1, e.Top+e.Height-1, termbox.ColorGreen)
 	} else {
-		termbox.SetBg(e.Width-1, e.Height-1, termbox.ColorRed)
+		termbox.SetBg(e.Left+e.Width-1, e.Top+e.Height-1, termbox.ColorRed)
 	}
 }
 
@@ -227,7 +436,7 @@ func (e *Editor) DrawStatusMsg() {
 	statusMsg := e.StatusMsg
 	e.StatusMu.Unlock()
 	for i, r := range []rune(statusMsg) {
-		termbox.SetCell(i, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
+		e.setCell(i, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
 	}
 }
 
@@ -236,20 +445,28 @@ func (e *Editor) DrawInfoBar() {
 	users := e.Users
 	e.StatusMu.Unlock()
 
-	e.mu.RLock()
-	length := len(e.Text)
-	e.mu.RUnlock()
-
 	x := 0
+	name := e.FileName
+	if name == "" {
+		name = "[No Name]"
+	}
+	if e.Modified {
+		name += " [+]"
+	} else if e.RemoteModified {
+		name += " [~]"
+	}
+	for _, r := range name + "  " {
+		e.setCell(x, e.Height-1, r, termbox.ColorDefault|termbox.AttrBold, termbox.ColorDefault)
+		x++
+	}
+
 	for _, user := range users {
 		for _, r := range user {
-			hash := fnv.New32a()
-			hash.Write([]byte(user))
 			color := GetColorForUsername(user, users)
-			termbox.SetCell(x, e.Height-1, r, color, termbox.ColorDefault)
+			e.setCell(x, e.Height-1, r, color, termbox.ColorDefault)
 			x++
 		}
-		termbox.SetCell(x, e.Height-1, ' ', termbox.ColorDefault, termbox.ColorDefault)
+		e.setCell(x, e.Height-1, ' ', termbox.ColorDefault, termbox.ColorDefault)
 		x++
 	}
 
@@ -257,12 +474,33 @@ func (e *Editor) DrawInfoBar() {
 	cursor := e.Cursor
 	e.mu.RUnlock()
 
-	cx, cy := e.calcXY(cursor)
-	debugInfo := fmt.Sprintf(" x=%d, y=%d, cursor=%d, len(text)=%d", cx, cy, e.Cursor, length)
+	line, col := e.cursorLine(cursor)+1, cursor-e.lineStart(cursor)+1
 
-	for _, r := range debugInfo {
-		termbox.SetCell(x, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
-		x++
+	lang := "Plain Text"
+	if e.Highlighter != nil {
+		lang = e.Highlighter.Name()
+	}
+
+	conn := "offline"
+	if e.IsConnected {
+		conn = "online"
+	}
+
+	// right-aligned, leaving the last cell for the connection indicator
+	info := fmt.Sprintf(" Ln %d, Col %d | %s | %s | %s ", line, col, lang, encoding, conn)
+	if e.Mode != "" {
+		info = " " + e.Mode + " |" + info
+	}
+	if e.Blame && cursor < len(e.Authors) && e.Authors[cursor] != "" {
+		info = " by " + e.Authors[cursor] + " |" + info
+	}
+	start := e.Width - 1 - runewidth.StringWidth(info)
+	if start < x {
+		start = x
+	}
+	for _, r := range info {
+		e.setCell(start, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
+		start += runewidth.RuneWidth(r)
 	}
 }
 
@@ -276,11 +514,11 @@ func (e *Editor) MoveCursor(x, y int) {
 	newCursor := e.Cursor + x
 
 	// vertically.
-	if y > 0 {
+	if y != 0 && e.Wrap {
+		newCursor = e.calcCursorWrapped(y)
+	} else if y > 0 {
 		newCursor = e.calcCursorDown()
-	}
-
-	if y < 0 {
+	} else if y < 0 {
 		newCursor = e.calcCursorUp()
 	}
 
@@ -300,8 +538,14 @@ func (e *Editor) MoveCursor(x, y int) {
 			e.IncRowOff(cy - rowEnd)
 		}
 
+		// soft wrapped text never scrolls sideways
+		if e.Wrap {
+			e.ColOff = 0
+			cx = 1
+		}
+
 		colStart := e.GetColOff()
-		colEnd := e.GetColOff() + e.GetWidth()
+		colEnd := e.GetColOff() + e.textWidth()
 
 		// scroll left
 		if cx <= colStart {
@@ -422,24 +666,71 @@ func (e *Editor) calcXY(index int) (int, int) {
 		return x, y
 	}
 
-	e.mu.RLock()
-	length := len(e.Text)
-	e.mu.RUnlock()
+	e.layout(func(i, cx, cy int) bool {
+		x, y = cx, cy
+		return i < index
+	})
+	return x, y
+}
+
+// calcCursorWrapped returns the index dy visual rows above or below the
+// cursor, keeping its column where possible.
+func (e *Editor) calcCursorWrapped(dy int) int {
+	x, y := e.calcXY(e.Cursor)
+	return e.indexAt(x, y+dy)
+}
+
+// indexAt returns the text index whose cursor position is closest to the
+// visual cell (x, y), both 1-based and relative to the start of the text.
+func (e *Editor) indexAt(x, y int) int {
+	if y < 1 {
+		return 0
+	}
 
-	if index > length {
-		index = length
+	idx, last := -1, 0
+	e.layout(func(i, cx, cy int) bool {
+		if cy > y {
+			return false
+		}
+		last = i
+		if cy == y && (idx == -1 || cx <= x) {
+			idx = i
+		}
+		return true
+	})
+
+	if idx == -1 {
+		return last
 	}
+	return idx
+}
+
+// layout calls fn with the 1-based visual position of every cursor index of
+// the text, including the one past the last rune, until fn returns false.
+// With soft wrap on, runes that don't fit the text width move to the next
+// row; the last column is kept free for the cursor.
+func (e *Editor) layout(fn func(i, x, y int) bool) {
+	width := e.textWidth()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 
-	for i := 0; i < index; i++ {
-		e.mu.RLock()
-		r := e.Text[i]
-		e.mu.RUnlock()
-		if r == rune('\n') {
+	x, y := 1, 1
+	for i := 0; i <= len(e.Text); i++ {
+		if e.Wrap && i < len(e.Text) && e.Text[i] != '\n' && x > 1 && x+runewidth.RuneWidth(e.Text[i]) > width {
+			x = 1
+			y++
+		}
+
+		if !fn(i, x, y) || i == len(e.Text) {
+			return
+		}
+
+		if e.Text[i] == rune('\n') {
 			x = 1
 			y++
 		} else {
-			x = x + runewidth.RuneWidth(r)
+			x = x + runewidth.RuneWidth(e.Text[i])
 		}
 	}
-	return x, y
 }
@@ -0,0 +1,149 @@
+package editor
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// FileTree is a side pane listing the documents of the session as a
+// directory tree.
+type FileTree struct {
+	Width   int
+	Visible bool
+	Focused bool
+	Current string
+
+	rows     []treeRow
+	selected int
+}
+
+type treeRow struct {
+	label string
+	depth int
+	path  string
+	dir   bool
+}
+
+// untitled is the label of the document without a path.
+const untitled = "[No Name]"
+
+func NewFileTree(width int) *FileTree {
+	return &FileTree{Width: width}
+}
+
+// SetPaths rebuilds the tree from the document paths, keeping the selected
+// path where possible.
+func (t *FileTree) SetPaths(paths []string) {
+	selected := t.Selected()
+
+	sorted := make([]string, len(paths))
+	copy(sorted, paths)
+	sort.Strings(sorted)
+
+	t.rows = t.rows[:0]
+	var dirs []string
+	for _, path := range sorted {
+		parts := strings.Split(path, "/")
+
+		// number of directories shared with the previous path
+		common := 0
+		for common < len(dirs) && common < len(parts)-1 && dirs[common] == parts[common] {
+			common++
+		}
+		dirs = dirs[:common]
+
+		for _, dir := range parts[common : len(parts)-1] {
+			t.rows = append(t.rows, treeRow{label: dir + "/", depth: len(dirs), dir: true})
+			dirs = append(dirs, dir)
+		}
+
+		label := parts[len(parts)-1]
+		if path == "" {
+			label = untitled
+		}
+		t.rows = append(t.rows, treeRow{label: label, depth: len(dirs), path: path})
+	}
+
+	t.selected = 0
+	if len(t.rows) > 0 && t.rows[0].dir {
+		t.Move(1)
+	}
+	t.Select(selected)
+}
+
+// Select moves the selection to the row of path.
+func (t *FileTree) Select(path string) {
+	for i, row := range t.rows {
+		if row.path == path && !row.dir {
+			t.selected = i
+			return
+		}
+	}
+}
+
+// Selected returns the path of the selected document.
+func (t *FileTree) Selected() string {
+	if t.selected < 0 || t.selected >= len(t.rows) {
+		return ""
+	}
+	return t.rows[t.selected].path
+}
+
+// Move moves the selection by d documents, skipping directory rows.
+func (t *FileTree) Move(d int) {
+	step := 1
+	if d < 0 {
+		step, d = -1, -d
+	}
+
+	for ; d > 0; d-- {
+		for i := t.selected + step; i >= 0 && i < len(t.rows); i += step {
+			if !t.rows[i].dir {
+				t.selected = i
+				break
+			}
+		}
+	}
+}
+
+// Draw renders the tree in the column range [x, x+Width) and rows [y, y+h).
+// The last column holds the border to the editor.
+func (t *FileTree) Draw(x, y, h int) {
+	first := 0
+	if t.selected >= h {
+		first = t.selected - h + 1
+	}
+
+	for row := 0; row < h; row++ {
+		termbox.SetCell(x+t.Width-1, y+row, '│', termbox.ColorDarkGray, termbox.ColorDefault)
+
+		i := first + row
+		if i >= len(t.rows) {
+			continue
+		}
+
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		r := t.rows[i]
+		if r.dir {
+			fg = termbox.ColorBlue | termbox.AttrBold
+		}
+		if r.path == t.Current && !r.dir {
+			fg |= termbox.AttrBold
+		}
+		if i == t.selected && t.Focused {
+			fg, bg = termbox.ColorBlack, termbox.ColorCyan
+		}
+
+		col := x + r.depth*2
+		for _, ch := range r.label {
+			if col >= x+t.Width-1 {
+				break
+			}
+			termbox.SetCell(col, y+row, ch, fg, bg)
+			col += runewidth.RuneWidth(ch)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package editor
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/nsf/termbox-go"
+)
+
+// Highlighter keeps the token type of every rune in the editor text.
+// On each update only the region around the change is re-lexed.
+type Highlighter struct {
+	lexer chroma.Lexer
+	text  []rune
+	types []chroma.TokenType
+}
+
+// tokenColors maps token types to colours. Lookups try the exact type first,
+// then its subcategory and finally its category.
+var tokenColors = map[chroma.TokenType]termbox.Attribute{
+	chroma.Comment:         termbox.ColorDarkGray,
+	chroma.Keyword:         termbox.ColorBlue | termbox.AttrBold,
+	chroma.KeywordType:     termbox.ColorCyan,
+	chroma.NameFunction:    termbox.ColorYellow,
+	chroma.NameBuiltin:     termbox.ColorCyan,
+	chroma.NameTag:         termbox.ColorBlue,
+	chroma.NameAttribute:   termbox.ColorYellow,
+	chroma.Literal:         termbox.ColorMagenta,
+	chroma.LiteralString:   termbox.ColorGreen,
+	chroma.Operator:        termbox.ColorLightRed,
+	chroma.GenericDeleted:  termbox.ColorRed,
+	chroma.GenericInserted: termbox.ColorGreen,
+	chroma.GenericHeading:  termbox.ColorBlue | termbox.AttrBold,
+	chroma.Error:           termbox.ColorRed,
+}
+
+// NewHighlighter returns a highlighter for the language name or, if lang is
+// empty, for the extension of fileName. It returns nil for plain text.
+func NewHighlighter(lang, fileName string) *Highlighter {
+	var lexer chroma.Lexer
+	if lang != "" {
+		lexer = lexers.Get(lang)
+	} else if fileName != "" {
+		lexer = lexers.Match(filepath.Base(fileName))
+	}
+
+	if lexer == nil || lexer == lexers.Fallback {
+		return nil
+	}
+
+	return &Highlighter{lexer: chroma.Coalesce(lexer)}
+}
+
+// Name returns the name of the highlighted language.
+func (h *Highlighter) Name() string {
+	return h.lexer.Config().Name
+}
+
+// Color returns the foreground colour of the rune at index i.
+func (h *Highlighter) Color(i int) termbox.Attribute {
+	if i < 0 || i >= len(h.types) {
+		return termbox.ColorDefault
+	}
+
+	t := h.types[i]
+	for _, key := range []chroma.TokenType{t, t.SubCategory(), t.Category()} {
+		if color, ok := tokenColors[key]; ok {
+			return color
+		}
+	}
+	return termbox.ColorDefault
+}
+
+// Update re-lexes the text. Only the lines around the changed region are
+// re-tokenised, or the text up to it for edits that add or remove a comment
+// or string delimiter; lexing stops as soon as a line after the change produces the same
+// tokens it had before.
+func (h *Highlighter) Update(text []rune) {
+	oldText, oldTypes := h.text, h.types
+
+	prefix := 0
+	for prefix < len(oldText) && prefix < len(text) && oldText[prefix] == text[prefix] {
+		prefix++
+	}
+	if prefix == len(oldText) && prefix == len(text) {
+		return
+	}
+
+	suffix := 0
+	for suffix < len(oldText)-prefix && suffix < len(text)-prefix &&
+		oldText[len(oldText)-1-suffix] == text[len(text)-1-suffix] {
+		suffix++
+	}
+
+	// Restart from a line that does not begin inside a comment or string,
+	// since the lexer state at such a line is unknown. Lexers also look past
+	// the end of a line, to tell a function name by the parenthesis after
+	// it, so the last line with text before the change is re-lexed too.
+	start := lineStart(text, prefix)
+	for start > 0 {
+		start = lineStart(text, start-1)
+		if !blank(text[start:prefix]) {
+			break
+		}
+	}
+	for start > 0 && start < len(oldTypes) && isMultiline(oldTypes[start]) {
+		start = lineStart(text, start-1)
+	}
+
+	// A "/*" or quote above with no end after it isn't lexed as a comment
+	// or string, until an edit adds the end. Edits that may do that re-lex
+	// from the top.
+	if delimits(oldText[max(prefix-1, 0):min(len(oldText)-suffix+1, len(oldText))]) ||
+		delimits(text[max(prefix-1, 0):min(len(text)-suffix+1, len(text))]) {
+		start = 0
+	}
+
+	delta := len(text) - len(oldText)
+	changeEnd := len(text) - suffix
+
+	types := make([]chroma.TokenType, 0, len(text)-start)
+	it, err := h.lexer.Tokenise(nil, string(text[start:]))
+	if err != nil {
+		h.text = append(h.text[:0:0], text...)
+		h.types = make([]chroma.TokenType, len(text))
+		return
+	}
+
+	pos, line := start, start
+	for tok := it(); tok != chroma.EOF; tok = it() {
+		for _, r := range tok.Value {
+			if pos >= len(text) {
+				break
+			}
+			// lexers may turn CRLF into LF, the CR takes the type of its LF
+			if r == '\n' && text[pos] == '\r' && pos+1 < len(text) && text[pos+1] == '\n' {
+				types = append(types, tok.Type)
+				pos++
+			}
+			types = append(types, tok.Type)
+			pos++
+
+			if text[pos-1] != '\n' {
+				continue
+			}
+
+			// a whole line after the change is lexed, compare it with the cache
+			if line >= changeEnd && sameTypes(types[line-start:], oldTypes, line-delta) {
+				h.types = append(append(append([]chroma.TokenType{}, oldTypes[:start]...), types...), oldTypes[pos-delta:]...)
+				h.text = append(h.text[:0:0], text...)
+				return
+			}
+			line = pos
+		}
+	}
+
+	for pos < len(text) {
+		types = append(types, chroma.Text)
+		pos++
+	}
+
+	h.types = append(append([]chroma.TokenType{}, oldTypes[:start]...), types...)
+	h.text = append(h.text[:0:0], text...)
+}
+
+func sameTypes(types, old []chroma.TokenType, offset int) bool {
+	if offset < 0 || offset+len(types) > len(old) {
+		return false
+	}
+	for i, t := range types {
+		if old[offset+i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// delimits reports whether runes hold a delimiter of a comment or string.
+func delimits(runes []rune) bool {
+	s := string(runes)
+	return strings.ContainsAny(s, "\"'`") || strings.Contains(s, "/*") || strings.Contains(s, "*/") || strings.Contains(s, "//")
+}
+
+func blank(runes []rune) bool {
+	return strings.TrimSpace(string(runes)) == ""
+}
+
+func isMultiline(t chroma.TokenType) bool {
+	return t.InCategory(chroma.Comment) || t.InSubCategory(chroma.LiteralString)
+}
+
+func lineStart(text []rune, i int) int {
+	if i > len(text) {
+		i = len(text)
+	}
+	for i > 0 && text[i-1] != '\n' {
+		i--
+	}
+	return i
+}
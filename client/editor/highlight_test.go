@@ -0,0 +1,132 @@
+package editor
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/chroma/v2"
+)
+
+const goSource = `package main
+
+// main greets
+func main() {
+	s := "hello /* not a comment */"
+	/* a comment
+	   over two lines */
+	for i := 0; i < 3; i++ {
+		println(s, i)
+	}
+}
+`
+
+// lexAll returns the types of text lexed from scratch.
+func lexAll(text []rune) []chroma.TokenType {
+	h := NewHighlighter("go", "")
+	h.Update(text)
+	return h.types
+}
+
+func checkTypes(t *testing.T, text []rune, got, want []chroma.TokenType) {
+	t.Helper()
+	if len(got) != len(text) || len(want) != len(text) {
+		t.Fatalf("%d and %d types for %d runes", len(got), len(want), len(text))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("rune %d (%q) of %q: got %s, want %s", i, text[i], string(text), got[i], want[i])
+		}
+	}
+}
+
+// Edits re-lexed around the change give the types a full lex gives.
+func TestUpdateMatchesFullLex(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		source := goSource
+		if seed%2 == 1 {
+			source = strings.ReplaceAll(goSource, "\n", "\r\n")
+		}
+		r := rand.New(rand.NewSource(seed))
+		h := NewHighlighter("go", "")
+		text := []rune(source)
+		h.Update(text)
+
+		for i := 0; i < 300; i++ {
+			at := r.Intn(len(text) + 1)
+			if len(text) > 0 && r.Intn(3) == 0 {
+				end := at + r.Intn(4)
+				if at == len(text) {
+					at--
+				}
+				if end > len(text) {
+					end = len(text)
+				}
+				text = append(text[:at:at], text[end:]...)
+			} else {
+				inserts := []string{"/*", "*/", "\"", "\n", "\r\n", "x", "func ", "//", "/", "*", "->", "a[i] - 1", "]"}
+				insert := []rune(inserts[r.Intn(len(inserts))])
+				text = append(append(text[:at:at], insert...), text[at:]...)
+			}
+
+			h.Update(text)
+			checkTypes(t, text, h.types, lexAll(text))
+		}
+	}
+}
+
+// Every rune of a CRLF file gets the type of the same rune with LF endings.
+func TestUpdateCRLF(t *testing.T) {
+	lf := lexAll([]rune(goSource))
+
+	crlf := []rune(strings.ReplaceAll(goSource, "\n", "\r\n"))
+	var withoutCR []chroma.TokenType
+	for i, typ := range lexAll(crlf) {
+		if crlf[i] != '\r' {
+			withoutCR = append(withoutCR, typ)
+		}
+	}
+	checkTypes(t, []rune(goSource), withoutCR, lf)
+}
+
+// countingLexer counts the runes it's given to lex.
+type countingLexer struct {
+	chroma.Lexer
+	lexed int
+}
+
+func (l *countingLexer) Tokenise(options *chroma.TokeniseOptions, text string) (chroma.Iterator, error) {
+	l.lexed += len([]rune(text))
+	return l.Lexer.Tokenise(options, text)
+}
+
+// Operators that are no delimiters only re-lex from the changed line.
+func TestUpdateFromChangedLine(t *testing.T) {
+	h := NewHighlighter("go", "")
+	lexer := &countingLexer{Lexer: h.lexer}
+	h.lexer = lexer
+
+	text := []rune(strings.Repeat(goSource, 100))
+	h.Update(text)
+
+	for _, insert := range []string{"* ", "/ ", "- ", "> ", "] ", "x := a[i] * b / c - d"} {
+		at := len(text) - len("}\n")
+		text = append(append(text[:at:at], []rune(insert)...), text[at:]...)
+
+		lexer.lexed = 0
+		h.Update(text)
+		checkTypes(t, text, h.types, lexAll(text))
+		if lexer.lexed > 100 {
+			t.Errorf("inserting %q lexed %d of %d runes", insert, lexer.lexed, len(text))
+		}
+	}
+
+	// a delimiter may change everything after it
+	text = append([]rune("/*"), text...)
+	lexer.lexed = 0
+	h.Update(text)
+	checkTypes(t, text, h.types, lexAll(text))
+	if lexer.lexed != len(text) {
+		t.Errorf("adding a comment start lexed %d of %d runes", lexer.lexed, len(text))
+	}
+}
@@ -0,0 +1,116 @@
+package editor
+
+// Selection is a range of text selected by dragging the mouse. Anchor is
+// the index where the drag started, the other end is the cursor. An
+// inclusive selection also covers the rune under the cursor.
+type Selection struct {
+	Anchor    int
+	Inclusive bool
+}
+
+// scrollStep is the number of rows scrolled per mouse wheel event.
+const scrollStep = 3
+
+// CellIndex returns the text index under the screen cell (mx, my). It
+// returns false if the cell is outside the text area.
+func (e *Editor) CellIndex(mx, my int) (int, bool) {
+	mx -= e.Left
+	my -= e.Top
+	if my < 0 || my >= e.GetHeight()-1 || mx < e.GutterWidth() {
+		return 0, false
+	}
+
+	x := mx - e.GutterWidth() + e.GetColOff() + 1
+	y := my + e.GetRowOff() + 1
+	return e.indexAt(x, y), true
+}
+
+// Click moves the cursor to the screen cell (mx, my) and starts a new
+// selection there.
+func (e *Editor) Click(mx, my int) {
+	idx, ok := e.CellIndex(mx, my)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	e.Cursor = idx
+	e.mu.Unlock()
+	e.Selection = &Selection{Anchor: idx}
+}
+
+// Drag extends the selection to the screen cell (mx, my).
+func (e *Editor) Drag(mx, my int) {
+	if e.Selection == nil {
+		e.Click(mx, my)
+		return
+	}
+
+	// dragging past the window edges scrolls it
+	if my < e.Top {
+		e.Scroll(-1)
+		my = e.Top
+	} else if my >= e.Top+e.GetHeight()-1 {
+		e.Scroll(1)
+		my = e.Top + e.GetHeight() - 2
+	}
+
+	idx, ok := e.CellIndex(mx, my)
+	if !ok {
+		return
+	}
+
+	e.mu.Lock()
+	e.Cursor = idx
+	e.mu.Unlock()
+}
+
+// Release ends a drag. A click without movement leaves no selection.
+func (e *Editor) Release() {
+	if e.Selection != nil && e.Selection.Anchor == e.Cursor {
+		e.Selection = nil
+	}
+}
+
+func (e *Editor) ClearSelection() {
+	e.Selection = nil
+}
+
+// SelectionRange returns the selected range [start, end) of the text.
+func (e *Editor) SelectionRange() (int, int, bool) {
+	if e.Selection == nil {
+		return 0, 0, false
+	}
+
+	e.mu.RLock()
+	length := len(e.Text)
+	e.mu.RUnlock()
+
+	start, end := e.Selection.Anchor, e.Cursor
+	if start > end {
+		start, end = end, start
+	}
+	if e.Selection.Inclusive {
+		end++
+	}
+	if end > length {
+		end = length
+	}
+	if start >= end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// Scroll moves the window by dy rows without moving the cursor.
+func (e *Editor) Scroll(dy int) {
+	_, rows := e.calcXY(len(e.GetText()))
+
+	e.RowOff += dy * scrollStep
+	if e.RowOff > rows-1 {
+		e.RowOff = rows - 1
+	}
+	if e.RowOff < 0 {
+		e.RowOff = 0
+	}
+}
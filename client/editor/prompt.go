@@ -0,0 +1,47 @@
+package editor
+
+import (
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// Prompt is a single line input shown in place of the status bar.
+type Prompt struct {
+	Label string
+	Input []rune
+}
+
+func (e *Editor) OpenPrompt(label string) {
+	e.Prompt = &Prompt{Label: label}
+}
+
+func (e *Editor) ClosePrompt() {
+	e.Prompt = nil
+}
+
+func (p *Prompt) Insert(r rune) {
+	p.Input = append(p.Input, r)
+}
+
+func (p *Prompt) Backspace() {
+	if len(p.Input) > 0 {
+		p.Input = p.Input[:len(p.Input)-1]
+	}
+}
+
+func (p *Prompt) Text() string {
+	return string(p.Input)
+}
+
+func (e *Editor) DrawPrompt() {
+	x := 0
+	for _, r := range e.Prompt.Label {
+		e.setCell(x, e.Height-1, r, termbox.ColorDefault|termbox.AttrBold, termbox.ColorDefault)
+		x += runewidth.RuneWidth(r)
+	}
+	for _, r := range e.Prompt.Input {
+		e.setCell(x, e.Height-1, r, termbox.ColorDefault, termbox.ColorDefault)
+		x += runewidth.RuneWidth(r)
+	}
+	e.setCursor(x, e.Height-1)
+}
@@ -0,0 +1,152 @@
+package editor
+
+import (
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Match is a range of runes [Start, End) in the editor text.
+type Match struct {
+	Start int
+	End   int
+}
+
+// Search holds the state of a search over the editor text.
+type Search struct {
+	Query   string
+	Regex   bool
+	Matches []Match
+	Current int
+
+	re         *regexp.Regexp
+	src        string
+	submatches [][]int
+}
+
+// NewSearch finds every non-empty match of query in text. If isRegex is set,
+// query is compiled as a regular expression.
+func NewSearch(text []rune, query string, isRegex bool) (*Search, error) {
+	s := &Search{Query: query, Regex: isRegex}
+	if query == "" {
+		return s, nil
+	}
+
+	pattern := query
+	if !isRegex {
+		pattern = regexp.QuoteMeta(query)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return s, err
+	}
+
+	s.re = re
+	s.update(text)
+	return s, nil
+}
+
+// update recomputes the matches after the text changed.
+func (s *Search) update(text []rune) {
+	s.Matches = nil
+	s.submatches = nil
+	if s.re == nil {
+		return
+	}
+
+	s.src = string(text)
+
+	// convert byte offsets to rune offsets while walking the string once
+	runeIdx, byteIdx := 0, 0
+	toRunes := func(b int) int {
+		for byteIdx < b {
+			_, size := utf8.DecodeRuneInString(s.src[byteIdx:])
+			byteIdx += size
+			runeIdx++
+		}
+		return runeIdx
+	}
+
+	for _, loc := range s.re.FindAllStringSubmatchIndex(s.src, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		start := toRunes(loc[0])
+		end := toRunes(loc[1])
+		s.Matches = append(s.Matches, Match{Start: start, End: end})
+		s.submatches = append(s.submatches, loc)
+	}
+
+	if s.Current >= len(s.Matches) {
+		s.Current = 0
+	}
+}
+
+// Replacement returns the text replacing the i-th match. For regex searches
+// $1-style references in template are expanded.
+func (s *Search) Replacement(i int, template string) string {
+	if !s.Regex || s.re == nil {
+		return template
+	}
+	return string(s.re.ExpandString(nil, template, s.src, s.submatches[i]))
+}
+
+// matchAt returns the index of the match covering rune i, or -1.
+func (s *Search) matchAt(i int) int {
+	j := sort.Search(len(s.Matches), func(j int) bool { return s.Matches[j].End > i })
+	if j < len(s.Matches) && s.Matches[j].Start <= i {
+		return j
+	}
+	return -1
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (e *Editor) Find(query string, isRegex bool) error {
+	e.mu.RLock()
+	search, err := NewSearch(e.Text, query, isRegex)
+	e.mu.RUnlock()
+
+	e.Search = search
+	if err != nil {
+		return err
+	}
+
+	// select the first match at or after the cursor
+	search.Current = sort.Search(len(search.Matches), func(j int) bool {
+		return search.Matches[j].Start >= e.Cursor
+	})
+	if search.Current == len(search.Matches) {
+		search.Current = 0
+	}
+	e.gotoMatch()
+	return nil
+}
+
+// NextMatch moves the cursor to the next match, or the previous one if dir
+// is negative. The search wraps around the document.
+func (e *Editor) NextMatch(dir int) {
+	if e.Search == nil || len(e.Search.Matches) == 0 {
+		return
+	}
+
+	n := len(e.Search.Matches)
+	if dir < 0 {
+		e.Search.Current = (e.Search.Current - 1 + n) % n
+	} else {
+		e.Search.Current = (e.Search.Current + 1) % n
+	}
+	e.gotoMatch()
+}
+
+func (e *Editor) ClearSearch() {
+	e.Search = nil
+}
+
+func (e *Editor) gotoMatch() {
+	if len(e.Search.Matches) == 0 {
+		return
+	}
+	e.MoveCursor(e.Search.Matches[e.Search.Current].Start-e.Cursor, 0)
+}
@@ -1,21 +1,18 @@
 package main
 
 import (
-	"errors"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
+	"diploma/client/session"
 	"diploma/commons"
 
 	"diploma/crdt"
 
 	"diploma/client/editor"
 
-	"github.com/gorilla/websocket"
 	"github.com/nsf/termbox-go"
-	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -37,157 +34,80 @@ func getTermboxChan() chan termbox.Event {
 	return termboxChan
 }
 
-func getMsgChan(conn *websocket.Conn) chan commons.Message {
-	messageChan := make(chan commons.Message)
-	go func() {
-		for {
-			var msg commons.Message
-
-			err := conn.ReadJSON(&msg)
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					logger.Errorf("websocket error: %v", err)
-				}
-				e.IsConnected = false
-				e.StatusChan <- "lost connection!"
-				break
-			}
-
-			logger.Infof("message received: %+v\n", msg)
-
-			messageChan <- msg
-
-		}
-	}()
-	return messageChan
-}
-
 // ////////////////////////////////////////////////////////////////////
 // ////////////////////////////////////////////////////////////////////
-func handleTermboxEvent(ev termbox.Event, conn *websocket.Conn) error {
-	if ev.Type == termbox.EventKey {
-		switch ev.Key {
-
-		// exit session
-		case termbox.KeyEsc, termbox.KeyCtrlC:
-			// Return an error with the prefix "pairpad", so that it gets treated as an exit "event".
-			return errors.New("pairpad: exiting")
-
-		// save file contents
-		case termbox.KeyCtrlS:
-			if fileName == "" {
-				fileName = "content.txt"
-			}
-
-			err := crdt.Save(fileName, &doc)
-			if err != nil {
-				logrus.Errorf("Failed to save to %s", fileName)
-				e.StatusChan <- fmt.Sprintf("Failed to save to %s", fileName)
-				return err
-			}
-
-			e.StatusChan <- fmt.Sprintf("Saved document to %s", fileName)
-
-		// The default key for loading content from a file is Ctrl+L.
-		/* case termbox.KeyCtrlL:
-		if fileName != "" {
-			logger.Log(logrus.InfoLevel, "LOADING DOCUMENT")
-			newDoc, err := crdt.Load(fileName)
-			if err != nil {
-				logrus.Errorf("failed to load file %s", fileName)
-				e.StatusChan <- fmt.Sprintf("Failed to load %s", fileName)
+func handleTermboxEvent(ev termbox.Event, conn *session.Conn) error {
+	if ev.Type == termbox.EventKey && e.Prompt != nil {
+		if err := handlePromptEvent(ev, conn); err != nil {
+			return err
+		}
+	} else if ev.Type == termbox.EventKey && snapshotList != nil {
+		handleSnapshotsEvent(ev)
+	} else if ev.Type == termbox.EventKey && history != nil {
+		handleHistoryEvent(ev, conn)
+	} else if ev.Type == termbox.EventKey && tree.Focused {
+		handleTreeEvent(ev, conn)
+	} else if ev.Type == termbox.EventKey && chat.Focused {
+		handleChatEvent(ev, conn)
+	} else if ev.Type == termbox.EventKey && comments.Focused {
+		handleCommentsEvent(ev, conn)
+	} else if ev.Type == termbox.EventKey && vim != nil {
+		// the modal layer passes keys it doesn't consume to the keymap
+		consumed, err := vim.handleKey(ev, conn)
+		if err != nil {
+			return err
+		}
+		if !consumed {
+			if err := handleKeyEvent(ev, conn); err != nil {
 				return err
 			}
-			e.StatusChan <- fmt.Sprintf("Loading %s", fileName)
-			doc = newDoc
-			e.SetX(0)
-			e.SetText(crdt.Content(doc))
-
-			logger.Log(logrus.InfoLevel, "SENDING DOCUMENT")
-			docMsg := commons.Message{Type: commons.DocSyncMessage, Document: doc}
-			_ = conn.WriteJSON(&docMsg)
-		} else {
-			e.StatusChan <- "No file to load!"
-		} */
-
-		// move cursor
-		case termbox.KeyArrowLeft, termbox.KeyCtrlB:
-			e.MoveCursor(-1, 0)
-
-		case termbox.KeyArrowRight, termbox.KeyCtrlF:
-			e.MoveCursor(1, 0)
-
-		case termbox.KeyArrowUp, termbox.KeyCtrlP:
-			e.MoveCursor(0, -1)
-
-		case termbox.KeyArrowDown, termbox.KeyCtrlN:
-			e.MoveCursor(0, 1)
-
-		// Home key
-		case termbox.KeyHome:
-			e.SetX(0)
-
-		// End key
-		case termbox.KeyEnd:
-			e.SetX(len(e.Text))
-
-		// delete symbol
-		case termbox.KeyBackspace, termbox.KeyBackspace2:
-			performOperation(OperationDelete, ev, conn)
-		case termbox.KeyDelete:
-			performOperation(OperationDelete, ev, conn)
-
-		// Tab key
-		case termbox.KeyTab:
-			for i := 0; i < 4; i++ {
-				ev.Ch = ' '
-				performOperation(OperationInsert, ev, conn)
-			}
-
-		// Enter key
-		case termbox.KeyEnter:
-			ev.Ch = '\n'
-			performOperation(OperationInsert, ev, conn)
+		}
+	} else if ev.Type == termbox.EventKey {
+		if err := handleKeyEvent(ev, conn); err != nil {
+			return err
+		}
+	} else if ev.Type == termbox.EventMouse {
+		handleMouseEvent(ev)
+	} else if ev.Type == termbox.EventResize {
+		layout()
+		// scroll the cursors back into view
+		for _, p := range tabs[activeTab].root.leaves() {
+			p.editor.MoveCursor(0, 0)
+		}
+	}
 
-		// Space key
-		case termbox.KeySpace:
-			ev.Ch = ' '
-			performOperation(OperationInsert, ev, conn)
+	refreshComments()
+	refreshBlame()
+	e.SendDraw()
+	return nil
+}
 
-		// insert symbol
-		default:
-			if ev.Ch != 0 {
-				performOperation(OperationInsert, ev, conn)
-			}
+func handleKeyEvent(ev termbox.Event, conn *session.Conn) error {
+	if name, ok := keymap.Lookup(ev); ok {
+		if err := actions[name](ev, conn); err != nil {
+			return err
 		}
+	} else if ev.Ch != 0 {
+		// insert symbol
+		deleteSelection(conn)
+		performOperation(OperationInsert, ev, conn)
 	}
 
-	e.SendDraw()
+	e.ClearSelection()
 	return nil
 }
 
-func handleMsg(msg commons.Message, conn *websocket.Conn) {
+func handleMsg(msg commons.Message, conn *session.Conn) {
 	switch msg.Type {
 	// recieve current doc
 	case commons.DocSyncMessage:
-		logger.Infof("DOCSYNC RECEIVED, updating local doc %+v\n", msg.Document)
-		doc = msg.Document
-		e.SetText(crdt.Content(doc))
+		logger.Infof("DOCSYNC RECEIVED, updating local doc %q %+v\n", msg.Path, msg.Document)
+		syncDocument(msg.Path, msg.Document)
 
 	// send current doc
 	case commons.DocReqMessage:
-		logger.Infof("DOCREQ RECEIVED, sending local document to %v\n", msg.ID)
-		docMsg := commons.Message{Type: commons.DocSyncMessage, Document: doc, ID: msg.ID}
-		_ = conn.WriteJSON(&docMsg)
-
-	// recieve unique ID
-	case commons.SiteIDMessage:
-		siteID, err := strconv.Atoi(msg.Text)
-		if err != nil {
-			logger.Errorf("failed to set siteID, err: %v\n", err)
-		}
-		crdt.SiteID = siteID
-		logger.Infof("SITE ID %v, INTENDED SITE ID: %v", crdt.SiteID, siteID)
+		logger.Infof("DOCREQ RECEIVED, sending local documents to %v\n", msg.ID)
+		sendDocuments(conn, msg)
 
 	// recieve new user info message
 	case commons.JoinMessage:
@@ -198,79 +118,191 @@ func handleMsg(msg commons.Message, conn *websocket.Conn) {
 		e.StatusMu.Lock()
 		e.Users = strings.Split(msg.Text, ",")
 		e.StatusMu.Unlock()
+		if msg.Sites != nil {
+			siteNames = msg.Sites
+		}
+
+	// recieve a file created, renamed or deleted by other user
+	case commons.FileCreateMessage, commons.FileRenameMessage, commons.FileDeleteMessage:
+		handleFileMessage(msg)
+
+	// recieve chat, or the chat so far after joining
+	case commons.ChatMessage, commons.ChatHistoryMessage:
+		handleChatMessage(msg)
+
+	// recieve a comment added, changed or deleted by other user
+	case commons.CommentMessage, commons.CommentDeleteMessage:
+		handleCommentMessage(msg)
+
+	// recieve the history of a document
+	case commons.HistoryMessage:
+		openHistory(msg)
+
+	// recieve a snapshot taken by other user, or the snapshots of a document
+	case commons.SnapshotMessage, commons.SnapshotsMessage:
+		handleSnapshotMessage(msg)
+
+	// recieve several operations applied as one edit
+	case commons.BatchMessage:
+		for _, op := range msg.Operations {
+			applyRemoteOperation(msg.Username, msg.Path, op)
+		}
+		logger.Infof("REMOTE BATCH: %d operations\n", len(msg.Operations))
+		if history != nil && msg.Path == history.path {
+			history.add(msg.Username, msg.Operations)
+		}
 
 	default:
-		switch msg.Operation.Type {
-		// recieve insert from other user
+		applyRemoteOperation(msg.Username, msg.Path, msg.Operation)
+		if history != nil && msg.Path == history.path {
+			history.add(msg.Username, []commons.Operation{msg.Operation})
+		}
+	}
+
+	printDoc(*doc)
+	refreshComments()
+	refreshBlame()
+	e.SendDraw()
+}
+
+func applyRemoteOperation(username, path string, op commons.Operation) {
+	d, ok := docs[path]
+	if !ok {
+		logger.Errorf("operation for unknown document %q\n", path)
+		return
+	}
+
+	for _, op := range integrate(path, d, commons.RemoteOp{Username: username, Operation: op}) {
+		showRemoteOperation(path, d, op)
+	}
+}
+
+// showRemoteOperation shows an operation applied to the document at path
+// in the editors showing it.
+func showRemoteOperation(path string, d *crdt.Document, op commons.RemoteOp) {
+	// documents in the background only keep their CRDT state up to date
+	shown := false
+	for _, ed := range editors() {
+		if ed.FileName == path {
+			updateEditor(ed, op.Username, d, op.Operation)
+			shown = true
+		}
+	}
+
+	if !shown {
+		v := views[path]
+		v.remoteModified = true
+		views[path] = v
+	}
+}
+
+// integrate applies an operation of another site to the document d at
+// path. It returns the operations that changed d with the positions they
+// were applied at, which include the waiting ones it made ready.
+func integrate(path string, d *crdt.Document, op commons.RemoteOp) []commons.RemoteOp {
+	// operations of older clients only have a position
+	if op.ID == "" {
+		switch op.Type {
 		case "insert":
-			_, err := doc.Insert(msg.Operation.Position, msg.Operation.Value)
-			if err != nil {
+			if _, err := d.Insert(op.Position, op.Value); err != nil {
 				logger.Errorf("failed to insert, err: %v\n", err)
 			}
+		case "delete":
+			_ = d.Delete(op.Position)
+		}
+		return []commons.RemoteOp{op}
+	}
 
-			e.SetText(crdt.Content(doc))
-			if msg.Operation.Position-1 <= e.Cursor {
-				e.MoveCursor(len(msg.Operation.Value), 0)
-			}
-			logger.Infof("REMOTE INSERT: %s at position %v\n", msg.Operation.Value, msg.Operation.Position)
-
-			color := editor.GetColorForUsername(msg.Username, e.Users)
-			e.UsersPos[msg.Username] = editor.CursorColPos{Pos: msg.Operation.Position - 1, Col: color}
-			for name, user := range e.UsersPos {
-				if name != msg.Username && msg.Operation.Position < user.Pos {
-					e.UsersPos[name] = editor.CursorColPos{Pos: user.Pos + 1, Col: user.Col}
-				}
-			}
+	p, ok := pending[path]
+	if !ok {
+		p = &commons.Pending{}
+		pending[path] = p
+	}
 
-		// recieve delete from other user
-		case "delete":
-			_ = doc.Delete(msg.Operation.Position)
-			e.SetText(crdt.Content(doc))
-			if msg.Operation.Position-1 <= e.Cursor {
-				e.MoveCursor(-len(msg.Operation.Value), 0)
+	applied, err := p.Integrate(d, op)
+	if err != nil {
+		logger.Errorf("failed to %s %s, err: %v\n", op.Type, op.ID, err)
+	}
+	for _, op := range applied {
+		logger.Infof("REMOTE %s: %q at position %v\n", strings.ToUpper(op.Type), op.Value, op.Position)
+	}
+	if p.Len() > 0 {
+		logger.Infof("%d operations on %q wait for characters\n", p.Len(), path)
+	}
+	return applied
+}
+
+// updateEditor shows an operation of another user in an editor.
+func updateEditor(ed *editor.Editor, username string, d *crdt.Document, op commons.Operation) {
+	// the timeline shows the past, not the live text
+	if ed == e && history != nil {
+		return
+	}
+
+	switch op.Type {
+	// recieve insert from other user
+	case "insert":
+		ed.SetText(crdt.Content(*d))
+		ed.RemoteModified = true
+		if op.Position-1 <= ed.Cursor {
+			ed.MoveCursor(len(op.Value), 0)
+		}
+
+		color := editor.GetColorForUsername(username, ed.Users)
+		ed.UsersPos[username] = editor.CursorColPos{Pos: op.Position - 1, Col: color}
+		for name, user := range ed.UsersPos {
+			if name != username && op.Position < user.Pos {
+				ed.UsersPos[name] = editor.CursorColPos{Pos: user.Pos + 1, Col: user.Col}
 			}
-			logger.Infof("REMOTE DELETE: position %v\n", msg.Operation.Position)
-
-			color := editor.GetColorForUsername(msg.Username, e.Users)
-			e.UsersPos[msg.Username] = editor.CursorColPos{Pos: msg.Operation.Position - 2, Col: color}
-			for name, user := range e.UsersPos {
-				if name != msg.Username && msg.Operation.Position < user.Pos {
-					e.UsersPos[name] = editor.CursorColPos{Pos: user.Pos - 1, Col: user.Col}
-				}
+		}
+
+	// recieve delete from other user
+	case "delete":
+		ed.SetText(crdt.Content(*d))
+		ed.RemoteModified = true
+		if op.Position-1 <= ed.Cursor {
+			ed.MoveCursor(-len(op.Value), 0)
+		}
+
+		color := editor.GetColorForUsername(username, ed.Users)
+		ed.UsersPos[username] = editor.CursorColPos{Pos: op.Position - 2, Col: color}
+		for name, user := range ed.UsersPos {
+			if name != username && op.Position < user.Pos {
+				ed.UsersPos[name] = editor.CursorColPos{Pos: user.Pos - 1, Col: user.Col}
 			}
 		}
 	}
-
-	printDoc(doc)
-	e.SendDraw()
 }
 
 func handleStatusMsg() {
 	for msg := range e.StatusChan {
+		// the message stays in the pane that was focused when it arrived
+		ed := e
+
 		// write message to StatusBar
-		e.StatusMu.Lock()
-		e.StatusMsg = msg
-		e.ShowMsg = true
-		e.StatusMu.Unlock()
+		ed.StatusMu.Lock()
+		ed.StatusMsg = msg
+		ed.ShowMsg = true
+		ed.StatusMu.Unlock()
 
-		logger.Infof("got status message: %s", e.StatusMsg)
+		logger.Infof("got status message: %s", msg)
 
-		e.SendDraw()
+		ed.SendDraw()
 		time.Sleep(6 * time.Second)
 
 		// write base StatusBar back
-		e.StatusMu.Lock()
-		e.ShowMsg = false
-		e.StatusMu.Unlock()
+		ed.StatusMu.Lock()
+		ed.ShowMsg = false
+		ed.StatusMu.Unlock()
 
-		e.SendDraw()
+		ed.SendDraw()
 	}
 
 }
 
 // ////////////////////////////////////////////////////////////////////
 // ////////////////////////////////////////////////////////////////////
-func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
+func performOperation(opType int, ev termbox.Event, conn *session.Conn) {
 	ch := string(ev.Ch)
 
 	var msg commons.Message
@@ -279,15 +311,15 @@ func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
 	case OperationInsert:
 		logger.Infof("LOCAL INSERT: %s at cursor position %v\n", ch, e.Cursor)
 
-		text, err := doc.Insert(e.Cursor+1, ch)
+		op, err := localInsert(e.Cursor+1, ch)
 		if err != nil {
-			e.SetText(text)
 			logger.Errorf("CRDT error: %v\n", err)
+			return
 		}
-		e.SetText(text)
+		e.SetText(crdt.Content(*doc))
 
 		e.MoveCursor(1, 0)
-		msg = commons.Message{Username: e.Username, Type: "operation", Operation: commons.Operation{Type: "insert", Position: e.Cursor, Value: ch}}
+		msg = commons.Message{Username: e.Username, Type: "operation", Path: current, Operation: op}
 
 		for name, user := range e.UsersPos {
 			if name != e.Username && e.Cursor < user.Pos {
@@ -298,12 +330,17 @@ func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
 	case OperationDelete:
 		logger.Infof("LOCAL DELETE: cursor position %v\n", e.Cursor)
 
-		if e.Cursor-1 < 0 {
-			e.Cursor = 0
+		// nothing before the start of the text
+		if e.Cursor == 0 {
+			return
 		}
 
-		text := doc.Delete(e.Cursor)
-		e.SetText(text)
+		op, err := localDelete(e.Cursor)
+		if err != nil {
+			logger.Errorf("CRDT error: %v\n", err)
+			return
+		}
+		e.SetText(crdt.Content(*doc))
 
 		for name, user := range e.UsersPos {
 			if name != e.Username && e.Cursor < user.Pos {
@@ -311,12 +348,15 @@ func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
 			}
 		}
 
-		msg = commons.Message{Username: e.Username, Type: "operation", Operation: commons.Operation{Type: "delete", Position: e.Cursor}}
+		msg = commons.Message{Username: e.Username, Type: "operation", Path: current, Operation: op}
 		e.MoveCursor(-1, 0)
 	}
 
+	e.Modified = true
+	mirrorOperations([]commons.Operation{msg.Operation})
+
 	if e.IsConnected {
-		err := conn.WriteJSON(msg)
+		err := conn.Send(msg)
 		if err != nil {
 			e.IsConnected = false
 			e.StatusChan <- "lost connection!"
@@ -324,9 +364,26 @@ func performOperation(opType int, ev termbox.Event, conn *websocket.Conn) {
 	}
 }
 
+// sendOperations sends operations that were already applied locally as
+// one batch message.
+func sendOperations(ops []commons.Operation, conn *session.Conn) {
+	mirrorOperations(ops)
+
+	if len(ops) == 0 || !e.IsConnected {
+		return
+	}
+
+	msg := commons.Message{Username: e.Username, Type: commons.BatchMessage, Path: current, Operations: ops}
+	err := conn.Send(msg)
+	if err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
 func drawLoop() {
 	for {
 		<-e.DrawChan
-		e.Draw()
+		draw()
 	}
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestBackspaceAtStart(t *testing.T) {
+	startSession(t, "a.txt")
+	if _, err := localInsert(1, "x"); err != nil {
+		t.Fatal(err)
+	}
+	e.SetText(crdt.Content(*doc))
+
+	performOperation(OperationDelete, termbox.Event{Key: termbox.KeyBackspace2}, nil)
+	if got := crdt.Content(*doc); got != "x" || e.Modified {
+		t.Fatalf("backspace at the start: text %q, modified %v", got, e.Modified)
+	}
+
+	e.SetX(1)
+	performOperation(OperationDelete, termbox.Event{Key: termbox.KeyBackspace2}, nil)
+	if got := crdt.Content(*doc); got != "" || e.Cursor != 0 {
+		t.Fatalf("backspace after x: text %q, cursor %d", got, e.Cursor)
+	}
+}
+
+// An insert the document can't take, here for missing its bounds, changes
+// nothing and sends nothing.
+func TestInsertFails(t *testing.T) {
+	startSession(t, "a.txt")
+	*doc = crdt.Document{}
+
+	performOperation(OperationInsert, termbox.Event{Ch: 'x'}, nil)
+	insertText(0, "ab", nil)
+	if len(doc.Characters) != 0 || e.Cursor != 0 || e.Modified {
+		t.Fatalf("characters %+v, cursor %d, modified %v", doc.Characters, e.Cursor, e.Modified)
+	}
+}
+
+// Edits of other users don't count as unsaved local changes.
+func TestRemoteEditsNotModified(t *testing.T) {
+	startSession(t, "a.txt")
+	other := crdt.New()
+	docs["b.txt"] = &other
+
+	for _, p := range []string{"a.txt", "b.txt"} {
+		src := crdt.New()
+		op, err := commons.InsertAs(&src, 7, 1, "x")
+		if err != nil {
+			t.Fatal(err)
+		}
+		applyRemoteOperation("bob", p, op)
+	}
+
+	if crdt.Content(*doc) != "x" || e.Modified || !e.RemoteModified {
+		t.Errorf("shown document: text %q, modified %v, remote %v", crdt.Content(*doc), e.Modified, e.RemoteModified)
+	}
+	if v := views["b.txt"]; crdt.Content(other) != "x" || v.modified || !v.remoteModified || unsaved("b.txt") {
+		t.Errorf("background document: text %q, view %+v", crdt.Content(other), v)
+	}
+}
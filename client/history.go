@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// timeline shows the focused document as it was at a point of its history.
+// Characters are never removed from the CRDT, so any past version is a
+// matter of which of them were visible.
+type timeline struct {
+	path    string
+	entries []commons.HistoryEntry
+
+	// inserted and deleted hold the entry that inserted or deleted a
+	// character. Characters inserted before the history starts are missing
+	// from inserted.
+	inserted map[string]int
+	deleted  map[string]int
+
+	// point is the number of entries applied.
+	point int
+
+	// cursor is the cursor of the editor before the timeline was opened.
+	cursor int
+}
+
+// history is nil unless the focused editor shows the past.
+var history *timeline
+
+func requestHistory(conn *session.Conn) {
+	if !e.IsConnected {
+		e.StatusChan <- "History needs a connection to the server"
+		return
+	}
+
+	msg := commons.Message{Username: e.Username, Type: commons.HistoryReqMessage, Path: current}
+	if err := conn.Send(msg); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
+// openHistory starts showing the history received from the server.
+func openHistory(msg commons.Message) {
+	if msg.Path != current {
+		return
+	}
+	if len(msg.History) == 0 {
+		e.StatusChan <- "No history yet"
+		return
+	}
+
+	t := &timeline{
+		path:     msg.Path,
+		entries:  msg.History,
+		inserted: make(map[string]int),
+		deleted:  make(map[string]int),
+		point:    len(msg.History),
+		cursor:   e.Cursor,
+	}
+	for i, entry := range t.entries {
+		t.index(i, entry)
+	}
+
+	history = t
+	e.ClearSelection()
+	showHistory()
+}
+
+// add appends an edit made while the timeline is open.
+func (t *timeline) add(username string, ops []commons.Operation) {
+	entry := commons.HistoryEntry{Time: time.Now(), Username: username, Operations: ops}
+	t.entries = append(t.entries, entry)
+	t.index(len(t.entries)-1, entry)
+}
+
+func (t *timeline) index(i int, entry commons.HistoryEntry) {
+	for _, op := range entry.Operations {
+		switch {
+		case op.ID == "":
+		case op.Type == "insert":
+			t.inserted[op.ID] = i
+		case op.Type == "delete":
+			t.deleted[op.ID] = i
+		}
+	}
+}
+
+// closeHistory goes back to the live document.
+func closeHistory() {
+	cursor := history.cursor
+	history = nil
+
+	e.SetText(crdt.Content(*doc))
+	e.SetX(min(cursor, len(e.Text)))
+	e.MoveCursor(0, 0)
+
+	if vim != nil {
+		vim.setMode(modeNormal)
+	} else {
+		e.Mode = ""
+	}
+}
+
+// visible reports whether a character was visible after the first point
+// entries.
+func (t *timeline) visible(char crdt.Character) bool {
+	if i, ok := t.inserted[char.ID]; ok && i >= t.point {
+		return false
+	}
+	if i, ok := t.deleted[char.ID]; ok {
+		return i >= t.point
+	}
+	return char.Visible
+}
+
+// text returns the document at the current point along with the sites of
+// its characters.
+func (t *timeline) text() (string, []string) {
+	var text []rune
+	var sites []string
+	for _, char := range doc.Characters {
+		if char.ID == "start" || char.ID == "end" || !t.visible(char) {
+			continue
+		}
+		text = append(text, []rune(char.Value)...)
+		sites = append(sites, crdt.Site(char.ID))
+	}
+	return string(text), sites
+}
+
+// showHistory renders the document at the current point.
+func showHistory() {
+	text, sites := history.text()
+	e.SetText(text)
+	e.SetX(min(history.cursor, len(e.Text)))
+	e.MoveCursor(0, 0)
+
+	if e.Blame {
+		e.Authors = make([]string, len(sites))
+		for i, site := range sites {
+			e.Authors[i] = siteNames[site]
+		}
+	}
+
+	e.Mode = fmt.Sprintf("HISTORY %d/%d", history.point, len(history.entries))
+	if history.point > 0 {
+		entry := history.entries[history.point-1]
+		e.Mode += fmt.Sprintf(" %s %s", entry.Time.Local().Format("Jan 2 15:04:05"), entry.Username)
+	} else {
+		e.Mode += " start"
+	}
+}
+
+// restoreHistory makes the document look like it did at the current
+// point. Characters still present keep their IDs, the others are deleted
+// or inserted again as new operations.
+func restoreHistory(conn *session.Conn) {
+	chars := make([]crdt.Character, len(doc.Characters))
+	copy(chars, doc.Characters)
+
+	var ops []commons.Operation
+	pos := 0
+	for _, char := range chars {
+		if char.ID == "start" || char.ID == "end" {
+			continue
+		}
+
+		switch live, past := char.Visible, history.visible(char); {
+		case live && past:
+			pos++
+		case live:
+			op, err := localDelete(pos + 1)
+			if err != nil {
+				logger.Errorf("CRDT error: %v\n", err)
+				continue
+			}
+			ops = append(ops, op)
+		case past:
+			op, err := localInsert(pos+1, char.Value)
+			if err != nil {
+				logger.Errorf("CRDT error: %v\n", err)
+			}
+			ops = append(ops, op)
+			pos++
+		}
+	}
+
+	point := history.point
+	closeHistory()
+	if len(ops) == 0 {
+		return
+	}
+
+	e.Modified = true
+	sendOperations(ops, conn)
+	e.StatusChan <- fmt.Sprintf("Restored the document as of edit %d", point)
+}
+
+// handleHistoryEvent handles keys while the history is shown.
+func handleHistoryEvent(ev termbox.Event, conn *session.Conn) {
+	step := 0
+	switch {
+	case ev.Key == termbox.KeyArrowLeft || ev.Ch == 'h':
+		step = -1
+	case ev.Key == termbox.KeyArrowRight || ev.Ch == 'l':
+		step = 1
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		step = -10
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		step = 10
+	case ev.Key == termbox.KeyPgup:
+		step = -100
+	case ev.Key == termbox.KeyPgdn:
+		step = 100
+	case ev.Key == termbox.KeyHome || ev.Ch == 'g':
+		step = -len(history.entries)
+	case ev.Key == termbox.KeyEnd || ev.Ch == 'G':
+		step = len(history.entries)
+
+	case ev.Key == termbox.KeyEnter:
+		restoreHistory(conn)
+		return
+
+	case ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+		closeHistory()
+		return
+	}
+
+	history.point = max(0, min(history.point+step, len(history.entries)))
+	showHistory()
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+)
+
+// KeymapConfig is the format of the keymap file, for example:
+//
+//	{
+//	  "tab_width": 2,
+//	  "bindings": {
+//	    "Ctrl+Q": "exit",
+//	    "Ctrl+F": "move-right",
+//	    "Alt+f": "search"
+//	  }
+//	}
+//
+// Bindings are merged over the defaults. Binding a key to "none" unbinds it.
+type KeymapConfig struct {
+	TabWidth int               `json:"tab_width"`
+	Bindings map[string]string `json:"bindings"`
+}
+
+// Keymap maps key strokes to named editor actions.
+type Keymap struct {
+	TabWidth int
+	bindings map[keyStroke]string
+}
+
+type keyStroke struct {
+	key termbox.Key
+	ch  rune
+	alt bool
+}
+
+var defaultBindings = map[string]string{
+	"Esc":       "exit",
+	"Ctrl+C":    "exit",
+	"Ctrl+S":    "save",
+	"Ctrl+F":    "search",
+	"F3":        "search-next",
+	"F4":        "search-prev",
+	"Ctrl+R":    "replace",
+	"F2":        "toggle-line-numbers",
+	"Ctrl+W":    "toggle-wrap",
+	"Left":      "move-left",
+	"Ctrl+B":    "move-left",
+	"Right":     "move-right",
+	"Up":        "move-up",
+	"Ctrl+P":    "move-up",
+	"Down":      "move-down",
+	"Ctrl+N":    "move-down",
+	"Home":      "move-home",
+	"End":       "move-end",
+	"Backspace": "delete",
+	"Delete":    "delete",
+	"Tab":       "indent",
+	"Enter":     "newline",
+	"Space":     "space",
+	"F8":        "file-tree",
+	"F6":        "next-buffer",
+	"F5":        "prev-buffer",
+	"F9":        "split-vertical",
+	"F10":       "split-horizontal",
+	"Ctrl+X":    "close-pane",
+	"Ctrl+O":    "next-pane",
+	"Ctrl+T":    "new-tab",
+	"F7":        "next-tab",
+	"Ctrl+G":    "chat",
+	"Ctrl+K":    "comment",
+	"Ctrl+L":    "comments",
+	"Ctrl+E":    "blame",
+	"Ctrl+Y":    "export-blame",
+	"Ctrl+U":    "history",
+	"F11":       "snapshot",
+	"F12":       "snapshots",
+}
+
+var keyNames = map[string][]termbox.Key{
+	"f1":         {termbox.KeyF1},
+	"f2":         {termbox.KeyF2},
+	"f3":         {termbox.KeyF3},
+	"f4":         {termbox.KeyF4},
+	"f5":         {termbox.KeyF5},
+	"f6":         {termbox.KeyF6},
+	"f7":         {termbox.KeyF7},
+	"f8":         {termbox.KeyF8},
+	"f9":         {termbox.KeyF9},
+	"f10":        {termbox.KeyF10},
+	"f11":        {termbox.KeyF11},
+	"f12":        {termbox.KeyF12},
+	"insert":     {termbox.KeyInsert},
+	"delete":     {termbox.KeyDelete},
+	"home":       {termbox.KeyHome},
+	"end":        {termbox.KeyEnd},
+	"pgup":       {termbox.KeyPgup},
+	"pgdn":       {termbox.KeyPgdn},
+	"up":         {termbox.KeyArrowUp},
+	"down":       {termbox.KeyArrowDown},
+	"left":       {termbox.KeyArrowLeft},
+	"right":      {termbox.KeyArrowRight},
+	"backspace":  {termbox.KeyBackspace, termbox.KeyBackspace2},
+	"tab":        {termbox.KeyTab},
+	"enter":      {termbox.KeyEnter},
+	"esc":        {termbox.KeyEsc},
+	"space":      {termbox.KeySpace},
+	"ctrl+space": {termbox.KeyCtrlSpace},
+}
+
+const defaultTabWidth = 4
+
+var (
+	ErrUnknownKey = errors.New("unknown key")
+	ErrKeyAlias   = errors.New("key can't be told apart")
+	ErrConflict   = errors.New("conflicting bindings")
+)
+
+// Terminals send these control keys as the same byte as another key.
+var keyAliases = map[termbox.Key]string{
+	termbox.KeyCtrlH: "Backspace",
+	termbox.KeyCtrlI: "Tab",
+	termbox.KeyCtrlM: "Enter",
+}
+
+// DefaultKeymap returns the built-in emacs-like bindings.
+func DefaultKeymap() *Keymap {
+	k := &Keymap{TabWidth: defaultTabWidth, bindings: make(map[keyStroke]string)}
+	for name, action := range defaultBindings {
+		if err := k.Bind(name, action); err != nil {
+			panic(err)
+		}
+	}
+	return k
+}
+
+// LoadKeymap reads a keymap file and merges it over the defaults. A missing
+// file is not an error.
+func LoadKeymap(path string) (*Keymap, error) {
+	k := DefaultKeymap()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return k, nil
+	}
+	if err != nil {
+		return k, err
+	}
+
+	var conf KeymapConfig
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return k, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if conf.TabWidth > 0 {
+		k.TabWidth = conf.TabWidth
+	}
+
+	// names that differ only in case are the same key
+	names := make(map[keyStroke]string)
+	for name, action := range conf.Bindings {
+		if action != "none" && actions[action] == nil {
+			return k, fmt.Errorf("%s: unknown action %q for %s", path, action, name)
+		}
+		strokes, err := parseKey(name)
+		if err != nil {
+			return k, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, s := range strokes {
+			if other, ok := names[s]; ok && conf.Bindings[other] != action {
+				return k, fmt.Errorf("%s: %w: %s and %s", path, ErrConflict, other, name)
+			}
+			names[s] = name
+		}
+	}
+
+	for name, action := range conf.Bindings {
+		if err := k.Bind(name, action); err != nil {
+			return k, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return k, nil
+}
+
+// defaultKeymapPath returns the keymap file in the user's config directory.
+func defaultKeymapPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "pairpad", "keymap.json")
+}
+
+// Bind binds the named key to an action. The action "none" removes the
+// binding.
+func (k *Keymap) Bind(name, action string) error {
+	strokes, err := parseKey(name)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range strokes {
+		if action == "none" {
+			delete(k.bindings, s)
+		} else {
+			k.bindings[s] = action
+		}
+	}
+	return nil
+}
+
+// Lookup returns the action bound to the key event.
+func (k *Keymap) Lookup(ev termbox.Event) (string, bool) {
+	s := keyStroke{key: ev.Key, ch: ev.Ch, alt: ev.Mod&termbox.ModAlt != 0}
+	if ev.Ch != 0 {
+		s.key = 0
+	}
+
+	action, ok := k.bindings[s]
+	return action, ok
+}
+
+// parseKey parses names like "Ctrl+S", "F3", "Alt+x" or "PgUp". Ctrl+H,
+// Ctrl+I and Ctrl+M are rejected, they arrive as Backspace, Tab and Enter.
+func parseKey(name string) ([]keyStroke, error) {
+	lower := strings.ToLower(name)
+
+	alt := false
+	if strings.HasPrefix(lower, "alt+") {
+		alt = true
+		lower = strings.TrimPrefix(lower, "alt+")
+		name = name[len("alt+"):]
+	}
+
+	if keys, ok := keyNames[lower]; ok {
+		strokes := make([]keyStroke, 0, len(keys))
+		for _, key := range keys {
+			strokes = append(strokes, keyStroke{key: key, alt: alt})
+		}
+		return strokes, nil
+	}
+
+	// Ctrl+A .. Ctrl+Z
+	if r := []rune(lower); len(r) == 6 && strings.HasPrefix(lower, "ctrl+") && r[5] >= 'a' && r[5] <= 'z' {
+		key := termbox.KeyCtrlA + termbox.Key(r[5]-'a')
+		if same, ok := keyAliases[key]; ok {
+			return nil, fmt.Errorf("%w: %q is %s", ErrKeyAlias, name, same)
+		}
+		return []keyStroke{{key: key, alt: alt}}, nil
+	}
+
+	// a single character
+	if r := []rune(name); len(r) == 1 {
+		return []keyStroke{{ch: r[0], alt: alt}}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, name)
+}
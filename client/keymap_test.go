@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsf/termbox-go"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name string
+		want []keyStroke
+	}{
+		{"Ctrl+S", []keyStroke{{key: termbox.KeyCtrlS}}},
+		{"ctrl+a", []keyStroke{{key: termbox.KeyCtrlA}}},
+		{"F3", []keyStroke{{key: termbox.KeyF3}}},
+		{"PgUp", []keyStroke{{key: termbox.KeyPgup}}},
+		{"Alt+x", []keyStroke{{ch: 'x', alt: true}}},
+		{"Alt+X", []keyStroke{{ch: 'X', alt: true}}},
+		{"Alt+Left", []keyStroke{{key: termbox.KeyArrowLeft, alt: true}}},
+		{"ж", []keyStroke{{ch: 'ж'}}},
+		{"Backspace", []keyStroke{{key: termbox.KeyBackspace}, {key: termbox.KeyBackspace2}}},
+	}
+
+	for _, tt := range tests {
+		got, err := parseKey(tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestParseKeyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"Ctrl+H", ErrKeyAlias},
+		{"Ctrl+i", ErrKeyAlias},
+		{"Alt+Ctrl+M", ErrKeyAlias},
+		{"Ctrl+1", ErrUnknownKey},
+		{"Hyper+A", ErrUnknownKey},
+		{"", ErrUnknownKey},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseKey(tt.name); !errors.Is(err, tt.err) {
+			t.Errorf("%q: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDefaultKeymap(t *testing.T) {
+	k := DefaultKeymap()
+
+	for _, tt := range []struct {
+		ev     termbox.Event
+		action string
+	}{
+		{termbox.Event{Key: termbox.KeyCtrlS}, "save"},
+		{termbox.Event{Key: termbox.KeyBackspace2}, "delete"},
+		{termbox.Event{Key: termbox.KeyEnter}, "newline"},
+		{termbox.Event{Key: termbox.KeyF12}, "snapshots"},
+	} {
+		if action, _ := k.Lookup(tt.ev); action != tt.action {
+			t.Errorf("%v: got %q, want %q", tt.ev, action, tt.action)
+		}
+	}
+
+	for name, action := range defaultBindings {
+		if actions[action] == nil {
+			t.Errorf("%s is bound to unknown action %q", name, action)
+		}
+	}
+}
+
+func writeKeymap(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keymap.json")
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeymap(t *testing.T) {
+	k, err := LoadKeymap(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	if k.TabWidth != defaultTabWidth {
+		t.Errorf("tab width %d, want %d", k.TabWidth, defaultTabWidth)
+	}
+
+	path := writeKeymap(t, `{
+		"tab_width": 2,
+		"bindings": {"Alt+f": "search", "Ctrl+S": "none", "Ctrl+Q": "exit"}
+	}`)
+	k, err = LoadKeymap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.TabWidth != 2 {
+		t.Errorf("tab width %d, want 2", k.TabWidth)
+	}
+	if action, _ := k.Lookup(termbox.Event{Ch: 'f', Mod: termbox.ModAlt}); action != "search" {
+		t.Errorf("Alt+f: got %q, want search", action)
+	}
+	if action, _ := k.Lookup(termbox.Event{Key: termbox.KeyCtrlQ}); action != "exit" {
+		t.Errorf("Ctrl+Q: got %q, want exit", action)
+	}
+	if _, ok := k.Lookup(termbox.Event{Key: termbox.KeyCtrlS}); ok {
+		t.Error("Ctrl+S is still bound")
+	}
+	if action, _ := k.Lookup(termbox.Event{Key: termbox.KeyCtrlF}); action != "search" {
+		t.Errorf("default Ctrl+F: got %q, want search", action)
+	}
+}
+
+func TestLoadKeymapErrors(t *testing.T) {
+	tests := []struct {
+		data string
+		err  error // nil for errors of any kind
+	}{
+		{`{"bindings": {"Ctrl+S": "fly"}}`, nil},
+		{`{"bindings": {"Meta+S": "save"}}`, ErrUnknownKey},
+		{`{"bindings": {"Ctrl+H": "save"}}`, ErrKeyAlias},
+		{`{"bindings": {"Ctrl+S": "save", "ctrl+s": "exit"}}`, ErrConflict},
+		{`{"bindings": {"Alt+F2": "save", "alt+f2": "exit"}}`, ErrConflict},
+		{`{"bindings": [}`, nil},
+	}
+
+	for _, tt := range tests {
+		_, err := LoadKeymap(writeKeymap(t, tt.data))
+		if err == nil {
+			t.Errorf("%s: no error", tt.data)
+		} else if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.data, err, tt.err)
+		}
+	}
+
+	// the same binding spelled twice is not a conflict
+	if _, err := LoadKeymap(writeKeymap(t, `{"bindings": {"F2": "save", "f2": "save"}}`)); err != nil {
+		t.Error(err)
+	}
+}
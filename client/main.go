@@ -8,7 +8,7 @@ import (
 
 	"diploma/client/editor"
 
-	"diploma/commons"
+	"diploma/client/session"
 	"diploma/crdt"
 
 	"github.com/Pallinder/go-randomdata"
@@ -16,15 +16,25 @@ import (
 )
 
 var (
-	doc      = crdt.New()
-	logger   = logrus.New()
-	e        *editor.Editor
-	fileName string
-	flags    Flags
+	doc    *crdt.Document
+	logger = logrus.New()
+	e      *editor.Editor
+	flags  Flags
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		os.Exit(runMerge(os.Args[2:]))
+	}
+
 	flags = parseFlags()
+
+	var err error
+	if keymap, err = LoadKeymap(flags.Keymap); err != nil {
+		fmt.Printf("Failed to load keymap, exiting: %s\n", err)
+		return
+	}
+
 	s := bufio.NewScanner(os.Stdin)
 
 	var name string
@@ -36,15 +46,13 @@ func main() {
 		name = randomdata.SillyName()
 	}
 
-	conn, _, err := createConn(flags)
+	conn, err := session.Dial(serverURL(flags), name)
 	if err != nil {
 		fmt.Printf("Connection error, exiting: %s\n", err)
 		return
 	}
 	defer conn.Close()
-
-	msg := commons.Message{Username: name, Text: "has joined the session.", Type: commons.JoinMessage}
-	_ = conn.WriteJSON(msg)
+	crdt.SiteID = conn.Site()
 
 	logFile, debugLogFile, err := setupLogger(logger)
 	if err != nil {
@@ -53,18 +61,41 @@ func main() {
 	}
 	defer closeLogFiles(logFile, debugLogFile)
 
+	if flags.Dir != "" {
+		if err = loadProject(flags.Dir); err != nil {
+			fmt.Printf("failed to load project: %s\n", err)
+			return
+		}
+	}
+
 	if flags.File != "" {
-		if doc, err = crdt.Load(flags.File); err != nil {
+		if current, err = loadFile(flags.File); err != nil {
 			fmt.Printf("failed to load document: %s\n", err)
 			return
 		}
 	}
 
+	if len(docs) == 0 {
+		d := crdt.New()
+		docs[""] = &d
+	}
+	if _, ok := docs[current]; !ok {
+		current = docPaths()[0]
+	}
+	doc = docs[current]
+
+	recoverDocuments(s)
+
 	uiConfig := UIConfig{
 		EditorConfig: editor.EditorConfig{
 			ScrollEnabled: flags.Scroll,
 			Username:      name,
+			FileName:      current,
+			Lang:          flags.Lang,
+			LineNumbers:   flags.Lines,
+			Wrap:          flags.Wrap,
 		},
+		Vim: flags.Vim,
 	}
 
 	err = initUI(conn, uiConfig)
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"diploma/crdt"
+	"diploma/diff"
+)
+
+// runMerge merges two documents edited apart with their common ancestor:
+//
+//	client merge [-o output] ancestor ours theirs
+//
+// Saved CRDT states are integrated with WOOT and the result is saved as a
+// state. If any of the files is plain text, their texts are merged line by
+// line instead, with conflict markers where both sides changed the same
+// lines. Without -o the merged text is printed. It returns the exit code.
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := fs.String("o", "", "The file to write the merged document to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: client merge [-o output] ancestor ours theirs")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 3 {
+		fs.Usage()
+		return 2
+	}
+	files := fs.Args()
+
+	states := make([]crdt.Document, 3)
+	plain := false
+	for i, fileName := range files {
+		d, err := crdt.LoadState(fileName)
+		if errors.Is(err, crdt.ErrNotState) {
+			plain = true
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %s: %s\n", fileName, err)
+			return 2
+		}
+		states[i] = d
+	}
+
+	if !plain {
+		merged, err := crdt.Merge(states[0], states[1], states[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to merge: %s\n", err)
+			return 2
+		}
+		if *output == "" {
+			fmt.Print(crdt.Content(merged))
+			return 0
+		}
+		if err := crdt.SaveState(*output, &merged); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to save %s: %s\n", *output, err)
+			return 2
+		}
+		return 0
+	}
+
+	texts := make([]string, 3)
+	for i, fileName := range files {
+		if states[i].Characters != nil {
+			texts[i] = crdt.Content(states[i])
+			continue
+		}
+		content, err := os.ReadFile(fileName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load %s: %s\n", fileName, err)
+			return 2
+		}
+		texts[i] = string(content)
+	}
+
+	merged, conflicts := diff.Merge3(texts[0], texts[1], texts[2], files[1], files[2])
+	if *output == "" {
+		fmt.Print(merged)
+	} else if err := os.WriteFile(*output, []byte(merged), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to save %s: %s\n", *output, err)
+		return 2
+	}
+
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "%d conflicts\n", conflicts)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+func handleMouseEvent(ev termbox.Event) {
+	switch ev.Key {
+	// place the cursor, or extend the selection while dragging
+	case termbox.MouseLeft:
+		if ev.Mod&termbox.ModMotion != 0 {
+			e.Drag(ev.MouseX, ev.MouseY)
+		} else {
+			// clicking another pane focuses it
+			if p := paneAt(ev.MouseX, ev.MouseY); p != nil {
+				focusPane(p)
+			}
+			e.Click(ev.MouseX, ev.MouseY)
+		}
+
+	case termbox.MouseRelease:
+		e.Release()
+
+	// scroll the viewport
+	case termbox.MouseWheelUp:
+		if p := paneAt(ev.MouseX, ev.MouseY); p != nil {
+			p.editor.Scroll(-1)
+		}
+
+	case termbox.MouseWheelDown:
+		if p := paneAt(ev.MouseX, ev.MouseY); p != nil {
+			p.editor.Scroll(1)
+		}
+	}
+}
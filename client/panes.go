@@ -0,0 +1,449 @@
+package main
+
+import (
+	"fmt"
+
+	"diploma/client/editor"
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/mattn/go-runewidth"
+	"github.com/nsf/termbox-go"
+)
+
+// pane is a node of the layout of a tab. A leaf shows one editor, any other
+// pane splits its rectangle between its two children.
+type pane struct {
+	editor *editor.Editor
+
+	// vertical splits put the children side by side instead of stacking them
+	vertical bool
+	children [2]*pane
+	parent   *pane
+
+	x, y, w, h int
+}
+
+// tab is a set of panes shown together. The focused pane holds e.
+type tab struct {
+	root  *pane
+	focus *pane
+}
+
+var (
+	tabs      []*tab
+	activeTab int
+)
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// leaves returns the panes holding an editor, from left to right and top to
+// bottom.
+func (p *pane) leaves() []*pane {
+	if p.editor != nil {
+		return []*pane{p}
+	}
+	return append(p.children[0].leaves(), p.children[1].leaves()...)
+}
+
+// place gives the pane the rectangle (x, y, w, h) and divides it between
+// its children. A vertical split keeps one column for the border.
+func (p *pane) place(x, y, w, h int) {
+	p.x, p.y, p.w, p.h = x, y, w, h
+
+	switch {
+	case p.editor != nil:
+		p.editor.SetPosition(x, y)
+		p.editor.SetSize(w, h)
+	case p.vertical:
+		left := (w - 1) / 2
+		p.children[0].place(x, y, left, h)
+		p.children[1].place(x+left+1, y, w-left-1, h)
+	default:
+		top := h / 2
+		p.children[0].place(x, y, w, top)
+		p.children[1].place(x, y+top, w, h-top)
+	}
+}
+
+func (p *pane) contains(x, y int) bool {
+	return x >= p.x && x < p.x+p.w && y >= p.y && y < p.y+p.h
+}
+
+// editors returns the editors of every tab.
+func editors() []*editor.Editor {
+	var eds []*editor.Editor
+	for _, t := range tabs {
+		for _, p := range t.root.leaves() {
+			eds = append(eds, p.editor)
+		}
+	}
+	return eds
+}
+
+// paneAt returns the pane of the active tab at the screen cell (x, y).
+func paneAt(x, y int) *pane {
+	for _, p := range tabs[activeTab].root.leaves() {
+		if p.contains(x, y) {
+			return p
+		}
+	}
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// newEditor creates another editor showing the document at p. It shares
+// its channels and settings with the focused editor.
+func newEditor(p string) *editor.Editor {
+	ed := editor.NewEditor(editor.EditorConfig{
+		ScrollEnabled: e.ScrollEnabled,
+		Username:      e.Username,
+		FileName:      p,
+		Lang:          flags.Lang,
+		LineNumbers:   e.LineNumbers,
+		Wrap:          e.Wrap,
+	})
+	ed.DrawChan = e.DrawChan
+	ed.StatusChan = e.StatusChan
+	shareState(ed)
+
+	ed.SetText(crdt.Content(*docs[p]))
+	if p == current {
+		ed.Cursor, ed.RowOff, ed.ColOff = e.Cursor, e.RowOff, e.ColOff
+		ed.Modified, ed.RemoteModified = e.Modified, e.RemoteModified
+	}
+	return ed
+}
+
+// shareState copies the session wide state of the focused editor to ed.
+func shareState(ed *editor.Editor) {
+	if ed == e {
+		return
+	}
+
+	e.StatusMu.Lock()
+	users := e.Users
+	e.StatusMu.Unlock()
+
+	ed.StatusMu.Lock()
+	ed.Users = users
+	ed.StatusMu.Unlock()
+
+	ed.IsConnected = e.IsConnected
+}
+
+// focusPane moves the focus to the pane p of the active tab.
+func focusPane(p *pane) {
+	tabs[activeTab].focus = p
+	focusEditor(p.editor)
+}
+
+// focusEditor makes ed the editor that receives input.
+func focusEditor(ed *editor.Editor) {
+	if ed == e {
+		return
+	}
+
+	if history != nil {
+		closeHistory()
+	}
+
+	// prompts belong to the pane they were opened in
+	if e.Prompt != nil {
+		if promptKind == promptSearch {
+			e.ClearSearch()
+		}
+		e.ClosePrompt()
+	}
+	e.ClearSelection()
+	e.Mode = ""
+	shareState(ed)
+
+	e = ed
+	current = ed.FileName
+	doc = docs[current]
+
+	tree.Current = current
+	tree.Select(current)
+
+	if vim != nil {
+		vim.setMode(modeNormal)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// splitPane splits the focused pane in two, both showing the current
+// document, and focuses the new half.
+func splitPane(vertical bool) {
+	t := tabs[activeTab]
+	p := t.focus
+
+	first := &pane{editor: p.editor, parent: p}
+	second := &pane{editor: newEditor(current), parent: p}
+
+	p.editor = nil
+	p.vertical = vertical
+	p.children = [2]*pane{first, second}
+
+	layout()
+	focusPane(second)
+}
+
+// closePane closes the focused pane, or its tab if it's the only pane.
+func closePane() {
+	t := tabs[activeTab]
+	p := t.focus
+
+	if p.parent == nil {
+		closeTab()
+		return
+	}
+
+	// the sibling takes the place of the parent
+	parent := p.parent
+	sibling := parent.children[0]
+	if sibling == p {
+		sibling = parent.children[1]
+	}
+
+	parent.editor = sibling.editor
+	parent.vertical = sibling.vertical
+	parent.children = sibling.children
+	for _, child := range parent.children {
+		if child != nil {
+			child.parent = parent
+		}
+	}
+
+	layout()
+	focusPane(parent.leaves()[0])
+}
+
+// cyclePane moves the focus to the next pane of the tab, or the previous
+// one if d is negative.
+func cyclePane(d int) {
+	t := tabs[activeTab]
+	leaves := t.root.leaves()
+	for i, p := range leaves {
+		if p == t.focus {
+			focusPane(leaves[(i+d+len(leaves))%len(leaves)])
+			return
+		}
+	}
+}
+
+// newTab opens a tab showing the current document.
+func newTab() {
+	p := &pane{editor: newEditor(current)}
+	tabs = append(tabs, &tab{root: p, focus: p})
+	activeTab = len(tabs) - 1
+
+	layout()
+	focusEditor(p.editor)
+}
+
+func closeTab() {
+	if len(tabs) == 1 {
+		e.StatusChan <- "Can't close the last pane"
+		return
+	}
+
+	tabs = append(tabs[:activeTab], tabs[activeTab+1:]...)
+	if activeTab >= len(tabs) {
+		activeTab = len(tabs) - 1
+	}
+
+	layout()
+	focusEditor(tabs[activeTab].focus.editor)
+}
+
+// cycleTab switches to the next tab, or the previous one if d is negative.
+func cycleTab(d int) {
+	activeTab = (activeTab + d + len(tabs)) % len(tabs)
+
+	layout()
+	focusEditor(tabs[activeTab].focus.editor)
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// mirrorOperations updates the other editors showing the current document
+// after local operations were applied to it.
+func mirrorOperations(ops []commons.Operation) {
+	for _, ed := range editors() {
+		if ed == e || ed.FileName != current {
+			continue
+		}
+
+		ed.SetText(crdt.Content(*doc))
+		ed.Modified = true
+
+		cursor := ed.Cursor
+		for _, op := range ops {
+			switch op.Type {
+			case "insert":
+				if op.Position-1 <= cursor {
+					cursor++
+				}
+			case "delete":
+				if op.Position > 0 && op.Position-1 < cursor {
+					cursor--
+				}
+			}
+		}
+		ed.SetX(cursor)
+		ed.MoveCursor(0, 0)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// layout places the file tree, the side panes on the right, the tab bar and
+// the panes of the active tab on the screen.
+func layout() {
+	w, h := termbox.Size()
+
+	left := 0
+	if tree.Visible {
+		left = tree.Width
+	}
+	w -= rightWidth()
+
+	top := 0
+	if len(tabs) > 1 {
+		top = 1
+	}
+
+	tabs[activeTab].root.place(left, top, w-left, h-top)
+}
+
+// draw renders every pane of the UI.
+func draw() {
+	_ = termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
+
+	if snapshotList != nil {
+		drawSnapshots()
+		termbox.Flush()
+		return
+	}
+
+	if tree.Visible {
+		_, h := termbox.Size()
+		tree.Draw(0, 0, h)
+	}
+
+	if len(tabs) > 1 {
+		drawTabBar()
+	}
+
+	// the focused editor goes last, so that it places the cursor
+	root := tabs[activeTab].root
+	drawBorders(root)
+	for _, p := range root.leaves() {
+		if p.editor != e {
+			shareState(p.editor)
+			p.editor.Render()
+		}
+	}
+	e.Render()
+
+	e.StatusMu.Lock()
+	users := e.Users
+	e.StatusMu.Unlock()
+
+	// the side panes go after the editor, so that the chat can take the
+	// cursor
+	w, h := termbox.Size()
+	if comments.Visible {
+		x := w - comments.Width
+		if chat.Visible {
+			x -= chat.Width
+		}
+		comments.Draw(x, 0, h, users)
+	}
+	if chat.Visible {
+		chat.Draw(w-chat.Width, 0, h, users)
+	} else if chat.Unread > 0 {
+		drawUnread()
+	}
+
+	termbox.Flush()
+}
+
+// drawBorders draws the column between the halves of vertical splits.
+func drawBorders(p *pane) {
+	if p.editor != nil {
+		return
+	}
+
+	if p.vertical {
+		x := p.children[1].x - 1
+		for y := p.y; y < p.y+p.h; y++ {
+			termbox.SetCell(x, y, '│', termbox.ColorDarkGray, termbox.ColorDefault)
+		}
+	}
+
+	drawBorders(p.children[0])
+	drawBorders(p.children[1])
+}
+
+// rightWidth returns the number of columns taken by the side panes on the
+// right of the editors.
+func rightWidth() int {
+	w := 0
+	if comments.Visible {
+		w += comments.Width
+	}
+	if chat.Visible {
+		w += chat.Width
+	}
+	return w
+}
+
+// drawUnread shows the number of unread chat messages in the top right
+// corner.
+func drawUnread() {
+	w, _ := termbox.Size()
+
+	label := fmt.Sprintf(" %d unread ", chat.Unread)
+	x := w - runewidth.StringWidth(label)
+	for _, r := range label {
+		termbox.SetCell(x, 0, r, termbox.ColorBlack|termbox.AttrBold, termbox.ColorYellow)
+		x += runewidth.RuneWidth(r)
+	}
+}
+
+// drawTabBar lists the tabs by the document of their focused pane.
+func drawTabBar() {
+	w, _ := termbox.Size()
+	w -= rightWidth()
+
+	x := 0
+	if tree.Visible {
+		x = tree.Width
+	}
+
+	for i, t := range tabs {
+		name := displayName(t.focus.editor.FileName)
+
+		fg, bg := termbox.ColorDefault, termbox.ColorDefault
+		if i == activeTab {
+			fg, bg = termbox.ColorBlack|termbox.AttrBold, termbox.ColorCyan
+		}
+
+		for _, r := range fmt.Sprintf(" %d:%s ", i+1, name) {
+			if x >= w {
+				return
+			}
+			termbox.SetCell(x, 0, r, fg, bg)
+			x += runewidth.RuneWidth(r)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"diploma/crdt"
+	"diploma/diff"
+)
+
+// Unsaved documents are written to the recovery directory every few
+// seconds and when the client exits. Each one keeps two files named after
+// the file the document is saved to: the whole document, and the text that
+// file had when the first unsaved change was written, which is the base
+// for merging.
+
+// recoveryDir holds the documents with unsaved changes.
+var recoveryDir = defaultRecoveryDir()
+
+func defaultRecoveryDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "pairpad-recovery")
+	}
+	return filepath.Join(dir, "pairpad", "recovery")
+}
+
+// recoveryPath returns the recovery files of the document at p, without
+// their extension.
+func recoveryPath(p string) string {
+	fileName, err := filepath.Abs(savePath(p))
+	if err != nil {
+		fileName = savePath(p)
+	}
+	return filepath.Join(recoveryDir, url.PathEscape(fileName))
+}
+
+// unsaved reports whether the document at p changed since it was saved.
+func unsaved(p string) bool {
+	for _, ed := range editors() {
+		if ed.FileName == p && ed.Modified {
+			return true
+		}
+	}
+	return views[p].modified
+}
+
+// autosave writes every unsaved document to the recovery directory.
+func autosave() {
+	for _, p := range docPaths() {
+		if !unsaved(p) {
+			continue
+		}
+		if err := writeRecovery(p); err != nil {
+			logger.Errorf("failed to autosave %q: %v\n", p, err)
+		}
+	}
+}
+
+func writeRecovery(p string) error {
+	if err := os.MkdirAll(recoveryDir, 0700); err != nil {
+		return err
+	}
+	name := recoveryPath(p)
+
+	if _, err := os.Stat(name + ".base"); errors.Is(err, fs.ErrNotExist) {
+		base, err := os.ReadFile(savePath(p))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := os.WriteFile(name+".base", base, 0600); err != nil {
+			return err
+		}
+	}
+
+	return crdt.SaveState(name+".woot", docs[p])
+}
+
+// clearRecovery drops the recovery files of the document at p once it's
+// saved.
+func clearRecovery(p string) {
+	name := recoveryPath(p)
+	_ = os.Remove(name + ".woot")
+	_ = os.Remove(name + ".base")
+}
+
+// recoverDocuments offers to restore the unsaved changes to the loaded
+// documents left by a previous run, or to merge them with the files.
+func recoverDocuments(s *bufio.Scanner) {
+	for _, p := range docPaths() {
+		name := recoveryPath(p)
+		info, err := os.Stat(name + ".woot")
+		if err != nil {
+			continue
+		}
+
+		recovered, err := crdt.ReadState(name + ".woot")
+		if err != nil {
+			fmt.Printf("Unsaved changes to %s can't be recovered: %s\n", displayName(p), err)
+			clearRecovery(p)
+			continue
+		}
+
+		fmt.Printf("Found unsaved changes to %s from %s.\n", displayName(p), info.ModTime().Format("Jan 2 15:04:05"))
+		fmt.Print("[r]estore, [m]erge with the file or [d]iscard them? ")
+		if !s.Scan() {
+			return
+		}
+
+		switch strings.ToLower(strings.TrimSpace(s.Text())) {
+		case "r", "restore":
+			crdt.Resume(recovered)
+			docs[p] = &recovered.Document
+			views[p] = view{modified: true}
+
+		case "m", "merge":
+			merged, conflicts, err := mergeRecovered(p, recovered)
+			if err != nil {
+				fmt.Printf("Failed to merge, keeping the changes for later: %s\n", err)
+				continue
+			}
+			if conflicts > 0 {
+				fmt.Printf("Merged with %d conflicts, look for the <<<<<<< markers.\n", conflicts)
+			}
+			docs[p] = &merged
+			views[p] = view{modified: true}
+
+		case "d", "discard":
+			clearRecovery(p)
+		}
+	}
+}
+
+// mergeRecovered merges the recovered state of the document at p with the
+// loaded one. Documents sharing their characters are merged like replicas,
+// others line by line against the text the file had when the changes
+// started.
+func mergeRecovered(p string, recovered crdt.State) (crdt.Document, int, error) {
+	loaded := *docs[p]
+	crdt.Resume(recovered)
+
+	if shareCharacters(loaded, recovered.Document) {
+		merged, err := crdt.Merge(crdt.New(), loaded, recovered.Document)
+		return merged, 0, err
+	}
+
+	base, err := os.ReadFile(recoveryPath(p) + ".base")
+	if err != nil {
+		return crdt.Document{}, 0, err
+	}
+
+	text, conflicts := diff.Merge3(string(base), crdt.Content(loaded), crdt.Content(recovered.Document), "file", "unsaved changes")
+	merged := crdt.New()
+	for i, r := range []rune(text) {
+		if _, err := merged.Insert(i+1, string(r)); err != nil {
+			return crdt.Document{}, 0, err
+		}
+	}
+	return merged, conflicts, nil
+}
+
+// shareCharacters reports whether a and b have characters of a session in
+// common. Text loaded from a file takes the same IDs on every load, so it
+// doesn't count.
+func shareCharacters(a, b crdt.Document) bool {
+	ids := make(map[string]bool, len(a.Characters))
+	for _, c := range a.Characters {
+		ids[c.ID] = true
+	}
+	for _, c := range b.Characters {
+		if site := crdt.Site(c.ID); site != "0" && site != "" && ids[c.ID] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	"diploma/crdt"
+)
+
+// insert types text at the 1-based pos of d as site.
+func insert(t *testing.T, d *crdt.Document, site, pos int, text string) {
+	t.Helper()
+	for i, r := range text {
+		if _, err := d.InsertCharacterAs(site, pos+i, string(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// The document another user sends is merged with the recovered one, the
+// unsaved changes stay and are autosaved again.
+func TestRecoverThenSync(t *testing.T) {
+	startSession(t, "a.txt")
+	dir, saved := recoveryDir, root
+	recoveryDir, root = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { recoveryDir, root = dir, saved })
+
+	shared := crdt.New()
+	insert(t, &shared, 1, 1, "hello")
+
+	local := crdt.Document{Characters: append([]crdt.Character(nil), shared.Characters...)}
+	insert(t, &local, 2, 6, " world")
+	docs["a.txt"] = &local
+	if err := writeRecovery("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	d := crdt.New()
+	docs["a.txt"] = &d
+	recoverDocuments(bufio.NewScanner(strings.NewReader("r\n")))
+	if got := crdt.Content(*docs["a.txt"]); got != "hello world" {
+		t.Fatalf("recovered %q", got)
+	}
+
+	remote := crdt.Document{Characters: append([]crdt.Character(nil), shared.Characters...)}
+	insert(t, &remote, 3, 1, ">")
+	syncDocument("a.txt", remote)
+	if got := crdt.Content(*docs["a.txt"]); got != ">hello world" {
+		t.Fatalf("got %q after the document arrived, want %q", got, ">hello world")
+	}
+
+	autosave()
+	s, err := crdt.ReadState(recoveryPath("a.txt") + ".woot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := crdt.Content(s.Document); got != ">hello world" {
+		t.Fatalf("autosaved %q", got)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+
+	"diploma/client/editor"
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/nsf/termbox-go"
+)
+
+const (
+	promptSearch = iota
+	promptReplace
+	promptCommand
+	promptNewFile
+	promptRenameFile
+	promptDeleteFile
+	promptComment
+	promptSnapshot
+	promptSaveAs
+)
+
+var (
+	promptKind  int
+	searchRegex bool
+)
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func openSearchPrompt() {
+	promptKind = promptSearch
+	e.OpenPrompt(searchLabel(nil))
+	if e.Search != nil {
+		e.Prompt.Input = []rune(e.Search.Query)
+	}
+}
+
+func openReplacePrompt() {
+	if e.Search == nil || len(e.Search.Matches) == 0 {
+		e.StatusChan <- "Nothing to replace, search with Ctrl+F first"
+		return
+	}
+
+	promptKind = promptReplace
+	e.OpenPrompt(fmt.Sprintf("Replace %d matches of %q with: ", len(e.Search.Matches), e.Search.Query))
+}
+
+func searchLabel(err error) string {
+	label := "Search: "
+	if searchRegex {
+		label = "Search (regex): "
+	}
+	if err != nil {
+		label = "[invalid] " + label
+	}
+	return label
+}
+
+// updateSearch re-runs the search each time the prompt input changes.
+func updateSearch() {
+	err := e.Find(e.Prompt.Text(), searchRegex)
+	e.Prompt.Label = searchLabel(err)
+}
+
+func handlePromptEvent(ev termbox.Event, conn *session.Conn) error {
+	switch ev.Key {
+	// cancel
+	case termbox.KeyEsc, termbox.KeyCtrlC:
+		if promptKind == promptSearch {
+			e.ClearSearch()
+		}
+		e.ClosePrompt()
+
+	// confirm
+	case termbox.KeyEnter:
+		input := e.Prompt.Text()
+		e.ClosePrompt()
+		switch promptKind {
+		case promptReplace:
+			replaceAll(input, conn)
+		case promptCommand:
+			return runVimCommand(input, conn)
+		case promptNewFile:
+			createDocument(input, conn)
+		case promptRenameFile:
+			renameDocument(tree.Selected(), input, conn)
+		case promptDeleteFile:
+			if input == "y" || input == "yes" {
+				deleteDocument(tree.Selected(), conn)
+			}
+		case promptComment:
+			addComment(input, conn)
+		case promptSnapshot:
+			takeSnapshot(input, conn)
+		case promptSaveAs:
+			return saveAs(input, conn)
+		}
+
+	// jump between matches while searching
+	case termbox.KeyArrowDown, termbox.KeyCtrlN:
+		e.NextMatch(1)
+
+	case termbox.KeyArrowUp, termbox.KeyCtrlP:
+		e.NextMatch(-1)
+
+	// toggle regular expressions
+	case termbox.KeyCtrlT:
+		if promptKind == promptSearch {
+			searchRegex = !searchRegex
+			updateSearch()
+		}
+
+	case termbox.KeyBackspace, termbox.KeyBackspace2:
+		e.Prompt.Backspace()
+		if promptKind == promptSearch {
+			updateSearch()
+		}
+
+	case termbox.KeySpace:
+		e.Prompt.Insert(' ')
+		if promptKind == promptSearch {
+			updateSearch()
+		}
+
+	default:
+		if ev.Ch != 0 {
+			e.Prompt.Insert(ev.Ch)
+			if promptKind == promptSearch {
+				updateSearch()
+			}
+		}
+	}
+	return nil
+}
+
+// replaceAll replaces every match of the current search and sends all the
+// resulting operations in a single batch message, so that collaborators
+// apply the replacement at once.
+func replaceAll(template string, conn *session.Conn) {
+	search := e.Search
+	if search == nil || len(search.Matches) == 0 {
+		return
+	}
+
+	var ops []commons.Operation
+	cursor := e.Cursor
+
+	// go from the last match to the first one, so earlier positions stay valid
+	for i := len(search.Matches) - 1; i >= 0; i-- {
+		m := search.Matches[i]
+		value := []rune(search.Replacement(i, template))
+
+		for j := m.Start; j < m.End; j++ {
+			op, err := localDelete(m.Start + 1)
+			if err != nil {
+				logger.Errorf("CRDT error: %v\n", err)
+				break
+			}
+			ops = append(ops, op)
+		}
+
+		for j, r := range value {
+			op, err := localInsert(m.Start+1+j, string(r))
+			if err != nil {
+				logger.Errorf("CRDT error: %v\n", err)
+			}
+			ops = append(ops, op)
+		}
+
+		delta := len(value) - (m.End - m.Start)
+		if m.End <= cursor {
+			cursor += delta
+		} else if m.Start < cursor {
+			cursor = m.Start
+		}
+
+		for name, user := range e.UsersPos {
+			if name != e.Username && m.End <= user.Pos {
+				e.UsersPos[name] = editor.CursorColPos{Pos: user.Pos + delta, Col: user.Col}
+			}
+		}
+	}
+
+	e.SetText(crdt.Content(*doc))
+	e.SetX(cursor)
+	e.Modified = true
+	e.StatusChan <- fmt.Sprintf("Replaced %d matches", len(search.Matches))
+	e.ClearSearch()
+
+	sendOperations(ops, conn)
+}
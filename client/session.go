@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"diploma/client/editor"
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// view is the editor state of a document that is not shown.
+type view struct {
+	cursor   int
+	rowOff   int
+	colOff   int
+	modified bool
+
+	remoteModified bool
+}
+
+var (
+	// docs holds every document of the session, keyed by its slash separated
+	// path relative to root. The empty path is the unnamed document.
+	docs = map[string]*crdt.Document{}
+
+	// pending holds the operations of other users on characters a document
+	// doesn't have yet, keyed like docs.
+	pending = map[string]*commons.Pending{}
+
+	// current is the path of the document shown in the editor.
+	current string
+
+	views = map[string]view{}
+
+	// root is the project directory documents are loaded from and saved to.
+	root = "."
+
+	tree = editor.NewFileTree(24)
+)
+
+// maxProjectFileSize limits the files loaded from a project directory.
+const maxProjectFileSize = 1 << 20
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// loadProject loads every text file below dir into the session.
+func loadProject(dir string) error {
+	root = dir
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if p != dir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// saved states are loaded along with their text files
+		if strings.HasSuffix(p, ".woot") || strings.HasSuffix(p, ".woot.tmp") {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Size() > maxProjectFileSize {
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil || bytes.IndexByte(content, 0) != -1 {
+			// unreadable or binary
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+
+		loaded, err := loadDocument(p)
+		if err != nil {
+			return fmt.Errorf("failed to load %s: %w", p, err)
+		}
+		docs[filepath.ToSlash(rel)] = &loaded
+		return nil
+	})
+}
+
+// loadFile loads a single file into the session under its path.
+func loadFile(name string) (string, error) {
+	p, err := cleanPath(name)
+	if err != nil {
+		return "", err
+	}
+
+	d, err := loadDocument(filepath.Join(root, name))
+	if err != nil {
+		return "", err
+	}
+	docs[p] = &d
+	return p, nil
+}
+
+// loadDocument loads the text file fileName. If the state saved next to it
+// holds the same text, the document is loaded from there instead, so that
+// it keeps the IDs it had in the session.
+func loadDocument(fileName string) (crdt.Document, error) {
+	d, err := crdt.Load(fileName)
+	if err != nil {
+		return d, err
+	}
+
+	s, err := crdt.ReadState(statePath(fileName))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		logger.Warnf("ignoring the state of %s: %v\n", fileName, err)
+	case crdt.Content(s.Document) != crdt.Content(d):
+		logger.Warnf("ignoring the state of %s, the file was changed since\n", fileName)
+	default:
+		crdt.Resume(s)
+		return s.Document, nil
+	}
+	return d, nil
+}
+
+// statePath returns the file the whole document saved to fileName goes to.
+func statePath(fileName string) string {
+	return fileName + ".woot"
+}
+
+var errBadPath = errors.New("path leaves the project directory")
+
+// cleanPath returns p as a clean slash separated path relative to root.
+// Absolute paths, paths with a volume name and paths with ".." are refused,
+// whether they are typed here or come from another user. Backslashes count
+// as separators, since the other user may be on Windows.
+func cleanPath(p string) (string, error) {
+	slashed := strings.ReplaceAll(filepath.ToSlash(p), `\`, "/")
+	if filepath.IsAbs(p) || filepath.VolumeName(p) != "" || path.IsAbs(slashed) || hasDrive(slashed) {
+		return "", fmt.Errorf("%w: %q", errBadPath, p)
+	}
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q", errBadPath, p)
+		}
+	}
+	return path.Clean(slashed), nil
+}
+
+// hasDrive reports whether p starts with a Windows drive letter like "C:".
+func hasDrive(p string) bool {
+	return len(p) >= 2 && p[1] == ':' && (p[0] >= 'a' && p[0] <= 'z' || p[0] >= 'A' && p[0] <= 'Z')
+}
+
+// savePath returns the file a document is saved to. The unnamed document
+// has to be named before saving, content.txt only stands for it. Paths of
+// docs went through cleanPath, so the file is always under root.
+func savePath(p string) string {
+	if p == "" {
+		return "content.txt"
+	}
+	return filepath.Join(root, filepath.FromSlash(p))
+}
+
+func docPaths() []string {
+	paths := make([]string, 0, len(docs))
+	for p := range docs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// switchDocument shows the document at p in the focused editor.
+func switchDocument(p string) {
+	d, ok := docs[p]
+	if !ok {
+		return
+	}
+
+	openDocument(e, p)
+
+	current = p
+	doc = d
+
+	tree.Current = p
+	tree.SetPaths(docPaths())
+	tree.Select(p)
+}
+
+// openDocument shows the document at p in ed, keeping the state of the
+// previous one.
+func openDocument(ed *editor.Editor, p string) {
+	if _, ok := docs[ed.FileName]; ok && p != ed.FileName {
+		views[ed.FileName] = view{cursor: ed.Cursor, rowOff: ed.RowOff, colOff: ed.ColOff, modified: ed.Modified, remoteModified: ed.RemoteModified}
+	}
+
+	v := views[p]
+	delete(views, p)
+
+	ed.FileName = p
+	ed.Highlighter = editor.NewHighlighter(flags.Lang, p)
+	ed.UsersPos = make(map[string]editor.CursorColPos)
+	ed.ClearSearch()
+	ed.ClearSelection()
+	ed.SetText(crdt.Content(*docs[p]))
+	ed.Cursor, ed.RowOff, ed.ColOff = v.cursor, v.rowOff, v.colOff
+	ed.Modified, ed.RemoteModified = v.modified, v.remoteModified
+}
+
+// cycleDocument switches to the next document in path order, or the
+// previous one if d is negative.
+func cycleDocument(d int) {
+	paths := docPaths()
+	for i, p := range paths {
+		if p == current {
+			switchDocument(paths[(i+d+len(paths))%len(paths)])
+			return
+		}
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func createDocument(p string, conn *session.Conn) {
+	p, err := cleanPath(p)
+	if err != nil {
+		e.StatusChan <- err.Error()
+		return
+	}
+	if p == "." {
+		return
+	}
+	if _, ok := docs[p]; ok {
+		e.StatusChan <- fmt.Sprintf("%s already exists", p)
+		return
+	}
+
+	d := crdt.New()
+	docs[p] = &d
+	switchDocument(p)
+
+	sendFileMessage(commons.Message{Type: commons.FileCreateMessage, Path: p}, conn)
+}
+
+func renameDocument(oldPath, newPath string, conn *session.Conn) {
+	newPath, err := cleanPath(newPath)
+	if err != nil {
+		e.StatusChan <- err.Error()
+		return
+	}
+	if newPath == "." || newPath == oldPath {
+		return
+	}
+	if _, ok := docs[newPath]; ok {
+		e.StatusChan <- fmt.Sprintf("%s already exists", newPath)
+		return
+	}
+
+	moveDocument(oldPath, newPath)
+	sendFileMessage(commons.Message{Type: commons.FileRenameMessage, Path: oldPath, NewPath: newPath}, conn)
+}
+
+func openSaveAsPrompt() {
+	promptKind = promptSaveAs
+	e.OpenPrompt("Save as: ")
+}
+
+// saveAs names the unnamed document after the file it's saved to.
+func saveAs(name string, conn *session.Conn) error {
+	if strings.TrimSpace(name) == "" {
+		return nil
+	}
+
+	oldPath := current
+	renameDocument(oldPath, name, conn)
+	if current == oldPath {
+		return nil
+	}
+
+	clearRecovery(oldPath)
+	return actionSave(termbox.Event{}, conn)
+}
+
+func deleteDocument(p string, conn *session.Conn) {
+	if _, ok := docs[p]; !ok {
+		return
+	}
+
+	removeDocument(p)
+	sendFileMessage(commons.Message{Type: commons.FileDeleteMessage, Path: p}, conn)
+}
+
+// moveDocument renames a document of the session.
+func moveDocument(oldPath, newPath string) {
+	d, ok := docs[oldPath]
+	if !ok {
+		return
+	}
+
+	delete(docs, oldPath)
+	docs[newPath] = d
+	if p, ok := pending[oldPath]; ok {
+		delete(pending, oldPath)
+		pending[newPath] = p
+	}
+	if v, ok := views[oldPath]; ok {
+		delete(views, oldPath)
+		views[newPath] = v
+	}
+
+	for _, ed := range editors() {
+		if ed.FileName == oldPath {
+			ed.FileName = newPath
+			ed.Highlighter = editor.NewHighlighter(flags.Lang, newPath)
+			ed.SetText(string(ed.GetText()))
+		}
+	}
+
+	if current == oldPath {
+		current = newPath
+		tree.Current = newPath
+	}
+	tree.SetPaths(docPaths())
+}
+
+// removeDocument deletes a document from the session. The session always
+// keeps at least the unnamed document.
+func removeDocument(p string) {
+	delete(docs, p)
+	delete(pending, p)
+	delete(views, p)
+
+	if len(docs) == 0 {
+		d := crdt.New()
+		docs[""] = &d
+	}
+
+	for _, ed := range editors() {
+		if ed.FileName == p && ed != e {
+			openDocument(ed, docPaths()[0])
+		}
+	}
+
+	if current == p {
+		switchDocument(docPaths()[0])
+	}
+	tree.SetPaths(docPaths())
+}
+
+func sendFileMessage(msg commons.Message, conn *session.Conn) {
+	msg.Username = e.Username
+	if e.IsConnected {
+		err := conn.Send(msg)
+		if err != nil {
+			e.IsConnected = false
+			e.StatusChan <- "lost connection!"
+		}
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// handleFileMessage applies a file change made by another user. New paths
+// that would be saved outside of root are ignored.
+func handleFileMessage(msg commons.Message) {
+	switch msg.Type {
+	case commons.FileCreateMessage:
+		p, err := cleanPath(msg.Path)
+		if err != nil || p == "." {
+			logger.Errorf("ignoring %s of %q by %s: %v\n", msg.Type, msg.Path, msg.Username, err)
+			return
+		}
+		if _, ok := docs[p]; !ok {
+			d := crdt.New()
+			docs[p] = &d
+			tree.SetPaths(docPaths())
+		}
+		e.StatusChan <- fmt.Sprintf("%s created %s", msg.Username, p)
+
+	case commons.FileRenameMessage:
+		p, err := cleanPath(msg.NewPath)
+		if err != nil || p == "." {
+			logger.Errorf("ignoring %s to %q by %s: %v\n", msg.Type, msg.NewPath, msg.Username, err)
+			return
+		}
+		if _, ok := docs[p]; ok && p != msg.Path {
+			logger.Errorf("ignoring %s of %q to %q by %s: %s already exists\n", msg.Type, msg.Path, p, msg.Username, p)
+			e.StatusChan <- fmt.Sprintf("%s tried to rename %s to %s, which already exists", msg.Username, msg.Path, p)
+			return
+		}
+		moveDocument(msg.Path, p)
+		e.StatusChan <- fmt.Sprintf("%s renamed %s to %s", msg.Username, msg.Path, p)
+
+	case commons.FileDeleteMessage:
+		removeDocument(msg.Path)
+		e.StatusChan <- fmt.Sprintf("%s deleted %s", msg.Username, msg.Path)
+	}
+}
+
+// syncDocument stores a document received from another user.
+func syncDocument(p string, d crdt.Document) {
+	if p != "" {
+		clean, err := cleanPath(p)
+		if err != nil || clean == "." {
+			logger.Errorf("ignoring document %q: %v\n", p, err)
+			return
+		}
+		p = clean
+	}
+
+	// a fresh session drops its empty unnamed document for the shared ones
+	if unnamed, ok := docs[""]; ok && p != "" && len(docs) == 1 && crdt.Content(*unnamed) == "" {
+		delete(docs, "")
+	}
+
+	// what's already here, like recovered unsaved changes, is kept
+	if local, ok := docs[p]; ok {
+		merged, err := crdt.Merge(crdt.New(), *local, d)
+		switch {
+		case err == nil:
+			d = merged
+		case unsaved(p):
+			logger.Errorf("keeping the unsaved changes to %q over the document received: %v\n", p, err)
+			e.StatusChan <- fmt.Sprintf("%s differs from the shared document, keeping the unsaved changes", displayName(p))
+			return
+		default:
+			logger.Warnf("replacing %q with the document received: %v\n", p, err)
+		}
+	}
+
+	docs[p] = &d
+	if waiting, ok := pending[p]; ok {
+		if _, err := waiting.Retry(docs[p]); err != nil {
+			logger.Errorf("failed to apply waiting operations on %q, err: %v\n", p, err)
+		}
+	}
+	for _, ed := range editors() {
+		if ed.FileName == p {
+			ed.SetText(crdt.Content(d))
+		}
+	}
+
+	if current == p {
+		doc = docs[p]
+	} else if _, ok := docs[current]; !ok {
+		switchDocument(p)
+	}
+	tree.SetPaths(docPaths())
+}
+
+// sendDocuments sends every document of the session to the user with id.
+func sendDocuments(conn *session.Conn, msg commons.Message) {
+	for _, p := range docPaths() {
+		docMsg := commons.Message{Type: commons.DocSyncMessage, Document: *docs[p], Path: p, ID: msg.ID}
+		_ = conn.Send(docMsg)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// handleTreeEvent handles keys while the file tree has the focus.
+func handleTreeEvent(ev termbox.Event, conn *session.Conn) {
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Key == termbox.KeyCtrlP || ev.Ch == 'k':
+		tree.Move(-1)
+
+	case ev.Key == termbox.KeyArrowDown || ev.Key == termbox.KeyCtrlN || ev.Ch == 'j':
+		tree.Move(1)
+
+	// open the selected document
+	case ev.Key == termbox.KeyEnter:
+		switchDocument(tree.Selected())
+		tree.Focused = false
+
+	// give the focus back to the editor
+	case ev.Key == termbox.KeyEsc || ev.Key == termbox.KeyTab:
+		tree.Focused = false
+
+	case ev.Key == termbox.KeyF8:
+		tree.Visible, tree.Focused = false, false
+		layout()
+
+	case ev.Ch == 'n':
+		promptKind = promptNewFile
+		e.OpenPrompt("New file: ")
+
+	case ev.Ch == 'r':
+		promptKind = promptRenameFile
+		e.OpenPrompt(fmt.Sprintf("Rename %s to: ", tree.Selected()))
+		e.Prompt.Input = []rune(tree.Selected())
+
+	case ev.Ch == 'd':
+		promptKind = promptDeleteFile
+		e.OpenPrompt(fmt.Sprintf("Delete %s for everyone? (y/n) ", tree.Selected()))
+	}
+}
+
+// displayName names the document at path for the user.
+func displayName(path string) string {
+	if path == "" {
+		return "[No Name]"
+	}
+	return path
+}
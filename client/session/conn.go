@@ -0,0 +1,151 @@
+package session
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"diploma/commons"
+
+	"github.com/gorilla/websocket"
+)
+
+// Conn is a connection to the server. It's what the terminal client and
+// Session have in common: joining, the site ID and the messages both ways.
+type Conn struct {
+	conn *websocket.Conn
+	site int
+
+	writeMu sync.Mutex
+
+	// early holds the messages that came before the site ID.
+	early []commons.Message
+	msgs  chan commons.Message
+
+	closing   chan struct{}
+	closeOnce sync.Once
+	done      chan struct{}
+	err       error
+}
+
+// Dial connects to the session served at url, a ws:// or wss:// address,
+// waits for the server to hand out a site ID and joins as name.
+func Dial(url, name string) (*Conn, error) {
+	dialer := websocket.Dialer{HandshakeTimeout: 30 * time.Second}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{conn: conn, msgs: make(chan commons.Message), closing: make(chan struct{}), done: make(chan struct{})}
+
+	// the site ID comes first, before any operation of this site
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	for c.site == 0 {
+		var msg commons.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if msg.Type != commons.SiteIDMessage {
+			c.early = append(c.early, msg)
+			continue
+		}
+		if c.site, err = strconv.Atoi(msg.Text); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	join := commons.Message{Username: name, Text: "has joined the session.", Type: commons.JoinMessage}
+	if err := c.Send(join); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go c.readLoop()
+	return c, nil
+}
+
+// Site returns the site ID the server handed out.
+func (c *Conn) Site() int {
+	return c.site
+}
+
+// Messages returns the messages of the server, other than the site ID. It's
+// closed once the connection ends.
+func (c *Conn) Messages() <-chan commons.Message {
+	return c.msgs
+}
+
+// Send sends a message to the server. It's safe to call from several
+// goroutines.
+func (c *Conn) Send(msg commons.Message) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+// Done is closed once the connection ended.
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that ended the connection, nil if it was closed.
+// It's only set once Done is closed.
+func (c *Conn) Err() error {
+	return c.err
+}
+
+// Close leaves the session. Messages not read by then are dropped.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() { close(c.closing) })
+
+	c.writeMu.Lock()
+	_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	c.writeMu.Unlock()
+
+	err := c.conn.Close()
+	<-c.done
+	return err
+}
+
+func (c *Conn) readLoop() {
+	defer close(c.done)
+	defer close(c.msgs)
+
+	for _, msg := range c.early {
+		if !c.deliver(msg) {
+			return
+		}
+	}
+	c.early = nil
+
+	for {
+		var msg commons.Message
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) && !errors.Is(err, net.ErrClosed) {
+				c.err = err
+			}
+			return
+		}
+		if !c.deliver(msg) {
+			return
+		}
+	}
+}
+
+// deliver hands msg to the reader of Messages, false if the connection is
+// closed meanwhile.
+func (c *Conn) deliver(msg commons.Message) bool {
+	select {
+	case c.msgs <- msg:
+		return true
+	case <-c.closing:
+		return false
+	}
+}
@@ -0,0 +1,262 @@
+// Package session is a headless client: it joins a session on the server,
+// keeps one document up to date with the other users and edits it, without
+// a terminal.
+package session
+
+import (
+	"sync"
+	"unicode/utf8"
+
+	"diploma/commons"
+	"diploma/crdt"
+)
+
+// Config is how a session shows up to the others.
+type Config struct {
+	// Name is the username, Path the document edited. The empty path is the
+	// unnamed document.
+	Name string
+	Path string
+}
+
+// Session is a connection to the server along with its copy of the
+// document.
+type Session struct {
+	conf Config
+	conn *Conn
+
+	mu      sync.Mutex
+	doc     crdt.Document
+	pending commons.Pending
+
+	// onRemoteOp is called with the operations of others once applied.
+	onRemoteOp func(commons.RemoteOp)
+
+	synced     chan struct{}
+	syncedOnce sync.Once
+
+	done chan struct{}
+	err  error
+}
+
+// Connect joins the session served at url, a ws:// or wss:// address, and
+// waits for the server to hand out a site ID.
+func Connect(url string, conf Config) (*Session, error) {
+	conn, err := Dial(url, conf.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Session{conf: conf, conn: conn, doc: crdt.New(), synced: make(chan struct{}), done: make(chan struct{})}
+	go s.loop()
+	return s, nil
+}
+
+// Site returns the site ID the server handed out.
+func (s *Session) Site() int {
+	return s.conn.Site()
+}
+
+// OnRemoteOp sets f to be called with every operation of other users once
+// it's applied, with the 1-based position it took. It's called from the
+// goroutine reading the connection, which waits for it to return. The
+// documents received when joining don't go through it.
+func (s *Session) OnRemoteOp(f func(commons.RemoteOp)) {
+	s.mu.Lock()
+	s.onRemoteOp = f
+	s.mu.Unlock()
+}
+
+// Synced is closed once another user sent the document. Nobody sends it to
+// the first user of a session.
+func (s *Session) Synced() <-chan struct{} {
+	return s.synced
+}
+
+// Done is closed once the session ended.
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Content returns the text of the document.
+func (s *Session) Content() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return crdt.Content(s.doc)
+}
+
+// Document returns a copy of the CRDT state of the document.
+func (s *Session) Document() crdt.Document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return crdt.Copy(s.doc)
+}
+
+// Insert inserts text before the rune at the 0-based pos and sends it to
+// the others.
+func (s *Session) Insert(pos int, text string) error {
+	return s.insert(pos, text)
+}
+
+// Append inserts text at the end of the document and sends it to the
+// others.
+func (s *Session) Append(text string) error {
+	return s.insert(-1, text)
+}
+
+// insert inserts text at pos, or at the end if pos is -1.
+func (s *Session) insert(pos int, text string) error {
+	s.mu.Lock()
+	length := utf8.RuneCountInString(crdt.Content(s.doc))
+	if pos == -1 {
+		pos = length
+	}
+	if pos < 0 || pos > length {
+		s.mu.Unlock()
+		return crdt.ErrPositionOutOfBounds
+	}
+
+	var ops []commons.Operation
+	for i, r := range []rune(text) {
+		op, err := commons.InsertAs(&s.doc, s.conn.Site(), pos+i+1, string(r))
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		ops = append(ops, op)
+	}
+	s.mu.Unlock()
+
+	return s.sendOperations(ops)
+}
+
+// Delete deletes n runes from the 0-based pos and sends it to the others.
+func (s *Session) Delete(pos, n int) error {
+	s.mu.Lock()
+	if pos < 0 || n < 0 || pos+n > utf8.RuneCountInString(crdt.Content(s.doc)) {
+		s.mu.Unlock()
+		return crdt.ErrPositionOutOfBounds
+	}
+
+	var ops []commons.Operation
+	for i := 0; i < n; i++ {
+		op, err := commons.Delete(&s.doc, pos+1)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		ops = append(ops, op)
+	}
+	s.mu.Unlock()
+
+	return s.sendOperations(ops)
+}
+
+// Close leaves the session. It returns the error that ended it, if it
+// ended before.
+func (s *Session) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	if s.err != nil {
+		return s.err
+	}
+	return err
+}
+
+// Dropped returns the number of operations of others that were malformed
+// or waited too long for their characters.
+func (s *Session) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending.Dropped()
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (s *Session) sendOperations(ops []commons.Operation) error {
+	switch len(ops) {
+	case 0:
+		return nil
+	case 1:
+		return s.send(commons.Message{Username: s.conf.Name, Type: "operation", Path: s.conf.Path, Operation: ops[0]})
+	}
+	return s.send(commons.Message{Username: s.conf.Name, Type: commons.BatchMessage, Path: s.conf.Path, Operations: ops})
+}
+
+func (s *Session) send(msg commons.Message) error {
+	return s.conn.Send(msg)
+}
+
+func (s *Session) loop() {
+	defer close(s.done)
+
+	for msg := range s.conn.Messages() {
+		if err := s.handle(msg); err != nil {
+			s.err = err
+			s.conn.Close()
+			return
+		}
+	}
+	s.err = s.conn.Err()
+}
+
+func (s *Session) handle(msg commons.Message) error {
+	switch msg.Type {
+	// send current doc
+	case commons.DocReqMessage:
+		doc := s.Document()
+		return s.send(commons.Message{Type: commons.DocSyncMessage, Document: doc, Path: s.conf.Path, ID: msg.ID})
+
+	// recieve current doc, it may miss operations that already arrived
+	case commons.DocSyncMessage:
+		if msg.Path != s.conf.Path {
+			return nil
+		}
+		s.mu.Lock()
+		merged, err := crdt.Merge(crdt.New(), s.doc, msg.Document)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.doc = merged
+		// operations that fail are counted by Dropped
+		_, _ = s.pending.Retry(&s.doc)
+		s.mu.Unlock()
+
+		s.syncedOnce.Do(func() { close(s.synced) })
+		return nil
+
+	case "operation":
+		if msg.Path != s.conf.Path {
+			return nil
+		}
+		s.receive(msg.Username, []commons.Operation{msg.Operation})
+
+	case commons.BatchMessage:
+		if msg.Path != s.conf.Path {
+			return nil
+		}
+		s.receive(msg.Username, msg.Operations)
+	}
+	return nil
+}
+
+// receive integrates the operations of username, then tells onRemoteOp
+// about the ones that changed the text, which may include waiting ones of
+// other users. Operations that fail are counted by Dropped.
+func (s *Session) receive(username string, ops []commons.Operation) {
+	s.mu.Lock()
+	var applied []commons.RemoteOp
+	for _, op := range ops {
+		done, _ := s.pending.Integrate(&s.doc, commons.RemoteOp{Username: username, Operation: op})
+		applied = append(applied, done...)
+	}
+	f := s.onRemoteOp
+	s.mu.Unlock()
+
+	if f != nil {
+		for _, op := range applied {
+			f(op)
+		}
+	}
+}
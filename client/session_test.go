@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"diploma/client/editor"
+	"diploma/commons"
+	"diploma/crdt"
+)
+
+// startSession opens a session holding only the document at p.
+func startSession(t *testing.T, p string) {
+	t.Helper()
+	logger.SetOutput(io.Discard)
+
+	d := crdt.New()
+	docs = map[string]*crdt.Document{p: &d}
+	current, doc = p, &d
+	views = map[string]view{}
+
+	e = editor.NewEditor(editor.EditorConfig{FileName: p})
+	root := &pane{editor: e}
+	tabs = []*tab{{root: root, focus: root}}
+}
+
+func TestCleanPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"a.txt", "a.txt"},
+		{"dir/a.txt", "dir/a.txt"},
+		{"dir//./a.txt", "dir/a.txt"},
+		{`dir\a.txt`, "dir/a.txt"},
+		{"dir/", "dir"},
+		{"", "."},
+		{"..a", "..a"},
+	}
+	for _, tt := range tests {
+		got, err := cleanPath(tt.path)
+		if err != nil || got != tt.want {
+			t.Errorf("cleanPath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	for _, p := range []string{
+		"/etc/passwd",
+		"..",
+		"../a.txt",
+		"dir/../../a.txt",
+		"dir/../a.txt",
+		`..\a.txt`,
+		`\a.txt`,
+		`C:\a.txt`,
+		"c:a.txt",
+		"//server/share/a.txt",
+	} {
+		if got, err := cleanPath(p); !errors.Is(err, errBadPath) {
+			t.Errorf("cleanPath(%q) = %q, %v, want errBadPath", p, got, err)
+		}
+	}
+}
+
+func TestRemotePaths(t *testing.T) {
+	startSession(t, "a.txt")
+
+	for _, msg := range []commons.Message{
+		{Type: commons.FileCreateMessage, Path: "../evil.txt"},
+		{Type: commons.FileCreateMessage, Path: "/tmp/evil.txt"},
+		{Type: commons.FileCreateMessage, Path: ""},
+		{Type: commons.FileRenameMessage, Path: "a.txt", NewPath: "../../evil.txt"},
+		{Type: commons.FileRenameMessage, Path: "a.txt", NewPath: `C:\evil.txt`},
+	} {
+		handleFileMessage(msg)
+	}
+	syncDocument("../evil.txt", crdt.New())
+
+	if len(docs) != 1 || docs["a.txt"] == nil {
+		t.Fatalf("documents after bad paths: %v", docPaths())
+	}
+
+	handleFileMessage(commons.Message{Type: commons.FileCreateMessage, Path: "dir/b.txt"})
+	handleFileMessage(commons.Message{Type: commons.FileRenameMessage, Path: "a.txt", NewPath: "dir/./c.txt"})
+	if docs["dir/b.txt"] == nil || docs["dir/c.txt"] == nil || docs["a.txt"] != nil {
+		t.Fatalf("documents after good paths: %v", docPaths())
+	}
+
+	// renaming onto another document would drop it
+	b, c := docs["dir/b.txt"], docs["dir/c.txt"]
+	handleFileMessage(commons.Message{Type: commons.FileRenameMessage, Path: "dir/c.txt", NewPath: "dir/b.txt"})
+	if len(docs) != 2 || docs["dir/b.txt"] != b || docs["dir/c.txt"] != c {
+		t.Fatalf("documents after renaming onto another: %v", docPaths())
+	}
+}
+
+func TestLocalPaths(t *testing.T) {
+	startSession(t, "a.txt")
+
+	createDocument("../evil.txt", nil)
+	renameDocument("a.txt", "/tmp/evil.txt", nil)
+	if len(docs) != 1 || docs["a.txt"] == nil {
+		t.Fatalf("documents after bad paths: %v", docPaths())
+	}
+
+	if _, err := loadFile("../evil.txt"); !errors.Is(err, errBadPath) {
+		t.Errorf("loadFile: got %v, want errBadPath", err)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"diploma/client/editor"
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+	"diploma/diff"
+
+	"github.com/nsf/termbox-go"
+)
+
+var (
+	// snapshotList lists the snapshots of the current document followed by
+	// the live text. It's nil unless shown.
+	snapshotList *editor.DiffView
+
+	// diffView shows a diff over the list, or is nil.
+	diffView *editor.DiffView
+
+	snapshots []commons.Snapshot
+
+	// pendingDiff holds the names given to :diff until the snapshots arrive.
+	pendingDiff []string
+)
+
+// liveName stands for the live text in :diff and diff headers.
+const liveName = "live"
+
+func openSnapshotPrompt() {
+	promptKind = promptSnapshot
+	e.OpenPrompt("Snapshot name: ")
+}
+
+// takeSnapshot stores the current document on the server under a name.
+func takeSnapshot(name string, conn *session.Conn) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	if !e.IsConnected {
+		e.StatusChan <- "Snapshots need a connection to the server"
+		return
+	}
+
+	msg := commons.Message{Username: e.Username, Type: commons.SnapshotMessage, Text: name, Path: current, Document: *doc}
+	if err := conn.Send(msg); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+		return
+	}
+	e.StatusChan <- fmt.Sprintf("Took snapshot %q", name)
+}
+
+func requestSnapshots(conn *session.Conn) {
+	if !e.IsConnected {
+		e.StatusChan <- "Snapshots need a connection to the server"
+		return
+	}
+
+	msg := commons.Message{Username: e.Username, Type: commons.SnapshotsReqMessage, Path: current}
+	if err := conn.Send(msg); err != nil {
+		e.IsConnected = false
+		e.StatusChan <- "lost connection!"
+	}
+}
+
+// requestDiff diffs two snapshots, or a snapshot and the live text, once
+// the server sent them.
+func requestDiff(names []string, conn *session.Conn) {
+	if len(names) == 1 {
+		names = append(names, liveName)
+	}
+	if len(names) != 2 {
+		e.StatusChan <- "Usage: :diff <snapshot> [snapshot]"
+		return
+	}
+
+	pendingDiff = names
+	requestSnapshots(conn)
+}
+
+func handleSnapshotMessage(msg commons.Message) {
+	switch msg.Type {
+	case commons.SnapshotMessage:
+		e.StatusChan <- fmt.Sprintf("%s took snapshot %q of %s", msg.Username, msg.Text, displayName(msg.Path))
+
+	case commons.SnapshotsMessage:
+		if msg.Path != current {
+			return
+		}
+		snapshots = msg.Snapshots
+
+		if pendingDiff != nil {
+			names := pendingDiff
+			pendingDiff = nil
+			diffNames(names[0], names[1])
+			return
+		}
+		openSnapshots()
+	}
+}
+
+// openSnapshots lists the snapshots received from the server.
+func openSnapshots() {
+	lines := make([]string, 0, len(snapshots)+1)
+	for _, s := range snapshots {
+		lines = append(lines, fmt.Sprintf("%-24s %s  %s", s.Name, s.Time.Local().Format("Jan 2 15:04:05"), s.Username))
+	}
+	lines = append(lines, "(live text)")
+
+	snapshotList = editor.NewDiffView("Snapshots of "+displayName(current), lines, true)
+	snapshotList.Selected = len(lines) - 1
+	diffView = nil
+}
+
+func closeSnapshots() {
+	snapshotList, diffView = nil, nil
+}
+
+// snapshotText returns the text of the i-th snapshot, the live text past
+// the last one.
+func snapshotText(i int) (string, string) {
+	if i >= len(snapshots) {
+		return liveName, crdt.Content(*doc)
+	}
+	return snapshots[i].Name, crdt.Content(snapshots[i].Document)
+}
+
+// diffNames shows the diff between two snapshots given by name.
+func diffNames(a, b string) {
+	index := func(name string) int {
+		if name == liveName {
+			return len(snapshots)
+		}
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if snapshots[i].Name == name {
+				return i
+			}
+		}
+		return -1
+	}
+
+	i, j := index(a), index(b)
+	switch {
+	case i < 0:
+		e.StatusChan <- fmt.Sprintf("No snapshot named %q", a)
+	case j < 0:
+		e.StatusChan <- fmt.Sprintf("No snapshot named %q", b)
+	default:
+		showDiff(i, j)
+	}
+}
+
+// showDiff shows the unified diff from the i-th to the j-th snapshot.
+func showDiff(i, j int) {
+	nameA, a := snapshotText(i)
+	nameB, b := snapshotText(j)
+
+	unified := diff.Unified(nameA, nameB, a, b, 3)
+	if unified == "" {
+		e.StatusChan <- fmt.Sprintf("No differences between %s and %s", nameA, nameB)
+		return
+	}
+
+	diffView = editor.NewDiffView(fmt.Sprintf("%s: %s → %s", displayName(current), nameA, nameB), diff.SplitLines(unified), false)
+	if snapshotList == nil {
+		openSnapshots()
+	}
+}
+
+// drawSnapshots draws the snapshots or the diff over the whole screen.
+func drawSnapshots() {
+	w, h := termbox.Size()
+	termbox.HideCursor()
+
+	if diffView != nil {
+		diffView.Draw(0, 0, w, h, " j/k scroll  PgUp/PgDn page  Esc back  q close")
+		return
+	}
+	snapshotList.Draw(0, 0, w, h, " j/k move  m mark  Enter diff against the mark or the live text  Esc close")
+}
+
+// handleSnapshotsEvent handles keys while the snapshots are shown.
+func handleSnapshotsEvent(ev termbox.Event) {
+	_, h := termbox.Size()
+
+	if diffView != nil {
+		switch {
+		case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+			diffView.Move(-1)
+		case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+			diffView.Move(1)
+		case ev.Key == termbox.KeyPgup:
+			diffView.Move(-(h - 2))
+		case ev.Key == termbox.KeyPgdn || ev.Key == termbox.KeySpace:
+			diffView.Move(h - 2)
+		case ev.Key == termbox.KeyEsc:
+			diffView = nil
+		case ev.Ch == 'q':
+			closeSnapshots()
+		}
+		return
+	}
+
+	switch {
+	case ev.Key == termbox.KeyArrowUp || ev.Ch == 'k':
+		snapshotList.Move(-1)
+	case ev.Key == termbox.KeyArrowDown || ev.Ch == 'j':
+		snapshotList.Move(1)
+
+	// mark the base of the next diff
+	case ev.Ch == 'm' || ev.Key == termbox.KeySpace:
+		if snapshotList.Marked == snapshotList.Selected {
+			snapshotList.Marked = -1
+		} else {
+			snapshotList.Marked = snapshotList.Selected
+		}
+
+	case ev.Key == termbox.KeyEnter:
+		from, to := snapshotList.Marked, snapshotList.Selected
+		if from < 0 || from == to {
+			from, to = to, len(snapshots)
+		}
+		if from == to {
+			e.StatusChan <- "Mark a snapshot with m to compare it with the live text"
+			return
+		}
+		showDiff(from, to)
+
+	case ev.Key == termbox.KeyEsc || ev.Ch == 'q':
+		closeSnapshots()
+	}
+}
@@ -1,56 +1,94 @@
 package main
 
 import (
+	"time"
+
 	"diploma/client/editor"
+	"diploma/client/session"
 
 	"diploma/crdt"
 
-	"github.com/gorilla/websocket"
 	"github.com/nsf/termbox-go"
 )
 
 type UIConfig struct {
 	EditorConfig editor.EditorConfig
+	Vim          bool
 }
 
-func mainLoop(conn *websocket.Conn) error {
+func mainLoop(conn *session.Conn) error {
 	termboxChan := getTermboxChan()
-	msgChan := getMsgChan(conn)
+	msgChan := conn.Messages()
+
+	var autosaveChan <-chan time.Time
+	if flags.Autosave > 0 {
+		ticker := time.NewTicker(flags.Autosave)
+		defer ticker.Stop()
+		autosaveChan = ticker.C
+	}
 
 	for {
 		select {
+		case <-autosaveChan:
+			autosave()
 		case termboxEvent := <-termboxChan:
 			err := handleTermboxEvent(termboxEvent, conn)
 			if err != nil {
 				return err
 			}
-		case msg := <-msgChan:
+		case msg, ok := <-msgChan:
+			if !ok {
+				if err := conn.Err(); err != nil {
+					logger.Errorf("websocket error: %v", err)
+				}
+				e.IsConnected = false
+				e.StatusChan <- "lost connection!"
+				msgChan = nil
+				continue
+			}
+			logger.Infof("message received: %+v\n", msg)
 			handleMsg(msg, conn)
 		}
 	}
 }
 
-func initUI(conn *websocket.Conn, conf UIConfig) error {
+func initUI(conn *session.Conn, conf UIConfig) error {
 	err := termbox.Init()
 	if err != nil {
 		return err
 	}
 	defer termbox.Close()
 
+	termbox.SetInputMode(termbox.InputEsc | termbox.InputMouse)
+
 	e = editor.NewEditor(conf.EditorConfig)
-	e.SetSize(termbox.Size())
-	e.SetText(crdt.Content(doc))
+	root := &pane{editor: e}
+	tabs = []*tab{{root: root, focus: root}}
+	layout()
+	e.SetText(crdt.Content(*doc))
+	if v, ok := views[current]; ok {
+		// recovered changes
+		e.Modified = v.modified
+		delete(views, current)
+	}
+	tree.Current = current
+	tree.SetPaths(docPaths())
+	tree.Select(current)
 	e.SendDraw()
 	e.IsConnected = true
 
+	if conf.Vim {
+		vim = NewVim()
+	}
+
 	go handleStatusMsg()
 
 	go drawLoop()
 
 	err = mainLoop(conn)
-	if err != nil {
-		return err
-	}
 
-	return nil
+	// whatever is left unsaved can be recovered on the next start
+	autosave()
+
+	return err
 }
@@ -4,30 +4,41 @@ import (
 	"flag"
 	"fmt"
 	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"time"
 
 	"diploma/crdt"
 
-	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 	"github.com/sirupsen/logrus/hooks/writer"
 )
 
 type Flags struct {
 	Server string
+	Room   string
 	Secure bool
 	Login  bool
 	File   string
+	Dir    string
+	Lang   string
 	Debug  bool
 	Scroll bool
+	Lines  bool
+	Wrap   bool
+	Keymap string
+	Vim    bool
+
+	// Autosave is the interval unsaved documents are written to the
+	// recovery directory at, 0 to only do it on exit.
+	Autosave time.Duration
 }
 
 func parseFlags() Flags {
 	serverAddr := flag.String("server", "localhost:8080", "The network address of the server")
 
+	room := flag.String("room", "", "The room of the server to join, each one is a separate session")
+
 	useSecureConn := flag.Bool("secure", false, "Enable a secure WebSocket connection (wss://)")
 
 	enableDebug := flag.Bool("debug", false, "Enable debugging mode to show more verbose logs")
@@ -36,34 +47,52 @@ func parseFlags() Flags {
 
 	file := flag.String("file", "", "The file to load the pairpad content from")
 
+	dir := flag.String("dir", "", "The project directory to share all files from")
+
+	lang := flag.String("lang", "", "The language for syntax highlighting (detected from -file by default)")
+
 	enableScroll := flag.Bool("scroll", true, "Enable scrolling with the cursor")
 
+	enableLines := flag.Bool("numbers", false, "Show the line number gutter (toggle with F2)")
+
+	enableWrap := flag.Bool("wrap", false, "Soft-wrap long lines instead of scrolling sideways (toggle with Ctrl+W)")
+
+	keymapFile := flag.String("keymap", defaultKeymapPath(), "The JSON file with custom key bindings")
+
+	enableVim := flag.Bool("vim", false, "Enable modal vim-style editing")
+
+	autosave := flag.Duration("autosave", 30*time.Second, "How often to write unsaved changes to the recovery directory (0 to only do it on exit)")
+
 	flag.Parse()
 
 	return Flags{
 		Server: *serverAddr,
+		Room:   *room,
 		Secure: *useSecureConn,
 		Debug:  *enableDebug,
 		Login:  *enableLogin,
 		File:   *file,
+		Dir:    *dir,
+		Lang:   *lang,
 		Scroll: *enableScroll,
+		Lines:  *enableLines,
+		Wrap:   *enableWrap,
+		Keymap: *keymapFile,
+		Vim:    *enableVim,
+
+		Autosave: *autosave,
 	}
 }
 
-func createConn(flags Flags) (*websocket.Conn, *http.Response, error) {
+// serverURL returns the WebSocket address of the room on the server.
+func serverURL(flags Flags) string {
 	var u url.URL
 	if flags.Secure {
-		u = url.URL{Scheme: "wss", Host: flags.Server, Path: "/"}
+		u = url.URL{Scheme: "wss", Host: flags.Server, Path: "/" + flags.Room}
 	} else {
-		u = url.URL{Scheme: "ws", Host: flags.Server, Path: "/"}
+		u = url.URL{Scheme: "ws", Host: flags.Server, Path: "/" + flags.Room}
 	}
-
-	// get WebSocket connection
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 2 * time.Minute,
-	}
-
-	return dialer.Dial(u.String(), nil)
+	return u.String()
 }
 
 // ////////////////////////////////////////////////////////////////////
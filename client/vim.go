@@ -0,0 +1,681 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"diploma/client/editor"
+	"diploma/client/session"
+
+	"github.com/nsf/termbox-go"
+)
+
+// Vim is an optional modal layer over the editor. Every edit it makes goes
+// through the regular local operations, so the collaboration code doesn't
+// know about modes.
+type Vim struct {
+	mode    vimMode
+	count   int
+	pending rune // operator waiting for its motion: 'd', 'y' or 'g'
+
+	register string
+	linewise bool
+
+	// lastChange repeats the last edit for the '.' command.
+	lastChange func(conn *session.Conn)
+
+	// the command that started the current insert session and the keys
+	// typed since, so the session can be repeated
+	enterInsert func(conn *session.Conn)
+	recording   []termbox.Event
+}
+
+type vimMode int
+
+const (
+	modeNormal vimMode = iota
+	modeInsert
+	modeVisual
+)
+
+var (
+	// vim is nil unless modal editing is enabled.
+	vim *Vim
+
+	errQuit = errors.New("pairpad: exiting")
+)
+
+func NewVim() *Vim {
+	v := &Vim{}
+	v.setMode(modeNormal)
+	return v
+}
+
+func (v *Vim) setMode(m vimMode) {
+	v.mode = m
+	v.count = 0
+	v.pending = 0
+
+	switch m {
+	case modeNormal:
+		e.Mode = "NORMAL"
+		e.ClearSelection()
+	case modeInsert:
+		e.Mode = "-- INSERT --"
+		e.ClearSelection()
+	case modeVisual:
+		e.Mode = "-- VISUAL --"
+		e.Selection = &editor.Selection{Anchor: e.Cursor, Inclusive: true}
+	}
+}
+
+// handleKey handles a key event and reports whether it was consumed. Keys
+// that are not consumed go to the keymap.
+func (v *Vim) handleKey(ev termbox.Event, conn *session.Conn) (bool, error) {
+	switch v.mode {
+	case modeInsert:
+		if ev.Key == termbox.KeyEsc {
+			v.finishInsert()
+			return true, nil
+		}
+		v.recording = append(v.recording, ev)
+		return false, nil
+
+	case modeVisual:
+		return v.visualKey(ev, conn)
+
+	default:
+		return v.normalKey(ev, conn)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (v *Vim) normalKey(ev termbox.Event, conn *session.Conn) (bool, error) {
+	if ev.Ch == 0 {
+		switch ev.Key {
+		case termbox.KeyEsc:
+			v.count, v.pending = 0, 0
+		case termbox.KeyBackspace, termbox.KeyBackspace2:
+			e.MoveCursor(-1, 0)
+		case termbox.KeySpace:
+			e.MoveCursor(1, 0)
+		case termbox.KeyEnter:
+			e.MoveCursor(0, 1)
+		case termbox.KeyDelete:
+			v.change(conn, deleteChars)
+		case termbox.KeyTab:
+		default:
+			return false, nil
+		}
+		return true, nil
+	}
+
+	ch := ev.Ch
+
+	// numeric prefix
+	if unicode.IsDigit(ch) && (ch != '0' || v.count > 0) {
+		v.count = v.count*10 + int(ch-'0')
+		return true, nil
+	}
+	n := v.count
+	if n == 0 {
+		n = 1
+	}
+
+	if v.pending != 0 {
+		op := v.pending
+		v.pending = 0
+		v.count = 0
+		v.operator(op, ch, n, conn)
+		return true, nil
+	}
+	v.count = 0
+
+	if v.motion(ch, n) {
+		return true, nil
+	}
+
+	switch ch {
+	case 'd', 'y', 'g':
+		v.pending = ch
+		v.count = n
+		if n == 1 {
+			v.count = 0
+		}
+
+	case 'x':
+		v.repeat(n, conn, deleteChars)
+
+	case 'X':
+		v.repeat(n, conn, deleteCharBefore)
+
+	case 'p':
+		v.repeat(n, conn, v.pasteAfter)
+
+	case 'P':
+		v.repeat(n, conn, v.pasteBefore)
+
+	case 'i':
+		v.startInsert(conn, func(*session.Conn) {})
+
+	case 'a':
+		v.startInsert(conn, func(*session.Conn) {
+			if e.Cursor < len(e.GetText()) && e.GetText()[e.Cursor] != '\n' {
+				e.SetX(e.Cursor + 1)
+			}
+		})
+
+	case 'I':
+		v.startInsert(conn, func(*session.Conn) {
+			e.SetX(firstNonBlank(e.GetText(), e.Cursor))
+		})
+
+	case 'A':
+		v.startInsert(conn, func(*session.Conn) {
+			_, end := lineBounds(e.GetText(), e.Cursor)
+			e.SetX(end)
+		})
+
+	case 'o':
+		v.startInsert(conn, func(conn *session.Conn) {
+			_, end := lineBounds(e.GetText(), e.Cursor)
+			insertText(end, "\n", conn)
+			e.SetX(end + 1)
+		})
+
+	case 'O':
+		v.startInsert(conn, func(conn *session.Conn) {
+			start, _ := lineBounds(e.GetText(), e.Cursor)
+			insertText(start, "\n", conn)
+			e.SetX(start)
+		})
+
+	case 'v':
+		v.setMode(modeVisual)
+
+	case '.':
+		if v.lastChange != nil {
+			for i := 0; i < n; i++ {
+				v.lastChange(conn)
+			}
+		}
+
+	case ':':
+		promptKind = promptCommand
+		e.OpenPrompt(":")
+
+	case '/':
+		openSearchPrompt()
+
+	case 'n':
+		e.NextMatch(1)
+
+	case 'N':
+		e.NextMatch(-1)
+	}
+
+	return true, nil
+}
+
+func (v *Vim) visualKey(ev termbox.Event, conn *session.Conn) (bool, error) {
+	if ev.Ch == 0 {
+		switch ev.Key {
+		case termbox.KeyEsc:
+			v.setMode(modeNormal)
+			return true, nil
+		case termbox.KeyArrowLeft, termbox.KeyArrowRight, termbox.KeyArrowUp, termbox.KeyArrowDown:
+			// move without clearing the selection
+			anchor := e.Selection
+			err := handleKeyEvent(ev, conn)
+			e.Selection = anchor
+			return true, err
+		}
+		return false, nil
+	}
+
+	ch := ev.Ch
+	if unicode.IsDigit(ch) && (ch != '0' || v.count > 0) {
+		v.count = v.count*10 + int(ch-'0')
+		return true, nil
+	}
+	n := v.count
+	if n == 0 {
+		n = 1
+	}
+	v.count = 0
+
+	if v.motion(ch, n) {
+		return true, nil
+	}
+
+	start, end, ok := e.SelectionRange()
+	switch ch {
+	case 'd', 'x':
+		if ok {
+			v.yank(string(e.GetText()[start:end]), false)
+			deleteRange(start, end, conn)
+			size := end - start
+			v.lastChange = func(conn *session.Conn) {
+				text := e.GetText()
+				deleteRange(e.Cursor, min(e.Cursor+size, len(text)), conn)
+			}
+		}
+		v.setMode(modeNormal)
+
+	case 'y':
+		if ok {
+			v.yank(string(e.GetText()[start:end]), false)
+			e.SetX(start)
+		}
+		v.setMode(modeNormal)
+
+	case 'v':
+		v.setMode(modeNormal)
+	}
+
+	return true, nil
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// motion moves the cursor n times and reports whether ch is a motion.
+func (v *Vim) motion(ch rune, n int) bool {
+	text := e.GetText()
+
+	switch ch {
+	case 'h':
+		start, _ := lineBounds(text, e.Cursor)
+		e.MoveCursor(-min(n, e.Cursor-start), 0)
+	case 'l':
+		_, end := lineBounds(text, e.Cursor)
+		e.MoveCursor(min(n, end-e.Cursor), 0)
+	case 'j':
+		for i := 0; i < n; i++ {
+			e.MoveCursor(0, 1)
+		}
+	case 'k':
+		for i := 0; i < n; i++ {
+			e.MoveCursor(0, -1)
+		}
+	case 'w':
+		moveTo(repeatMotion(text, e.Cursor, n, nextWordStart))
+	case 'b':
+		moveTo(repeatMotion(text, e.Cursor, n, prevWordStart))
+	case 'e':
+		moveTo(repeatMotion(text, e.Cursor, n, wordEnd))
+	case '0':
+		start, _ := lineBounds(text, e.Cursor)
+		moveTo(start)
+	case '^':
+		moveTo(firstNonBlank(text, e.Cursor))
+	case '$':
+		_, end := lineBounds(text, e.Cursor)
+		moveTo(end)
+	case 'G':
+		start, _ := lineBounds(text, len(text))
+		moveTo(start)
+	default:
+		return false
+	}
+	return true
+}
+
+// operator runs a two-key command such as dd, dw, yy or gg.
+func (v *Vim) operator(op, ch rune, n int, conn *session.Conn) {
+	switch {
+	case op == 'g' && ch == 'g':
+		moveTo(0)
+
+	case op == 'd' && ch == 'd':
+		v.repeat(1, conn, func(conn *session.Conn) {
+			start, end := lineRange(e.GetText(), e.Cursor, n)
+			v.yank(string(e.GetText()[start:end]), true)
+			deleteLines(start, end, conn)
+		})
+
+	case op == 'd' && (ch == 'w' || ch == '$'):
+		motion := ch
+		v.repeat(1, conn, func(conn *session.Conn) {
+			end := motionEnd(e.GetText(), e.Cursor, motion, n)
+			v.yank(string(e.GetText()[e.Cursor:end]), false)
+			deleteRange(e.Cursor, end, conn)
+		})
+
+	case op == 'y' && ch == 'y':
+		start, end := lineRange(e.GetText(), e.Cursor, n)
+		v.yank(string(e.GetText()[start:end]), true)
+
+	case op == 'y' && (ch == 'w' || ch == '$'):
+		end := motionEnd(e.GetText(), e.Cursor, ch, n)
+		v.yank(string(e.GetText()[e.Cursor:end]), false)
+	}
+}
+
+// runVimCommand runs an ex command typed after ':'.
+func runVimCommand(cmd string, conn *session.Conn) error {
+	// commands taking arguments
+	if fields := strings.Fields(cmd); len(fields) > 0 {
+		switch fields[0] {
+		case "snapshot":
+			if len(fields) == 1 {
+				openSnapshotPrompt()
+			} else {
+				takeSnapshot(strings.Join(fields[1:], " "), conn)
+			}
+			return nil
+		case "snapshots":
+			requestSnapshots(conn)
+			return nil
+		case "diff":
+			requestDiff(fields[1:], conn)
+			return nil
+		}
+	}
+
+	switch strings.TrimSpace(cmd) {
+	case "w":
+		return actionSave(termbox.Event{}, conn)
+	case "q":
+		if len(editors()) == 1 && e.Modified {
+			e.StatusChan <- "No write since last change (add ! to override)"
+			return nil
+		}
+		return quitPane()
+	case "q!":
+		return quitPane()
+	case "wq", "x":
+		if err := actionSave(termbox.Event{}, conn); err != nil {
+			return err
+		}
+		if current == "" {
+			// wait for a name
+			return nil
+		}
+		return quitPane()
+	case "sp", "split":
+		splitPane(false)
+		return nil
+	case "vs", "vsplit":
+		splitPane(true)
+		return nil
+	case "tabnew":
+		newTab()
+		return nil
+	case "":
+		return nil
+	default:
+		e.StatusChan <- fmt.Sprintf("Not an editor command: %s", cmd)
+		return nil
+	}
+}
+
+// quitPane closes the focused pane, quitting with the last one.
+func quitPane() error {
+	if len(editors()) > 1 {
+		closePane()
+		return nil
+	}
+	return errQuit
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// change runs an edit and remembers it for '.'.
+func (v *Vim) change(conn *session.Conn, edit func(conn *session.Conn)) {
+	edit(conn)
+	v.lastChange = edit
+}
+
+// repeat runs an edit n times and remembers it for '.'.
+func (v *Vim) repeat(n int, conn *session.Conn, edit func(conn *session.Conn)) {
+	v.change(conn, func(conn *session.Conn) {
+		for i := 0; i < n; i++ {
+			edit(conn)
+		}
+	})
+}
+
+func (v *Vim) startInsert(conn *session.Conn, enter func(conn *session.Conn)) {
+	enter(conn)
+	v.enterInsert = enter
+	v.recording = nil
+	v.setMode(modeInsert)
+}
+
+// finishInsert leaves insert mode. The entry command and the typed keys
+// become the change repeated by '.'.
+func (v *Vim) finishInsert() {
+	enter, keys := v.enterInsert, v.recording
+	v.lastChange = func(conn *session.Conn) {
+		enter(conn)
+		for _, ev := range keys {
+			_ = handleKeyEvent(ev, conn)
+		}
+		leaveInsert()
+	}
+	v.setMode(modeNormal)
+	leaveInsert()
+}
+
+// leaveInsert puts the cursor on the last inserted rune, as Esc does.
+func leaveInsert() {
+	text := e.GetText()
+	if e.Cursor > 0 && e.Cursor <= len(text) && text[e.Cursor-1] != '\n' {
+		e.MoveCursor(-1, 0)
+	}
+}
+
+func (v *Vim) yank(text string, linewise bool) {
+	v.register = text
+	v.linewise = linewise
+}
+
+func (v *Vim) pasteAfter(conn *session.Conn) {
+	if v.register == "" {
+		return
+	}
+
+	text := e.GetText()
+	if v.linewise {
+		_, end := lineBounds(text, e.Cursor)
+		if end == len(text) {
+			// the last line has no newline to paste after
+			insertText(end, "\n"+strings.TrimSuffix(v.register, "\n"), conn)
+			e.SetX(end + 1)
+			return
+		}
+		insertText(end+1, v.register, conn)
+		e.SetX(end + 1)
+		return
+	}
+
+	at := e.Cursor
+	if at < len(text) && text[at] != '\n' {
+		at++
+	}
+	insertText(at, v.register, conn)
+	e.SetX(at + len([]rune(v.register)) - 1)
+}
+
+func (v *Vim) pasteBefore(conn *session.Conn) {
+	if v.register == "" {
+		return
+	}
+
+	if v.linewise {
+		start, _ := lineBounds(e.GetText(), e.Cursor)
+		register := v.register
+		if !strings.HasSuffix(register, "\n") {
+			register += "\n"
+		}
+		insertText(start, register, conn)
+		e.SetX(start)
+		return
+	}
+
+	insertText(e.Cursor, v.register, conn)
+	e.SetX(e.Cursor + len([]rune(v.register)) - 1)
+}
+
+func deleteChars(conn *session.Conn) {
+	text := e.GetText()
+	if e.Cursor < len(text) && text[e.Cursor] != '\n' {
+		deleteRange(e.Cursor, e.Cursor+1, conn)
+	}
+}
+
+func deleteCharBefore(conn *session.Conn) {
+	text := e.GetText()
+	if e.Cursor > 0 && text[e.Cursor-1] != '\n' {
+		deleteRange(e.Cursor-1, e.Cursor, conn)
+	}
+}
+
+// deleteLines removes whole lines [start, end) including the newline that
+// separates them from the rest of the text.
+func deleteLines(start, end int, conn *session.Conn) {
+	text := e.GetText()
+	if end == len(text) && start > 0 && (end == 0 || text[end-1] != '\n') {
+		start--
+	}
+	deleteRange(start, end, conn)
+	e.SetX(firstNonBlank(e.GetText(), min(start, len(e.GetText()))))
+}
+
+func moveTo(pos int) {
+	e.MoveCursor(pos-e.Cursor, 0)
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// lineBounds returns the index of the first rune of the line holding pos and
+// the index of its newline (or the end of the text).
+func lineBounds(text []rune, pos int) (int, int) {
+	if pos > len(text) {
+		pos = len(text)
+	}
+
+	start := pos
+	for start > 0 && text[start-1] != '\n' {
+		start--
+	}
+
+	end := pos
+	for end < len(text) && text[end] != '\n' {
+		end++
+	}
+	return start, end
+}
+
+// lineRange returns the range of n lines starting at the line of pos,
+// including their trailing newlines.
+func lineRange(text []rune, pos, n int) (int, int) {
+	start, end := lineBounds(text, pos)
+	for i := 1; i < n && end < len(text); i++ {
+		_, end = lineBounds(text, end+1)
+	}
+	if end < len(text) {
+		end++
+	}
+	return start, end
+}
+
+func firstNonBlank(text []rune, pos int) int {
+	start, end := lineBounds(text, pos)
+	for start < end && (text[start] == ' ' || text[start] == '\t') {
+		start++
+	}
+	return start
+}
+
+// motionEnd returns the end of the range a 'w' or '$' motion covers.
+func motionEnd(text []rune, pos int, motion rune, n int) int {
+	if motion == '$' {
+		_, end := lineBounds(text, pos)
+		return end
+	}
+
+	end := repeatMotion(text, pos, n, nextWordStart)
+	// dw doesn't join lines
+	_, lineEnd := lineBounds(text, pos)
+	return min(end, lineEnd)
+}
+
+func repeatMotion(text []rune, pos, n int, motion func([]rune, int) int) int {
+	for i := 0; i < n; i++ {
+		pos = motion(text, pos)
+	}
+	return pos
+}
+
+// charClass splits runes into blanks, word characters and punctuation.
+func charClass(r rune) int {
+	switch {
+	case unicode.IsSpace(r):
+		return 0
+	case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+		return 1
+	default:
+		return 2
+	}
+}
+
+func nextWordStart(text []rune, pos int) int {
+	if pos >= len(text) {
+		return len(text)
+	}
+
+	class := charClass(text[pos])
+	for pos < len(text) && charClass(text[pos]) == class && class != 0 {
+		pos++
+	}
+	for pos < len(text) && charClass(text[pos]) == 0 {
+		pos++
+	}
+	return pos
+}
+
+func prevWordStart(text []rune, pos int) int {
+	if pos > len(text) {
+		pos = len(text)
+	}
+
+	for pos > 0 && charClass(text[pos-1]) == 0 {
+		pos--
+	}
+	if pos == 0 {
+		return 0
+	}
+
+	class := charClass(text[pos-1])
+	for pos > 0 && charClass(text[pos-1]) == class {
+		pos--
+	}
+	return pos
+}
+
+func wordEnd(text []rune, pos int) int {
+	if pos+1 >= len(text) {
+		return len(text)
+	}
+
+	pos++
+	for pos < len(text) && charClass(text[pos]) == 0 {
+		pos++
+	}
+	if pos == len(text) {
+		return pos
+	}
+
+	class := charClass(text[pos])
+	for pos+1 < len(text) && charClass(text[pos+1]) == class {
+		pos++
+	}
+	return pos
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"diploma/client/editor"
+	"diploma/crdt"
+
+	"github.com/nsf/termbox-go"
+)
+
+// startVim opens text in a fresh editor with modal editing on.
+func startVim(t *testing.T, text string) {
+	t.Helper()
+	logger.SetOutput(io.Discard)
+
+	d := crdt.New()
+	doc = &d
+	for i, r := range []rune(text) {
+		if _, err := localInsert(i+1, string(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keymap = DefaultKeymap()
+	e = editor.NewEditor(editor.EditorConfig{})
+	root := &pane{editor: e}
+	tabs = []*tab{{root: root, focus: root}}
+	e.SetText(crdt.Content(*doc))
+	vim = NewVim()
+}
+
+// typeKeys sends keys to vim, <esc> and <cr> stand for Esc and Enter.
+func typeKeys(t *testing.T, keys string) {
+	t.Helper()
+	for _, ev := range keyEvents(keys) {
+		consumed, err := vim.handleKey(ev, nil)
+		if err == nil && !consumed {
+			err = handleKeyEvent(ev, nil)
+		}
+		if err != nil {
+			t.Fatalf("%q: %v", keys, err)
+		}
+	}
+}
+
+func keyEvents(keys string) []termbox.Event {
+	var evs []termbox.Event
+	for r := []rune(keys); len(r) > 0; r = r[1:] {
+		switch {
+		case len(r) >= 5 && string(r[:5]) == "<esc>":
+			evs = append(evs, termbox.Event{Key: termbox.KeyEsc})
+			r = r[4:]
+		case len(r) >= 4 && string(r[:4]) == "<cr>":
+			evs = append(evs, termbox.Event{Key: termbox.KeyEnter})
+			r = r[3:]
+		default:
+			evs = append(evs, termbox.Event{Ch: r[0]})
+		}
+	}
+	return evs
+}
+
+func TestVimCommands(t *testing.T) {
+	tests := []struct {
+		text, keys string
+		want       string
+		cursor     int
+	}{
+		{"hello world", "x", "ello world", 0},
+		{"hello world", "3x", "lo world", 0},
+		{"hello world", "wx", "hello orld", 6},
+		{"hello world", "$X", "hello worl", 10},
+		{"hello world", "dw", "world", 0},
+		{"hello world", "d$", "", 0},
+		{"one\ntwo\nthree", "jdd", "one\nthree", 4},
+		{"one\ntwo\nthree", "2dd", "three", 0},
+		{"one\ntwo\nthree", "Gdd", "one\ntwo", 4},
+		{"one\ntwo", "yyp", "one\none\ntwo", 4},
+		{"one\ntwo", "yyjp", "one\ntwo\none", 8},
+		{"one\ntwo", "jyykP", "two\none\ntwo", 0},
+		{"abc", "ywP", "abcabc", 2},
+		{"abc", "ix<esc>", "xabc", 0},
+		{"abc", "ax<esc>", "axbc", 1},
+		{"abc", "Ax<esc>", "abcx", 3},
+		{"  abc", "$Ix<esc>", "  xabc", 2},
+		{"abc", "oxy<esc>", "abc\nxy", 5},
+		{"abc", "Oxy<esc>", "xy\nabc", 1},
+		{"abc def", "x.", "c def", 0},
+		{"abc def", "ix<esc>w.", "xabc xdef", 5},
+		{"a\nb\nc\nd", "dd2.", "d", 0},
+		{"hello world", "vlld", "lo world", 0},
+		{"hello world", "wvey$p", "hello worldworld", 15},
+		{"hello world", "ggG", "hello world", 0},
+	}
+
+	for _, tt := range tests {
+		startVim(t, tt.text)
+		typeKeys(t, tt.keys)
+
+		if got := string(e.GetText()); got != tt.want {
+			t.Errorf("%q on %q: got %q, want %q", tt.keys, tt.text, got, tt.want)
+		}
+		if got := crdt.Content(*doc); got != tt.want {
+			t.Errorf("%q on %q: document %q, want %q", tt.keys, tt.text, got, tt.want)
+		}
+		if e.Cursor != tt.cursor {
+			t.Errorf("%q on %q: cursor at %d, want %d", tt.keys, tt.text, e.Cursor, tt.cursor)
+		}
+		if vim.mode != modeNormal {
+			t.Errorf("%q on %q: left in mode %d", tt.keys, tt.text, vim.mode)
+		}
+	}
+}
+
+func TestVimQuit(t *testing.T) {
+	startVim(t, "abc")
+	if err := runVimCommand("q", nil); err != errQuit {
+		t.Fatalf(":q on an unmodified document: got %v, want errQuit", err)
+	}
+
+	typeKeys(t, "x")
+	if err := runVimCommand("q", nil); err != nil {
+		t.Fatalf(":q with changes: %v", err)
+	}
+	if err := runVimCommand("q!", nil); err != errQuit {
+		t.Fatalf(":q!: got %v, want errQuit", err)
+	}
+}
+
+func TestWordMotions(t *testing.T) {
+	text := []rune("foo.bar  baz\n  qux")
+
+	tests := []struct {
+		name   string
+		motion func([]rune, int) int
+		pos    int
+		want   int
+	}{
+		{"w", nextWordStart, 0, 3},
+		{"w", nextWordStart, 3, 4},
+		{"w", nextWordStart, 4, 9},
+		{"w", nextWordStart, 9, 15},
+		{"w", nextWordStart, 18, 18},
+		{"b", prevWordStart, 9, 4},
+		{"b", prevWordStart, 15, 9},
+		{"b", prevWordStart, 0, 0},
+		{"e", wordEnd, 0, 2},
+		{"e", wordEnd, 2, 3},
+		{"e", wordEnd, 7, 11},
+		{"e", wordEnd, 17, 18},
+	}
+
+	for _, tt := range tests {
+		if got := tt.motion(text, tt.pos); got != tt.want {
+			t.Errorf("%s from %d: got %d, want %d", tt.name, tt.pos, got, tt.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	text := []rune("ab\n\t cd\nef")
+
+	for _, tt := range []struct{ pos, start, end int }{
+		{0, 0, 2}, {2, 0, 2}, {3, 3, 7}, {9, 8, 10}, {20, 8, 10},
+	} {
+		if start, end := lineBounds(text, tt.pos); start != tt.start || end != tt.end {
+			t.Errorf("lineBounds(%d) = %d, %d, want %d, %d", tt.pos, start, end, tt.start, tt.end)
+		}
+	}
+
+	for _, tt := range []struct{ pos, n, start, end int }{
+		{0, 1, 0, 3}, {0, 2, 0, 8}, {4, 5, 3, 10}, {9, 1, 8, 10},
+	} {
+		if start, end := lineRange(text, tt.pos, tt.n); start != tt.start || end != tt.end {
+			t.Errorf("lineRange(%d, %d) = %d, %d, want %d, %d", tt.pos, tt.n, start, end, tt.start, tt.end)
+		}
+	}
+
+	if got := firstNonBlank(text, 6); got != 5 {
+		t.Errorf("firstNonBlank = %d, want 5", got)
+	}
+	if got := motionEnd(text, 0, 'w', 3); got != 2 {
+		t.Errorf("3dw ran past the line to %d", got)
+	}
+}
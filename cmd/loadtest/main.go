@@ -0,0 +1,349 @@
+// Command loadtest connects many typists to a server and reports how fast
+// their operations reach the others, how many connections dropped and how
+// much memory the server used.
+//
+// Without -server it starts a server in the process and reports the heap of
+// the whole process, the typists included. With -server the memory is only
+// known for a server on this machine, given by -pid. The typists all connect
+// during -ramp, then type for -duration.
+//
+//	loadtest -clients 300 -rate 5 -duration 1m
+//	loadtest -server host:8080 -pid 1234 -clients 200
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"diploma/commons"
+	"diploma/server/hub"
+
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+)
+
+type config struct {
+	clients   int
+	observers int
+	rate      float64
+	backspace float64
+	ramp      time.Duration
+	duration  time.Duration
+	drain     time.Duration
+}
+
+func main() {
+	serverAddr := flag.String("server", "", "The network address of the server, a server is started in the process if empty")
+	useSecureConn := flag.Bool("secure", false, "Enable a secure WebSocket connection (wss://)")
+	pid := flag.Int("pid", 0, "The process ID of the server, to sample its memory when it runs on this machine")
+
+	var conf config
+	flag.IntVar(&conf.clients, "clients", 200, "Number of typists")
+	flag.IntVar(&conf.observers, "observers", 10, "Number of typists that decode what they receive to measure latency, the others only drain it")
+	flag.Float64Var(&conf.rate, "rate", 5, "Keystrokes per second of a typist")
+	flag.Float64Var(&conf.backspace, "backspace", 0.05, "Share of the keystrokes that delete")
+	flag.DurationVar(&conf.ramp, "ramp", 5*time.Second, "Time to connect every typist over")
+	flag.DurationVar(&conf.duration, "duration", 30*time.Second, "How long to type once everyone is connected")
+	flag.DurationVar(&conf.drain, "drain", 30*time.Second, "How long to wait at most for the operations still on their way once typing stopped")
+	flag.Parse()
+
+	u := url.URL{Scheme: "ws", Host: *serverAddr, Path: "/"}
+	if *useSecureConn {
+		u.Scheme = "wss"
+	}
+
+	var mem memory
+	if *serverAddr == "" {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start the server: %s\n", err)
+			os.Exit(1)
+		}
+		h := hub.New()
+		defer h.Close()
+		go http.Serve(ln, h)
+
+		u.Host = ln.Addr().String()
+		mem.sample = heapInUse
+		mem.name = "process heap"
+
+		// the server logs every message, which isn't what's measured
+		color.Output = io.Discard
+	} else if *pid != 0 {
+		mem.sample = func() (uint64, error) { return residentSize(*pid) }
+		mem.name = "server"
+	}
+
+	r := run(u.String(), conf, &mem)
+	r.print(os.Stdout, conf, &mem)
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// typist is a client that types its own run of text. It only keeps what it
+// needs to make valid operations, not the document.
+type typist struct {
+	name     string
+	conn     *websocket.Conn
+	site     string
+	clock    int
+	observer bool
+
+	// visible holds the IDs of the characters it typed and didn't delete.
+	visible []string
+
+	sent     int64
+	received int64
+	lost     atomic.Bool
+}
+
+// results are shared by the typists.
+type results struct {
+	failed   atomic.Int64 // couldn't connect
+	dropped  atomic.Int64 // lost their connection while typing
+	received atomic.Int64 // operations the observers got
+
+	// drained is how long the operations took to arrive after typing
+	// stopped.
+	drained time.Duration
+
+	// observers is the number of observers that connected.
+	observers int
+
+	// sentAt holds the operations on their way to the observers, by
+	// character ID and type.
+	sentAt sync.Map
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	typists   []*typist
+}
+
+// sent is an operation on its way to the observers.
+type sent struct {
+	at time.Time
+
+	// waiting is the number of observers yet to receive it, it's forgotten
+	// once they all did.
+	waiting atomic.Int32
+}
+
+func run(server string, conf config, mem *memory) *results {
+	r := &results{}
+	stop := make(chan struct{})
+	start := make(chan struct{})
+
+	stopMem := mem.watch()
+	defer stopMem()
+
+	var connected, typing sync.WaitGroup
+	for i := 0; i < conf.clients; i++ {
+		t := &typist{name: fmt.Sprintf("typist%d", i), observer: i < conf.observers}
+		connected.Add(1)
+		go func() {
+			defer connected.Done()
+			if err := t.connect(server); err != nil {
+				r.failed.Add(1)
+				fmt.Fprintf(os.Stderr, "%s: %s\n", t.name, err)
+				return
+			}
+			r.mu.Lock()
+			r.typists = append(r.typists, t)
+			r.mu.Unlock()
+
+			go t.read(r, stop)
+
+			typing.Add(1)
+			go func() {
+				defer typing.Done()
+				<-start
+				t.typeUntil(conf, r, stop)
+			}()
+		}()
+		time.Sleep(conf.ramp / time.Duration(conf.clients))
+	}
+	connected.Wait()
+
+	for _, t := range r.typists {
+		if t.observer {
+			r.observers++
+		}
+	}
+	close(start)
+	time.Sleep(conf.duration)
+	close(stop)
+	typing.Wait()
+	stopped := time.Now()
+
+	// let the operations on their way arrive, until nothing comes for a
+	// second
+	for deadline := time.Now().Add(conf.drain); time.Now().Before(deadline); {
+		before := r.received.Load()
+		time.Sleep(time.Second)
+		if r.received.Load() == before {
+			break
+		}
+	}
+	r.drained = time.Since(stopped)
+
+	for _, t := range r.typists {
+		t.conn.Close()
+	}
+	return r
+}
+
+func (t *typist) connect(server string) error {
+	dialer := websocket.Dialer{HandshakeTimeout: 30 * time.Second}
+	conn, _, err := dialer.Dial(server, nil)
+	if err != nil {
+		return err
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(30 * time.Second))
+	for t.site == "" {
+		var msg commons.Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			conn.Close()
+			return err
+		}
+		if msg.Type == commons.SiteIDMessage {
+			t.site = msg.Text
+		}
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	t.conn = conn
+	return conn.WriteJSON(commons.Message{Username: t.name, Text: "has joined the session.", Type: commons.JoinMessage})
+}
+
+// read drains the connection. Observers decode the operations to time
+// them.
+func (t *typist) read(r *results, stop chan struct{}) {
+	for {
+		if !t.observer {
+			_, reader, err := t.conn.NextReader()
+			if err == nil {
+				_, err = io.Copy(io.Discard, reader)
+			}
+			if err != nil {
+				t.drop(r, stop, err)
+				return
+			}
+			continue
+		}
+
+		var msg commons.Message
+		if err := t.conn.ReadJSON(&msg); err != nil {
+			t.drop(r, stop, err)
+			return
+		}
+		if msg.Type != "operation" {
+			continue
+		}
+		key := msg.Operation.ID + msg.Operation.Type
+		if s, ok := r.sentAt.Load(key); ok {
+			s := s.(*sent)
+			latency := time.Since(s.at)
+			if s.waiting.Add(-1) == 0 {
+				r.sentAt.Delete(key)
+			}
+			atomic.AddInt64(&t.received, 1)
+			r.received.Add(1)
+			r.mu.Lock()
+			r.latencies = append(r.latencies, latency)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// drop counts a connection lost before the end of the test.
+func (t *typist) drop(r *results, stop chan struct{}, err error) {
+	select {
+	case <-stop:
+		return
+	default:
+	}
+	if t.lost.CompareAndSwap(false, true) {
+		r.dropped.Add(1)
+		fmt.Fprintf(os.Stderr, "%s dropped: %s\n", t.name, err)
+	}
+}
+
+// typeUntil types words at about conf.rate keystrokes a second, with a
+// pause between them.
+func (t *typist) typeUntil(conf config, r *results, stop chan struct{}) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	interval := time.Duration(float64(time.Second) / conf.rate)
+
+	word := 0
+	for {
+		// keystrokes come at jittered intervals, a word ends with a longer
+		// pause
+		wait := interval/2 + time.Duration(rnd.Int63n(int64(interval)))
+		if word == 0 {
+			word = 3 + rnd.Intn(6)
+			wait += interval * 2
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+
+		var op commons.Operation
+		if len(t.visible) > 0 && rnd.Float64() < conf.backspace {
+			op = t.delete()
+		} else {
+			value := string(rune('a' + rnd.Intn(26)))
+			word--
+			if word == 0 {
+				value = " "
+			}
+			op = t.insert(value)
+		}
+
+		// the observers other than this typist get it
+		waiting := r.observers
+		if t.observer {
+			waiting--
+		}
+		if waiting > 0 {
+			s := &sent{at: time.Now()}
+			s.waiting.Store(int32(waiting))
+			r.sentAt.Store(op.ID+op.Type, s)
+		}
+		err := t.conn.WriteJSON(commons.Message{Username: t.name, Type: "operation", Operation: op})
+		if err != nil {
+			t.drop(r, stop, err)
+			return
+		}
+		t.sent++
+	}
+}
+
+func (t *typist) insert(value string) commons.Operation {
+	t.clock++
+	previous := "start"
+	if len(t.visible) > 0 {
+		previous = t.visible[len(t.visible)-1]
+	}
+	id := fmt.Sprintf("%s.%d", t.site, t.clock)
+	t.visible = append(t.visible, id)
+	return commons.Operation{Type: "insert", Value: value, ID: id, Previous: previous, Next: "end"}
+}
+
+func (t *typist) delete() commons.Operation {
+	id := t.visible[len(t.visible)-1]
+	t.visible = t.visible[:len(t.visible)-1]
+	return commons.Operation{Type: "delete", ID: id}
+}
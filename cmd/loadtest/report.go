@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memory samples the memory used by the server while the test runs.
+type memory struct {
+	sample func() (uint64, error)
+	// name says what's sampled, the server or the whole process.
+	name string
+
+	mu         sync.Mutex
+	start      uint64
+	peak, last uint64
+	err        error
+}
+
+// watch samples every half second until the returned function is called.
+func (m *memory) watch() func() {
+	if m.sample == nil {
+		return func() {}
+	}
+
+	m.start, m.err = m.sample()
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(500 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			n, err := m.sample()
+			m.mu.Lock()
+			if err != nil {
+				m.err = err
+			} else {
+				m.last = n
+				m.peak = max(m.peak, n)
+			}
+			m.mu.Unlock()
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// heapInUse is the heap of this process, which holds the typists along with
+// the server.
+func heapInUse() (uint64, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapInuse, nil
+}
+
+// residentSize reads the resident memory of a process from /proc.
+func residentSize(pid int) (uint64, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// VmRSS:	   12345 kB
+		if fields := strings.Fields(s.Text()); len(fields) == 3 && fields[0] == "VmRSS:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, fmt.Errorf("no resident size for process %d", pid)
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (r *results) print(w io.Writer, conf config, mem *memory) {
+	var sent int64
+	for _, t := range r.typists {
+		sent += t.sent
+	}
+
+	// an observer that stayed should get every operation of the others
+	var expected, received int64
+	for _, t := range r.typists {
+		if t.observer && !t.lost.Load() {
+			expected += sent - t.sent
+			received += t.received
+		}
+	}
+
+	fmt.Fprintf(w, "clients:     %d connected, %d failed to connect, %d dropped\n", len(r.typists), r.failed.Load(), r.dropped.Load())
+	fmt.Fprintf(w, "operations:  %d sent in %s (%.0f/s)\n", sent, conf.duration, float64(sent)/conf.duration.Seconds())
+	if expected > 0 {
+		last := fmt.Sprintf("the last %s after typing stopped", r.drained.Round(time.Millisecond))
+		if r.drained >= conf.drain {
+			last = fmt.Sprintf("still arriving %s after typing stopped", r.drained.Round(time.Millisecond))
+		}
+		fmt.Fprintf(w, "deliveries:  %d of %d to the observers (%.2f%% missing), %s\n",
+			received, expected, 100*float64(expected-received)/float64(expected), last)
+	}
+
+	latencies := r.latencies
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if len(latencies) > 0 {
+		fmt.Fprintf(w, "latency:     ")
+		for _, p := range []float64{50, 90, 99, 99.9} {
+			fmt.Fprintf(w, "p%g %s  ", p, percentile(latencies, p).Round(time.Microsecond))
+		}
+		fmt.Fprintf(w, "max %s\n", latencies[len(latencies)-1].Round(time.Microsecond))
+	}
+
+	switch {
+	case mem.sample == nil:
+		fmt.Fprintf(w, "memory:      unknown, pass -pid for a server on this machine\n")
+	case mem.err != nil:
+		fmt.Fprintf(w, "memory:      %s\n", mem.err)
+	default:
+		fmt.Fprintf(w, "memory:      %s: %s before, %s peak, %s at the end\n", mem.name, megabytes(mem.start), megabytes(mem.peak), megabytes(mem.last))
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func megabytes(n uint64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
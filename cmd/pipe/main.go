@@ -0,0 +1,98 @@
+// Command pipe connects to a session without a terminal. It either appends
+// its standard input to a live document as it comes, or prints the
+// document.
+//
+//	pipe [flags] write < build.log
+//	pipe [flags] dump > notes.txt
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"diploma/client/session"
+)
+
+func main() {
+	serverAddr := flag.String("server", "localhost:8080", "The network address of the server")
+	room := flag.String("room", "", "The room of the server to join")
+	useSecureConn := flag.Bool("secure", false, "Enable a secure WebSocket connection (wss://)")
+	name := flag.String("name", "pipe", "The username shown to the others")
+	path := flag.String("path", "", "The document to use, the unnamed one by default")
+	wait := flag.Duration("wait", 5*time.Second, "How long to wait for the document from the others")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] write|dump\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 || (flag.Arg(0) != "write" && flag.Arg(0) != "dump") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	u := url.URL{Scheme: "ws", Host: *serverAddr, Path: "/" + *room}
+	if *useSecureConn {
+		u.Scheme = "wss"
+	}
+
+	s, err := session.Connect(u.String(), session.Config{Name: *name, Path: *path})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Connection error, exiting: %s\n", err)
+		os.Exit(1)
+	}
+
+	// edits made before the document arrives would end up at its start
+	select {
+	case <-s.Synced():
+	case <-time.After(*wait):
+		fmt.Fprintf(os.Stderr, "nobody sent %q within %s, starting from an empty document\n", *path, *wait)
+	}
+
+	if flag.Arg(0) == "write" {
+		err = write(s, os.Stdin)
+	} else {
+		_, err = io.WriteString(os.Stdout, s.Content())
+	}
+
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// write appends r to the document a line at a time, so that the others see
+// it while it's being written.
+func write(s *session.Session, r io.Reader) error {
+	in := bufio.NewReader(r)
+	for {
+		line, err := in.ReadString('\n')
+		if line != "" {
+			if err := s.Append(line); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-s.Done():
+			return errors.New("lost connection")
+		default:
+		}
+	}
+}
@@ -0,0 +1,149 @@
+// Command replay feeds a recording made with the server's -record flag back
+// into fresh replicas and shows where they diverged.
+//
+//	replay [-room team] [-speed 10] [-watch 2 [-path notes.txt]] session.rec
+//
+// Rooms are separate sessions, only the one given with -room is replayed.
+//
+// -watch redraws the document of one site after each change, as a
+// screencast of what its user saw.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"diploma/commons"
+	"diploma/replay"
+)
+
+func main() {
+	room := flag.String("room", "", "The room to replay, the one at / by default")
+	speed := flag.Float64("speed", 0, "Replay speed, 1 for real time, 0 to not wait between messages")
+	watch := flag.String("watch", "", "The site whose document is shown while replaying")
+	path := flag.String("path", "", "The document shown with -watch, the unnamed one by default")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] recording\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	all, err := commons.ReadRecording(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read %s: %s\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+
+	var entries []commons.RecordEntry
+	var others []string
+	for _, e := range all {
+		if e.Room == *room {
+			entries = append(entries, e)
+		} else if !slices.Contains(others, e.Room) {
+			others = append(others, e.Room)
+		}
+	}
+	if len(others) > 0 {
+		slices.Sort(others)
+		fmt.Fprintf(os.Stderr, "skipping the other rooms %q, choose one with -room\n", others)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "%s has nothing recorded in room %q\n", flag.Arg(0), *room)
+		os.Exit(1)
+	}
+
+	p := replay.New()
+	shown := ""
+	for i, e := range entries {
+		if *speed > 0 && i > 0 {
+			time.Sleep(time.Duration(float64(e.Time.Sub(entries[i-1].Time)) / *speed))
+		}
+
+		d, err := p.Step(e)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "entry %d: %s\n", i, err)
+			os.Exit(1)
+		}
+		if *watch != "" && e.Site == *watch {
+			shown = show(p, e, i, *path, shown)
+		}
+		if d != nil {
+			fmt.Print(d)
+			summary(p)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Printf("%d entries over %s, no divergence\n", len(entries), entries[len(entries)-1].Time.Sub(entries[0].Time).Round(time.Millisecond))
+	summary(p)
+}
+
+// show redraws the document of the watched site if it's not the one shown
+// already, and returns it.
+func show(p *replay.Replayer, e commons.RecordEntry, i int, path, shown string) string {
+	for _, r := range p.Replicas() {
+		text, _ := r.Content(path)
+		if r.Site != e.Site || text == shown || e.Message == nil {
+			continue
+		}
+		fmt.Printf("\033[H\033[2J%s  entry %d  %s %s\n\n%s\n", e.Time.Format(time.StampMilli), i, e.Dir, e.Message.Type, text)
+		return text
+	}
+	return shown
+}
+
+// summary prints the documents each replica ended with.
+func summary(p *replay.Replayer) {
+	var paths []string
+	for _, r := range p.Replicas() {
+		for _, path := range r.Paths() {
+			if !slices.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		fmt.Printf("\n%q:\n", path)
+		texts := map[string]int{}
+		for _, r := range p.Replicas() {
+			text, ok := r.Content(path)
+			if !ok {
+				continue
+			}
+			state := "live"
+			if !r.Live {
+				state = "left"
+			}
+			fmt.Printf("  %-24s %-4s %6d runes", r, state, len([]rune(text)))
+			if n := r.Waiting(path); n > 0 {
+				fmt.Printf(", %d operations waiting", n)
+			}
+			if n := r.Dropped(path); n > 0 {
+				fmt.Printf(", %d operations dropped", n)
+			}
+			fmt.Println()
+			if r.Live {
+				texts[text]++
+			}
+		}
+		if len(texts) > 1 {
+			fmt.Printf("  the live replicas ended with %d different texts\n", len(texts))
+		}
+	}
+}
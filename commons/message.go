@@ -1,6 +1,8 @@
 package commons
 
 import (
+	"time"
+
 	"diploma/crdt"
 
 	"github.com/google/uuid"
@@ -14,13 +16,75 @@ const (
 	SiteIDMessage  MessageType = "SiteID"  // generating site IDs
 	JoinMessage    MessageType = "join"    // joining messages
 	UsersMessage   MessageType = "users"   // list of active users
+	BatchMessage   MessageType = "batch"   // operations applied as one edit
+
+	FileCreateMessage MessageType = "fileCreate" // creating a document
+	FileRenameMessage MessageType = "fileRename" // renaming a document
+	FileDeleteMessage MessageType = "fileDelete" // deleting a document
+
+	ChatMessage        MessageType = "chat"        // chat messages
+	ChatHistoryMessage MessageType = "chatHistory" // chat of the session so far
+
+	CommentMessage       MessageType = "comment"       // adding or updating a comment
+	CommentDeleteMessage MessageType = "commentDelete" // deleting a comment
+
+	HistoryReqMessage MessageType = "historyReq" // requesting the history of a document
+	HistoryMessage    MessageType = "history"    // history of a document
+
+	SnapshotMessage     MessageType = "snapshot"     // taking a named snapshot of a document
+	SnapshotsReqMessage MessageType = "snapshotsReq" // requesting the snapshots of a document
+	SnapshotsMessage    MessageType = "snapshots"    // snapshots of a document
 )
 
 type Message struct {
-	Username  string        `json:"username"`
-	Text      string        `json:"text"`
-	Type      MessageType   `json:"type"`
-	ID        uuid.UUID     `json:"ID"`
-	Operation Operation     `json:"operation"`
-	Document  crdt.Document `json:"document"`
+	Username   string        `json:"username"`
+	Text       string        `json:"text"`
+	Type       MessageType   `json:"type"`
+	ID         uuid.UUID     `json:"ID"`
+	Operation  Operation     `json:"operation"`
+	Operations []Operation   `json:"operations,omitempty"`
+	Document   crdt.Document `json:"document"`
+
+	// Path is the document a message refers to, NewPath is its new path
+	// when renaming. The empty path is the unnamed document.
+	Path    string `json:"path,omitempty"`
+	NewPath string `json:"newPath,omitempty"`
+
+	// Chat holds chat messages stamped by the server.
+	Chat []ChatEntry `json:"chat,omitempty"`
+
+	// Comment is the comment added, updated or deleted in the document at
+	// Path.
+	Comment *crdt.Comment `json:"comment,omitempty"`
+
+	// Sites maps site IDs to the names of their users.
+	Sites map[string]string `json:"sites,omitempty"`
+
+	// History holds the edits made to the document at Path, oldest first.
+	History []HistoryEntry `json:"history,omitempty"`
+
+	// Snapshots holds named snapshots of the document at Path, oldest
+	// first.
+	Snapshots []Snapshot `json:"snapshots,omitempty"`
+}
+
+// Snapshot is a document as it was when a user tagged it with a name.
+type Snapshot struct {
+	Name     string        `json:"name"`
+	Username string        `json:"username"`
+	Time     time.Time     `json:"time"`
+	Document crdt.Document `json:"document"`
+}
+
+// HistoryEntry is an edit of a document, as recorded by the server.
+type HistoryEntry struct {
+	Time       time.Time   `json:"time"`
+	Username   string      `json:"username"`
+	Operations []Operation `json:"operations"`
+}
+
+type ChatEntry struct {
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
 }
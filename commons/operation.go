@@ -1,7 +1,122 @@
 package commons
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"diploma/crdt"
+)
+
 type Operation struct {
 	Type     string `json:"type"`
 	Position int    `json:"position"`
 	Value    string `json:"value"`
+
+	// ID is the CRDT character inserted or deleted, Previous and Next are
+	// the characters it was inserted between. Operations without an ID are
+	// applied at Position.
+	ID       string `json:"id,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+var (
+	// ErrNotReady is returned for operations on characters a site doesn't
+	// have yet. They can be integrated once the operations they depend on
+	// are.
+	ErrNotReady = errors.New("operation depends on characters not integrated yet")
+
+	// ErrBadOperation is returned for operations that no later operation
+	// can make ready, like ones on malformed character IDs.
+	ErrBadOperation = errors.New("malformed operation")
+)
+
+// Insert inserts value at the 1-based position of d and returns the
+// operation repeating it at other sites.
+func Insert(d *crdt.Document, pos int, value string) (Operation, error) {
+	return InsertAs(d, crdt.SiteID, pos, value)
+}
+
+// InsertAs inserts like Insert, as site.
+func InsertAs(d *crdt.Document, site, pos int, value string) (Operation, error) {
+	char, err := d.InsertCharacterAs(site, pos, value)
+	return Operation{Type: "insert", Position: pos, Value: value, ID: char.ID, Previous: char.IDPrevious, Next: char.IDNext}, err
+}
+
+// Delete deletes the rune at the 1-based position of d and returns the
+// operation repeating it at other sites.
+func Delete(d *crdt.Document, pos int) (Operation, error) {
+	char, err := d.DeleteCharacter(pos)
+	return Operation{Type: "delete", Position: pos, ID: char.ID}, err
+}
+
+// Validate checks that op names its characters by IDs a site can generate,
+// so that it's worth waiting for them.
+func Validate(op Operation) error {
+	ok := false
+	switch op.Type {
+	case "insert":
+		ok = op.Value != "" && validID(op.ID) &&
+			(op.Previous == crdt.CharacterStart.ID || validID(op.Previous)) &&
+			(op.Next == crdt.CharacterEnd.ID || validID(op.Next))
+	case "delete":
+		ok = validID(op.ID)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s %q", ErrBadOperation, op.Type, op.ID)
+	}
+	return nil
+}
+
+// validID reports whether id has the "site.clock" form of the IDs of
+// generated characters.
+func validID(id string) bool {
+	site, clock, ok := strings.Cut(id, ".")
+	if !ok {
+		return false
+	}
+	s, err := strconv.Atoi(site)
+	if err != nil || s < 0 {
+		return false
+	}
+	c, err := strconv.Atoi(clock)
+	return err == nil && c >= 0
+}
+
+// Integrate applies an operation generated at another site to d. It
+// returns the operation with the visible position it took, and whether it
+// changed the text at all, which it doesn't when received twice.
+func Integrate(d *crdt.Document, op Operation) (Operation, bool, error) {
+	if err := Validate(op); err != nil {
+		return op, false, err
+	}
+
+	switch op.Type {
+	case "insert":
+		if d.Contains(op.ID) {
+			return op, false, nil
+		}
+		char := crdt.Character{ID: op.ID, Visible: true, Value: op.Value, IDPrevious: op.Previous, IDNext: op.Next}
+		if err := d.IntegrateCharacter(char); err != nil {
+			if errors.Is(err, crdt.ErrBoundsNotPresent) {
+				return op, false, ErrNotReady
+			}
+			return op, false, err
+		}
+		op.Position = d.VisibleIndex(op.ID) + 1
+
+	case "delete":
+		if !d.Contains(op.ID) {
+			return op, false, ErrNotReady
+		}
+		i := d.VisibleIndex(op.ID)
+		if i == -1 {
+			return op, false, nil
+		}
+		d.IntegrateDelete(crdt.Character{ID: op.ID})
+		op.Position = i + 1
+	}
+	return op, true, nil
 }
@@ -0,0 +1,106 @@
+package commons
+
+import (
+	"errors"
+	"fmt"
+
+	"diploma/crdt"
+)
+
+// RemoteOp is an operation of another user.
+type RemoteOp struct {
+	Username string
+	Operation
+}
+
+// maxWaiting bounds the operations a Pending holds. Past it the oldest are
+// dropped, the characters they wait for are most likely never coming.
+const maxWaiting = 1000
+
+// ErrDropped is returned for waiting operations given up on.
+var ErrDropped = errors.New("operation dropped")
+
+// Pending holds the operations of other sites that depend on characters a
+// document doesn't have yet, until they arrive. The zero value is ready to
+// use.
+type Pending struct {
+	ops     []RemoteOp
+	dropped int
+}
+
+// Integrate applies op to d, along with the waiting operations it made
+// ready. It returns the operations that changed the text, in the order
+// they were applied, with the 1-based positions they took. Malformed
+// operations are dropped rather than kept waiting.
+func (p *Pending) Integrate(d *crdt.Document, op RemoteOp) ([]RemoteOp, error) {
+	integrated, changed, err := Integrate(d, op.Operation)
+	switch {
+	case errors.Is(err, ErrNotReady):
+		// d didn't change, so nothing else got ready either
+		p.ops = append(p.ops, op)
+		return nil, p.trim()
+	case err != nil:
+		p.dropped++
+		return nil, err
+	case !changed:
+		return nil, nil
+	}
+
+	applied := []RemoteOp{{Username: op.Username, Operation: integrated}}
+	more, err := p.Retry(d)
+	return append(applied, more...), err
+}
+
+// Retry applies the waiting operations that d now has the characters for,
+// after it got them some other way.
+func (p *Pending) Retry(d *crdt.Document) ([]RemoteOp, error) {
+	var applied []RemoteOp
+	for progress := true; progress; {
+		progress = false
+
+		left := p.ops[:0]
+		for i, op := range p.ops {
+			integrated, changed, err := Integrate(d, op.Operation)
+			switch {
+			case errors.Is(err, ErrNotReady):
+				left = append(left, op)
+			case err != nil:
+				// the broken operation is dropped, the others keep waiting
+				p.ops = append(left, p.ops[i+1:]...)
+				p.dropped++
+				return applied, err
+			default:
+				progress = true
+				if changed {
+					applied = append(applied, RemoteOp{Username: op.Username, Operation: integrated})
+				}
+			}
+		}
+		p.ops = left
+	}
+	return applied, nil
+}
+
+// trim drops the oldest operations past maxWaiting.
+func (p *Pending) trim() error {
+	n := len(p.ops) - maxWaiting
+	if n <= 0 {
+		return nil
+	}
+
+	oldest := p.ops[0]
+	p.ops = append(p.ops[:0], p.ops[n:]...)
+	p.dropped += n
+	return fmt.Errorf("%w: %s %s of %s waited too long for its characters", ErrDropped, oldest.Type, oldest.ID, oldest.Username)
+}
+
+// Len returns the number of waiting operations.
+func (p *Pending) Len() int {
+	return len(p.ops)
+}
+
+// Dropped returns the number of operations that were malformed, failed or
+// waited too long.
+func (p *Pending) Dropped() int {
+	return p.dropped
+}
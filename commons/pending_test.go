@@ -0,0 +1,94 @@
+package commons
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"diploma/crdt"
+)
+
+func TestPendingOutOfOrder(t *testing.T) {
+	// site 1 types "abc", site 2 gets it backwards and twice
+	src := crdt.New()
+	var ops []Operation
+	for i, r := range "abc" {
+		op, err := InsertAs(&src, 1, i+1, string(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops = append(ops, op)
+	}
+	op, err := Delete(&src, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops = append(ops, op)
+
+	dst := crdt.New()
+	var p Pending
+	var applied []RemoteOp
+	for i := len(ops) - 1; i >= 0; i-- {
+		done, err := p.Integrate(&dst, RemoteOp{Username: "one", Operation: ops[i]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		applied = append(applied, done...)
+	}
+	if done, _ := p.Integrate(&dst, RemoteOp{Operation: ops[0]}); len(done) != 0 {
+		t.Fatalf("a duplicate changed the text: %+v", done)
+	}
+
+	if got := crdt.Content(dst); got != "ac" || p.Len() != 0 {
+		t.Fatalf("got %q with %d waiting, want %q", got, p.Len(), "ac")
+	}
+	// the delete goes as soon as "b" is there
+	if len(applied) != 4 || applied[1].Value != "b" || applied[2].Type != "delete" || applied[2].Username != "one" || applied[3].Value != "c" {
+		t.Fatalf("applied %+v", applied)
+	}
+}
+
+func TestPendingDropsMalformed(t *testing.T) {
+	d := crdt.New()
+	var p Pending
+
+	for _, op := range []Operation{
+		{Type: "delete", ID: "-1"},
+		{Type: "delete", ID: "1"},
+		{Type: "delete", ID: "a.b"},
+		{Type: "insert", ID: "1.1", Value: "x", Previous: "start", Next: "nowhere"},
+		{Type: "insert", ID: "1.1", Previous: "start", Next: "end"},
+		{Type: "move", ID: "1.1"},
+	} {
+		if _, err := p.Integrate(&d, RemoteOp{Operation: op}); !errors.Is(err, ErrBadOperation) {
+			t.Errorf("%+v: got %v, want ErrBadOperation", op, err)
+		}
+	}
+	if p.Len() != 0 || p.Dropped() != 6 {
+		t.Fatalf("%d waiting and %d dropped, want 0 and 6", p.Len(), p.Dropped())
+	}
+}
+
+func TestPendingBound(t *testing.T) {
+	d := crdt.New()
+	var p Pending
+
+	// deletions of characters that never arrive
+	for i := 0; i < maxWaiting; i++ {
+		if _, err := p.Integrate(&d, RemoteOp{Operation: Operation{Type: "delete", ID: fmt.Sprintf("1.%d", i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err := p.Integrate(&d, RemoteOp{Operation: Operation{Type: "delete", ID: "2.1"}})
+	if !errors.Is(err, ErrDropped) || p.Len() != maxWaiting || p.Dropped() != 1 {
+		t.Fatalf("got %v with %d waiting and %d dropped", err, p.Len(), p.Dropped())
+	}
+
+	// the oldest one went, the newest still waits
+	if err := d.IntegrateCharacter(crdt.Character{ID: "2.1", Visible: true, Value: "x", IDPrevious: "start", IDNext: "end"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Retry(&d); err != nil || p.Len() != maxWaiting-1 || crdt.Content(d) != "" {
+		t.Fatalf("got %v with %d waiting and %q", err, p.Len(), crdt.Content(d))
+	}
+}
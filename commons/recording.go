@@ -0,0 +1,100 @@
+package commons
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Directions of a recorded entry, as seen from the server.
+const (
+	RecordIn         = "in"         // message from the client
+	RecordOut        = "out"        // message to the client
+	RecordConnect    = "connect"    // client connected
+	RecordDisconnect = "disconnect" // client left
+)
+
+// RecordEntry is a line of a session recording.
+type RecordEntry struct {
+	Time    time.Time `json:"time"`
+	Room    string    `json:"room,omitempty"`
+	Dir     string    `json:"dir"`
+	Client  uuid.UUID `json:"client"`
+	Site    string    `json:"site,omitempty"`
+	Message *Message  `json:"message,omitempty"`
+}
+
+// Recorder writes the entries of a session recording, one JSON object per
+// line. A nil Recorder records nothing.
+type Recorder struct {
+	w    *recordWriter
+	room string
+}
+
+// recordWriter is shared by the recorders of every room.
+type recordWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: &recordWriter{enc: json.NewEncoder(w)}}
+}
+
+// Room returns a recorder writing to the same recording, for the entries
+// of room.
+func (r *Recorder) Room(room string) *Recorder {
+	if r == nil {
+		return nil
+	}
+	return &Recorder{w: r.w, room: room}
+}
+
+// Record writes an entry stamped with the current time. After an error it
+// stops writing, Err returns it.
+func (r *Recorder) Record(dir string, client uuid.UUID, site string, msg *Message) {
+	if r == nil {
+		return
+	}
+
+	r.w.mu.Lock()
+	defer r.w.mu.Unlock()
+	if r.w.err == nil {
+		r.w.err = r.w.enc.Encode(RecordEntry{Time: time.Now(), Room: r.room, Dir: dir, Client: client, Site: site, Message: msg})
+	}
+}
+
+// Err returns the error that stopped the recording, if any.
+func (r *Recorder) Err() error {
+	if r == nil {
+		return nil
+	}
+
+	r.w.mu.Lock()
+	defer r.w.mu.Unlock()
+	return r.w.err
+}
+
+// ReadRecording reads the entries of a session recording. A line cut short
+// at the end, as left by a server that was killed, is ignored.
+func ReadRecording(r io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		var entry RecordEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -0,0 +1,56 @@
+package crdt
+
+import "time"
+
+// Comment is a note on the characters from Start to End. It's anchored to
+// character IDs, so it stays on the same text while the document is edited.
+type Comment struct {
+	ID       string    `json:"id"`
+	Start    string    `json:"start"`
+	End      string    `json:"end"`
+	Author   string    `json:"author"`
+	Text     string    `json:"text"`
+	Time     time.Time `json:"time"`
+	Resolved bool      `json:"resolved"`
+
+	// Site changed the comment last, at Time. It orders changes made at the
+	// same time.
+	Site int `json:"site,omitempty"`
+}
+
+// newer reports whether c is a later version of the comment than old.
+func (c Comment) newer(old Comment) bool {
+	if !c.Time.Equal(old.Time) {
+		return c.Time.After(old.Time)
+	}
+	return c.Site > old.Site
+}
+
+// PutComment adds a comment, or replaces the one with the same ID if c is a
+// later version of it, so that replicas keep the same version whatever
+// order the changes arrive in.
+func (doc *Document) PutComment(c Comment) {
+	if i := doc.commentIndex(c.ID); i != -1 {
+		if c.newer(doc.Comments[i]) {
+			doc.Comments[i] = c
+		}
+		return
+	}
+	doc.Comments = append(doc.Comments, c)
+}
+
+// DeleteComment removes the comment with id.
+func (doc *Document) DeleteComment(id string) {
+	for i := range doc.Comments {
+		if doc.Comments[i].ID == id {
+			doc.Comments = append(doc.Comments[:i], doc.Comments[i+1:]...)
+			return
+		}
+	}
+}
+
+// CommentSpan returns the visible range [start, end) of a comment. ok is
+// false once all of its text has been deleted.
+func (doc *Document) CommentSpan(c Comment) (start, end int, ok bool) {
+	return doc.Span(c.Start, c.End)
+}
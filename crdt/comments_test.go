@@ -0,0 +1,61 @@
+package crdt
+
+import (
+	"testing"
+	"time"
+)
+
+// Concurrent changes of a comment leave the same version whatever order
+// they arrive in, the one a merge keeps.
+func TestPutCommentConcurrent(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	base := Comment{ID: "c", Text: "typo", Time: created, Site: 1}
+
+	resolved := base
+	resolved.Resolved, resolved.Time, resolved.Site = true, created.Add(time.Second), 2
+	edited := base
+	edited.Text, edited.Time, edited.Site = "typo here", created.Add(time.Second), 3
+	later := base
+	later.Text, later.Time, later.Site = "fixed", created.Add(time.Minute), 1
+
+	tests := []struct {
+		name string
+		a, b Comment
+		want Comment
+	}{
+		{"at the same time", resolved, edited, edited},
+		{"one later", later, edited, later},
+		{"same change", resolved, resolved, resolved},
+	}
+	for _, tt := range tests {
+		for _, order := range [][]Comment{{tt.a, tt.b}, {tt.b, tt.a}} {
+			d := New()
+			d.PutComment(base)
+			for _, c := range order {
+				d.PutComment(c)
+			}
+			if len(d.Comments) != 1 || d.Comments[0] != tt.want {
+				t.Errorf("%s: applying %q then %q gives %+v", tt.name, order[0].Text, order[1].Text, d.Comments)
+			}
+		}
+
+		a, b := New(), New()
+		a.PutComment(tt.a)
+		b.PutComment(tt.b)
+		merged, err := Merge(New(), a, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(merged.Comments) != 1 || merged.Comments[0] != tt.want {
+			t.Errorf("%s: merging gives %+v", tt.name, merged.Comments)
+		}
+	}
+
+	// an older version doesn't replace a newer one
+	d := New()
+	d.PutComment(later)
+	d.PutComment(base)
+	if d.Comments[0] != later {
+		t.Errorf("got %+v, want %+v", d.Comments[0], later)
+	}
+}
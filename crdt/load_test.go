@@ -0,0 +1,30 @@
+package crdt
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestLoadMultibyte(t *testing.T) {
+	text := "héllo\nмир 🙂\n"
+	name := filepath.Join(t.TempDir(), "text.txt")
+	if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := Content(doc); got != text {
+		t.Fatalf("loaded %q, want %q", got, text)
+	}
+	// one character per rune, so that positions are rune offsets
+	for _, c := range doc.Characters {
+		if c.Visible && utf8.RuneCountInString(c.Value) != 1 {
+			t.Fatalf("character %s holds %q", c.ID, c.Value)
+		}
+	}
+}
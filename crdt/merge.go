@@ -0,0 +1,90 @@
+package crdt
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrNotAncestor = errors.New("the ancestor has characters missing from a merged document")
+
+// Merge combines two documents that were edited apart after being copied
+// from base. The characters of b missing from a are integrated like remote
+// insertions and a character deleted in either of them stays deleted, so
+// the result is the document both sites would have reached by exchanging
+// their operations.
+func Merge(base, a, b Document) (Document, error) {
+	inA := make(map[string]Character, len(a.Characters))
+	for _, c := range a.Characters {
+		inA[c.ID] = c
+	}
+	inB := make(map[string]bool, len(b.Characters))
+	for _, c := range b.Characters {
+		inB[c.ID] = true
+	}
+	for _, c := range base.Characters {
+		if _, ok := inA[c.ID]; !ok || !inB[c.ID] {
+			return Document{}, ErrNotAncestor
+		}
+	}
+
+	merged := Document{Characters: make([]Character, len(a.Characters))}
+	copy(merged.Characters, a.Characters)
+
+	var pending []Character
+	for _, c := range b.Characters {
+		existing, ok := inA[c.ID]
+		switch {
+		case !ok:
+			pending = append(pending, c)
+		case existing.Value != c.Value:
+			// both sites generated characters with the same site ID
+			return Document{}, fmt.Errorf("character %s differs between the documents", c.ID)
+		case !c.Visible:
+			merged.IntegrateDelete(c)
+		}
+	}
+
+	// a character can only be integrated once the ones it was generated
+	// between are there
+	for len(pending) > 0 {
+		var left []Character
+		for _, c := range pending {
+			err := merged.IntegrateCharacter(c)
+			if errors.Is(err, ErrBoundsNotPresent) {
+				left = append(left, c)
+				continue
+			}
+			if err != nil {
+				return Document{}, err
+			}
+			if !c.Visible {
+				merged.IntegrateDelete(c)
+			}
+		}
+		if len(left) == len(pending) {
+			return Document{}, ErrBoundsNotPresent
+		}
+		pending = left
+	}
+
+	// the latest version of a comment wins
+	merged.Comments = append(merged.Comments, a.Comments...)
+	for _, c := range b.Comments {
+		if i := merged.commentIndex(c.ID); i == -1 {
+			merged.Comments = append(merged.Comments, c)
+		} else if c.newer(merged.Comments[i]) {
+			merged.Comments[i] = c
+		}
+	}
+
+	return merged, nil
+}
+
+func (doc *Document) commentIndex(id string) int {
+	for i, c := range doc.Comments {
+		if c.ID == id {
+			return i
+		}
+	}
+	return -1
+}
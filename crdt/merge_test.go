@@ -0,0 +1,151 @@
+package crdt
+
+import (
+	"errors"
+	"testing"
+)
+
+// edit changes a document as the given site.
+type edit func(t *testing.T, d *Document, site int)
+
+func ins(pos int, text string) edit {
+	return func(t *testing.T, d *Document, site int) {
+		for i, r := range text {
+			if _, err := d.InsertCharacterAs(site, pos+i, string(r)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func del(pos, n int) edit {
+	return func(t *testing.T, d *Document, site int) {
+		for i := 0; i < n; i++ {
+			if _, err := d.DeleteCharacter(pos); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name string
+		base string
+		a, b []edit
+		want string
+	}{
+		{name: "no changes", base: "one\ntwo\n", want: "one\ntwo\n"},
+		{name: "all empty", want: ""},
+		{name: "empty base", a: []edit{ins(1, "a\n")}, b: []edit{ins(1, "b\n")}, want: "a\nb\n"},
+		{name: "change in a", base: "one\n", a: []edit{del(1, 3), ins(1, "1")}, want: "1\n"},
+		{
+			name: "changes to different lines",
+			base: "one\ntwo\n",
+			a:    []edit{del(1, 3), ins(1, "1")},
+			b:    []edit{del(5, 3), ins(5, "2")},
+			want: "1\n2\n",
+		},
+		{
+			// no conflicts: both insertions stay, ordered by site
+			name: "conflicting changes",
+			base: "one\ntwo\n",
+			a:    []edit{del(5, 3), ins(5, "A")},
+			b:    []edit{del(5, 3), ins(5, "B")},
+			want: "one\nAB\n",
+		},
+		{
+			name: "same deletion",
+			base: "one\ntwo\n",
+			a:    []edit{del(1, 4)},
+			b:    []edit{del(1, 4)},
+			want: "two\n",
+		},
+		{
+			name: "overlapping deletions",
+			base: "one\ntwo\n",
+			a:    []edit{del(1, 5)},
+			b:    []edit{del(3, 4)},
+			want: "o\n",
+		},
+		{
+			name: "insertions at the start",
+			base: "one\n",
+			a:    []edit{ins(1, "a\n")},
+			b:    []edit{ins(1, "b\n")},
+			want: "a\nb\none\n",
+		},
+		{
+			name: "insertions at the end",
+			base: "one\n",
+			a:    []edit{ins(5, "a\n")},
+			b:    []edit{ins(5, "b\n")},
+			want: "one\na\nb\n",
+		},
+		{
+			name: "insertions at the start and at the end",
+			base: "one",
+			a:    []edit{ins(1, "a\n")},
+			b:    []edit{ins(4, "\nb")},
+			want: "a\none\nb",
+		},
+		{
+			name: "insertion into deleted text",
+			base: "one\ntwo",
+			a:    []edit{del(4, 4)},
+			b:    []edit{ins(7, "o")},
+			want: "oneo",
+		},
+		{
+			name: "no trailing newline",
+			base: "one\ntwo",
+			a:    []edit{ins(8, "\n")},
+			b:    []edit{ins(8, "!")},
+			want: "one\ntwo\n!",
+		},
+	}
+
+	for _, tt := range tests {
+		base := New()
+		ins(1, tt.base)(t, &base, 9)
+
+		a, b := Copy(base), Copy(base)
+		for _, e := range tt.a {
+			e(t, &a, 1)
+		}
+		for _, e := range tt.b {
+			e(t, &b, 2)
+		}
+
+		ab, err := Merge(base, a, b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := Content(ab); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		checkDocument(t, &ab)
+
+		// either order gives the same document
+		ba, err := Merge(base, b, a)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if Content(ba) != Content(ab) {
+			t.Errorf("%s: merging the other way gives %q, not %q", tt.name, Content(ba), Content(ab))
+		}
+	}
+}
+
+func TestMergeNotAncestor(t *testing.T) {
+	base := New()
+	ins(1, "ab")(t, &base, 9)
+
+	a := Copy(base)
+	b := New()
+	ins(1, "ab")(t, &b, 2)
+
+	if _, err := Merge(base, a, b); !errors.Is(err, ErrNotAncestor) {
+		t.Fatalf("got %v, want ErrNotAncestor", err)
+	}
+}
@@ -0,0 +1,136 @@
+package crdt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// stateFormat marks files holding a whole document rather than its
+	// text.
+	stateFormat = "woot"
+
+	// stateVersion is the version of the files written by SaveState.
+	stateVersion = 1
+)
+
+var (
+	ErrNotState      = errors.New("not a saved CRDT state")
+	ErrStateChecksum = errors.New("saved CRDT state is corrupted")
+)
+
+// State is a document as saved by SaveState, along with the replica that
+// saved it.
+type State struct {
+	Version int
+	Site    int
+	Clock   int
+	Document
+}
+
+// stateFile is the layout of a saved state. Checksum covers the encoded
+// payload, so that a damaged file isn't mistaken for a document.
+type stateFile struct {
+	Format   string          `json:"format"`
+	Version  int             `json:"version,omitempty"`
+	Site     int             `json:"site,omitempty"`
+	Clock    int             `json:"clock,omitempty"`
+	Checksum string          `json:"checksum,omitempty"`
+	Payload  json.RawMessage `json:"payload,omitempty"`
+}
+
+type statePayload struct {
+	Characters []Character `json:"characters"`
+	Comments   []Comment   `json:"comments,omitempty"`
+}
+
+// SaveState writes a whole document, IDs and deleted characters included,
+// along with the site and clock of this replica. Unlike Save it can be
+// loaded back as the same document.
+func SaveState(fileName string, doc *Document) error {
+	payload, err := json.Marshal(statePayload{Characters: doc.Characters, Comments: doc.Comments})
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	f := stateFile{Format: stateFormat, Version: stateVersion, Site: SiteID, Clock: LocalClock, Payload: payload}
+	mu.Unlock()
+	f.Checksum = checksum(payload)
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	// write aside first, so that a crash doesn't leave half a state
+	tmp := fileName + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// LoadState reads the document saved by SaveState. It returns ErrNotState
+// for other files, plain text in particular.
+func LoadState(fileName string) (Document, error) {
+	s, err := ReadState(fileName)
+	return s.Document, err
+}
+
+// ReadState reads a state saved by SaveState, checking that it wasn't
+// damaged.
+func ReadState(fileName string) (State, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return State{}, err
+	}
+
+	var f stateFile
+	if err := json.Unmarshal(content, &f); err != nil || f.Format != stateFormat {
+		return State{}, ErrNotState
+	}
+
+	if f.Version > stateVersion {
+		return State{}, fmt.Errorf("saved CRDT state has version %d, newer than %d", f.Version, stateVersion)
+	}
+	if f.Version != stateVersion {
+		return State{}, ErrNotState
+	}
+
+	if checksum(f.Payload) != f.Checksum {
+		return State{}, ErrStateChecksum
+	}
+	var p statePayload
+	if err := json.Unmarshal(f.Payload, &p); err != nil {
+		return State{}, fmt.Errorf("%w: %v", ErrStateChecksum, err)
+	}
+	return State{Version: f.Version, Site: f.Site, Clock: f.Clock, Document: Document{Characters: p.Characters, Comments: p.Comments}}, nil
+}
+
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Resume moves the local clock past every character of s, so that the
+// characters generated from now on can't take the ID of a saved one.
+func Resume(s State) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	LocalClock = max(LocalClock, s.Clock)
+	for _, char := range s.Characters {
+		if i := strings.LastIndexByte(char.ID, '.'); i != -1 {
+			if clock, err := strconv.Atoi(char.ID[i+1:]); err == nil {
+				LocalClock = max(LocalClock, clock)
+			}
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package crdt
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "text.woot")
+
+	doc := New()
+	for i, r := range "héllo" {
+		if _, err := doc.InsertCharacter(i+1, string(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := doc.DeleteCharacter(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveState(name, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := ReadState(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Characters) != len(doc.Characters) || Content(s.Document) != "éllo" {
+		t.Fatalf("read back %q with %d characters", Content(s.Document), len(s.Characters))
+	}
+}
+
+func TestReadStateErrors(t *testing.T) {
+	dir := t.TempDir()
+	doc := New()
+	if err := SaveState(filepath.Join(dir, "saved"), &doc); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(filepath.Join(dir, "saved"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, data string
+		err        error
+	}{
+		{"text", "hello\n", ErrNotState},
+		{"other json", `{"format":"pdf"}`, ErrNotState},
+		{"no version", `{"format":"woot","characters":[]}`, ErrNotState},
+		{"damaged", string(bytes.Replace(saved, []byte(`"start"`), []byte(`"begin"`), 1)), ErrStateChecksum},
+	}
+	for _, tt := range tests {
+		name := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(name, []byte(tt.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadState(name); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	name := filepath.Join(dir, "newer")
+	if err := os.WriteFile(name, []byte(`{"format":"woot","version":99}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadState(name); err == nil {
+		t.Error("newer version: no error")
+	}
+}
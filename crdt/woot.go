@@ -10,6 +10,7 @@ import (
 
 type Document struct {
 	Characters []Character
+	Comments   []Comment
 }
 
 type Character struct {
@@ -59,8 +60,8 @@ func Load(fileName string) (Document, error) {
 	lines := strings.Split(string(content), "\n")
 	pos := 1
 	for i := 0; i < len(lines); i++ {
-		for j := 0; j < len(lines[i]); j++ {
-			_, err := doc.Insert(pos, string(lines[i][j]))
+		for _, r := range lines[i] {
+			_, err := doc.Insert(pos, string(r))
 			if err != nil {
 				return doc, err
 			}
@@ -90,6 +91,18 @@ func (doc *Document) SetText(newDoc Document) {
 	}
 }
 
+// Copy returns a copy of doc that shares none of its characters and
+// comments, to hand to another goroutine.
+func Copy(doc Document) Document {
+	c := Document{
+		Characters: make([]Character, len(doc.Characters)),
+		Comments:   make([]Comment, len(doc.Comments)),
+	}
+	copy(c.Characters, doc.Characters)
+	copy(c.Comments, doc.Comments)
+	return c
+}
+
 func Content(doc Document) string {
 	value := ""
 	for _, char := range doc.Characters {
@@ -100,19 +113,20 @@ func Content(doc Document) string {
 	return value
 }
 
-func IthVisible(doc Document, position int) Character {
+// IthVisible returns the visible character at the 1-based position.
+func IthVisible(doc Document, position int) (Character, error) {
 	count := 0
 
 	for _, char := range doc.Characters {
 		if char.Visible {
 			if count == position-1 {
-				return char
+				return char, nil
 			}
 			count++
 		}
 	}
 
-	return Character{ID: "-1"}
+	return Character{ID: "-1"}, ErrPositionOutOfBounds
 }
 
 func (doc *Document) Length() int {
@@ -137,22 +151,92 @@ func (doc *Document) Position(charID string) int {
 	return -1
 }
 
+// Left returns the ID of the character before charID, or "" if there is
+// none or charID is missing.
 func (doc *Document) Left(charID string) string {
-	i := doc.Position(charID)
+	i := doc.Position(charID) - 1
 	if i <= 0 {
-		return doc.Characters[i].ID
+		return ""
 	}
 	return doc.Characters[i-1].ID
 }
 
+// Right returns the ID of the character after charID, or "" if there is
+// none or charID is missing.
 func (doc *Document) Right(charID string) string {
-	i := doc.Position(charID)
-	if i >= len(doc.Characters)-1 {
-		return doc.Characters[i-1].ID
+	i := doc.Position(charID) - 1
+	if i < 0 || i >= len(doc.Characters)-1 {
+		return ""
 	}
 	return doc.Characters[i+1].ID
 }
 
+// VisibleIndex returns the 0-based index of a character among the visible
+// ones, or -1 if it's deleted or missing.
+func (doc *Document) VisibleIndex(charID string) int {
+	index := 0
+	for _, char := range doc.Characters {
+		if char.ID == charID {
+			if !char.Visible {
+				return -1
+			}
+			return index
+		}
+		if char.Visible {
+			index++
+		}
+	}
+	return -1
+}
+
+// Span returns the visible range [start, end) of the characters from
+// startID to endID, skipping deleted ones. ok is false if none of them is
+// visible.
+func (doc *Document) Span(startID, endID string) (start, end int, ok bool) {
+	index := 0
+	start = -1
+	inside := false
+	for _, char := range doc.Characters {
+		if char.ID == startID {
+			inside = true
+		}
+		if char.Visible {
+			if inside && start == -1 {
+				start = index
+			}
+			index++
+		}
+		if char.ID == endID {
+			if start == -1 {
+				return 0, 0, false
+			}
+			return start, index, true
+		}
+	}
+	return 0, 0, false
+}
+
+// Site returns the site that generated the character with charID, or ""
+// for the start and end characters.
+func Site(charID string) string {
+	site, _, ok := strings.Cut(charID, ".")
+	if !ok {
+		return ""
+	}
+	return site
+}
+
+// Authors returns the site of every visible character.
+func (doc *Document) Authors() []string {
+	var sites []string
+	for _, char := range doc.Characters {
+		if char.Visible {
+			sites = append(sites, Site(char.ID))
+		}
+	}
+	return sites
+}
+
 func (doc *Document) Contains(charID string) bool {
 	position := doc.Position(charID)
 	return position != -1
@@ -198,13 +282,12 @@ func (doc *Document) LocalInsert(char Character, position int) (*Document, error
 		return doc, ErrEmptyWCharacter
 	}
 
+	// IDPrevious and IDNext keep the neighbours the character was generated
+	// with, IntegrateInsert needs them to order concurrent inserts.
 	doc.Characters = append(doc.Characters[:position],
 		append([]Character{char}, doc.Characters[position:]...)...,
 	)
 
-	doc.Characters[position-1].IDNext = char.ID
-	doc.Characters[position+1].IDPrevious = char.ID
-
 	return doc, nil
 }
 
@@ -221,41 +304,83 @@ func (doc *Document) IntegrateInsert(char, charPrev, charNext Character) (*Docum
 		return doc.LocalInsert(char, position)
 	}
 
-	if len(subsequence) == 1 {
-		return doc.LocalInsert(char, position-1)
+	// only the characters generated between the same bounds are concurrent
+	// with char, the others are ordered by them
+	prevPosition := doc.Position(charPrev.ID)
+	nextPosition := doc.Position(charNext.ID)
+
+	// a character lies between the ones it was generated between, so a
+	// bound outside of charPrev..charNext is beyond them
+	positions := make(map[string]int, nextPosition-prevPosition+1)
+	for i, c := range doc.Characters[prevPosition-1 : nextPosition] {
+		positions[c.ID] = prevPosition + i
+	}
+
+	bounds := []Character{charPrev}
+	for _, c := range subsequence {
+		p, prevInside := positions[c.IDPrevious]
+		n, nextInside := positions[c.IDNext]
+		if (!prevInside || p <= prevPosition) && (!nextInside || n >= nextPosition) {
+			bounds = append(bounds, c)
+		}
 	}
+	bounds = append(bounds, charNext)
 
 	i := 1
-	for i < len(subsequence)-1 && subsequence[i].ID < char.ID {
+	for i < len(bounds)-1 && bounds[i].ID < char.ID {
 		i++
 	}
-	return doc.IntegrateInsert(char, subsequence[i-1], subsequence[i])
+	return doc.IntegrateInsert(char, bounds[i-1], bounds[i])
 }
 
 func (doc *Document) GenerateInsert(position int, value string) (*Document, error) {
+	char, charPrev, charNext := doc.generateCharacter(SiteID, position, value)
+	return doc.IntegrateInsert(char, charPrev, charNext)
+}
+
+// generateCharacter creates a character of site for value at position,
+// along with its neighbours.
+func (doc *Document) generateCharacter(site, position int, value string) (Character, Character, Character) {
 	mu.Lock()
 	LocalClock++
+	clock := LocalClock
 	mu.Unlock()
 
-	charPrev := IthVisible(*doc, position-1)
-	charNext := IthVisible(*doc, position)
-
-	if charPrev.ID == "-1" {
+	charPrev, err := IthVisible(*doc, position-1)
+	if err != nil {
 		charPrev = doc.Find("start")
 	}
-	if charNext.ID == "-1" {
+	charNext, err := IthVisible(*doc, position)
+	if err != nil {
 		charNext = doc.Find("end")
 	}
 
 	char := Character{
-		ID:         fmt.Sprint(SiteID) + fmt.Sprint(LocalClock),
+		ID:         fmt.Sprintf("%d.%d", site, clock),
 		Visible:    true,
 		Value:      value,
 		IDPrevious: charPrev.ID,
 		IDNext:     charNext.ID,
 	}
 
-	return doc.IntegrateInsert(char, charPrev, charNext)
+	return char, charPrev, charNext
+}
+
+// IntegrateCharacter inserts a character generated at another site between
+// the neighbours it was generated with.
+func (doc *Document) IntegrateCharacter(char Character) error {
+	if doc.Contains(char.ID) {
+		return nil
+	}
+
+	charPrev := doc.Find(char.IDPrevious)
+	charNext := doc.Find(char.IDNext)
+	if charPrev.ID == "-1" || charNext.ID == "-1" {
+		return ErrBoundsNotPresent
+	}
+
+	_, err := doc.IntegrateInsert(char, charPrev, charNext)
+	return err
 }
 
 // ////////////////////////////////////////////////////////////////////
@@ -272,7 +397,10 @@ func (doc *Document) IntegrateDelete(char Character) *Document {
 }
 
 func (doc *Document) GenerateDelete(position int) *Document {
-	char := IthVisible(*doc, position)
+	char, err := IthVisible(*doc, position)
+	if err != nil {
+		return doc
+	}
 	return doc.IntegrateDelete(char)
 }
 
@@ -291,3 +419,29 @@ func (doc *Document) Delete(position int) string {
 	newDoc := doc.GenerateDelete(position)
 	return Content(*newDoc)
 }
+
+// InsertCharacter inserts value at position like Insert, returning the new
+// character so that other sites can integrate it.
+func (doc *Document) InsertCharacter(position int, value string) (Character, error) {
+	return doc.InsertCharacterAs(SiteID, position, value)
+}
+
+// InsertCharacterAs inserts like InsertCharacter, but as site rather than
+// SiteID, so that replicas of several sites can live in one process.
+func (doc *Document) InsertCharacterAs(site, position int, value string) (Character, error) {
+	char, charPrev, charNext := doc.generateCharacter(site, position, value)
+	_, err := doc.IntegrateInsert(char, charPrev, charNext)
+	return char, err
+}
+
+// DeleteCharacter deletes the character at position like Delete, returning
+// it so that other sites can delete the same one. There is nothing to
+// delete outside of the text, that gives ErrPositionOutOfBounds.
+func (doc *Document) DeleteCharacter(position int) (Character, error) {
+	char, err := IthVisible(*doc, position)
+	if err != nil {
+		return char, err
+	}
+	doc.IntegrateDelete(char)
+	return char, nil
+}
@@ -0,0 +1,202 @@
+package crdt
+
+import (
+	"testing"
+)
+
+// runProgram plays data as a program on n replicas, two bytes per
+// instruction: the first picks the replica and what to do, the second is a
+// position or a message. Every message is delivered at the end.
+func runProgram(t *testing.T, n int, data []byte) []*replica {
+	replicas := newReplicas(t, n)
+
+	for i := 0; i+1 < len(data); i += 2 {
+		r := replicas[int(data[i]&0x3f)%n]
+		arg := int(data[i+1])
+		length := len([]rune(Content(r.doc)))
+
+		switch data[i] >> 6 {
+		case 0, 1:
+			generate(t, r, replicas, insert(arg%(length+1)+1, string(rune('a'+arg%26))))
+
+		case 2:
+			if length == 0 {
+				continue
+			}
+			generate(t, r, replicas, remove(arg%length+1))
+
+		case 3:
+			if len(r.inbox) > 0 {
+				r.deliver(t, arg%len(r.inbox))
+			}
+		}
+
+		checkDocument(t, &r.doc)
+	}
+
+	for _, r := range replicas {
+		r.flush(t, func(n int) int { return n - 1 })
+		checkDocument(t, &r.doc)
+	}
+	return replicas
+}
+
+// checkDocument checks the invariants of a WOOT document: the bounds at
+// both ends, unique IDs, and every character between the ones it was
+// generated between.
+func checkDocument(t *testing.T, doc *Document) {
+	t.Helper()
+
+	chars := doc.Characters
+	if len(chars) < 2 || chars[0].ID != "start" || chars[len(chars)-1].ID != "end" {
+		t.Fatalf("document isn't bounded by start and end: %+v", chars)
+	}
+
+	position := make(map[string]int, len(chars))
+	for i, c := range chars {
+		if _, ok := position[c.ID]; ok {
+			t.Fatalf("ID %s appears twice", c.ID)
+		}
+		position[c.ID] = i
+	}
+
+	for i, c := range chars[1 : len(chars)-1] {
+		prev, okPrev := position[c.IDPrevious]
+		next, okNext := position[c.IDNext]
+		if !okPrev || !okNext {
+			t.Fatalf("%s was generated next to missing characters %q and %q", c.ID, c.IDPrevious, c.IDNext)
+		}
+		if prev >= i+1 || next <= i+1 {
+			t.Fatalf("%s at %d isn't between %s at %d and %s at %d", c.ID, i+1, c.IDPrevious, prev, c.IDNext, next)
+		}
+	}
+}
+
+func FuzzReplicas(f *testing.F) {
+	// two sites typing at the same place, then everyone catching up
+	f.Add(uint8(2), []byte{0x00, 0, 0x01, 0, 0xc0, 0, 0xc1, 0})
+	// delete of a character the site hasn't received yet
+	f.Add(uint8(3), []byte{0x00, 1, 0xc1, 0, 0x81, 0, 0xc2, 1, 0xc2, 0})
+	// a longer mixed program
+	f.Add(uint8(4), []byte("\x00a\x01b\x02c\x43d\xc0\x00\x81\x01\xc1\x02\x00z\xc2\x05\x82\x00\xc3\x07"))
+
+	f.Fuzz(func(t *testing.T, n uint8, data []byte) {
+		// short programs find the same bugs, and minimizing stays quick
+		if len(data) > 256 {
+			data = data[:256]
+		}
+		n = n%4 + 2
+		checkConverged(t, runProgram(t, int(n), data))
+	})
+}
+
+func FuzzNeighbours(f *testing.F) {
+	f.Add("", "start")
+	f.Add("ab", "end")
+	f.Add("abc", "0.2")
+	f.Add("x", "missing")
+
+	f.Fuzz(func(t *testing.T, text, id string) {
+		// every pair is checked, keep it quick
+		if len(text) > 64 {
+			text = text[:64]
+		}
+
+		doc := New()
+		for i, r := range []rune(text) {
+			if _, err := doc.Insert(i+1, string(r)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkDocument(t, &doc)
+
+		ids := []string{id, "start", "end", ""}
+		for _, c := range doc.Characters {
+			ids = append(ids, c.ID)
+		}
+
+		for _, a := range ids {
+			left, right := doc.Left(a), doc.Right(a)
+			p := doc.Position(a)
+			switch {
+			case p == -1:
+				if left != "" || right != "" {
+					t.Fatalf("%q is missing but has neighbours %q and %q", a, left, right)
+				}
+			default:
+				if left != "" && doc.Right(left) != a {
+					t.Fatalf("right of the left of %q is %q", a, doc.Right(left))
+				}
+				if right != "" && doc.Left(right) != a {
+					t.Fatalf("left of the right of %q is %q", a, doc.Left(right))
+				}
+				if (left == "") != (a == "start") || (right == "") != (a == "end") {
+					t.Fatalf("%q has neighbours %q and %q", a, left, right)
+				}
+			}
+
+			for _, b := range ids {
+				sub, err := doc.Subseq(Character{ID: a}, Character{ID: b})
+				q := doc.Position(b)
+				switch {
+				case p == -1 || q == -1 || p > q:
+					if err == nil {
+						t.Fatalf("subsequence from %q to %q didn't fail", a, b)
+					}
+				case err != nil:
+					t.Fatalf("subsequence from %q to %q: %v", a, b, err)
+				case len(sub) != max(q-p-1, 0):
+					t.Fatalf("subsequence from %q to %q has %d characters", a, b, len(sub))
+				}
+			}
+		}
+	})
+}
+
+func FuzzLocalInsert(f *testing.F) {
+	f.Add("ab", 0, "x")
+	f.Add("ab", 1, "x")
+	f.Add("ab", 3, "x")
+	f.Add("ab", 4, "x")
+	f.Add("", 1, "")
+	f.Add("abc", -1, "y")
+
+	f.Fuzz(func(t *testing.T, text string, position int, id string) {
+		if len(text) > 256 {
+			text = text[:256]
+		}
+
+		doc := New()
+		for i, r := range []rune(text) {
+			if _, err := doc.Insert(i+1, string(r)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before := len(doc.Characters)
+
+		char := Character{ID: id, Visible: true, Value: "#"}
+		_, err := doc.LocalInsert(char, position)
+
+		switch {
+		case position <= 0 || position >= before:
+			if err != ErrPositionOutOfBounds {
+				t.Fatalf("inserting at %d of %d gave %v", position, before, err)
+			}
+		case id == "":
+			if err != ErrEmptyWCharacter {
+				t.Fatalf("inserting an empty ID gave %v", err)
+			}
+		case err != nil:
+			t.Fatal(err)
+		case len(doc.Characters) != before+1 || doc.Characters[position].ID != id:
+			t.Fatalf("%q isn't at %d: %+v", id, position, doc.Characters)
+		}
+
+		if err != nil && len(doc.Characters) != before {
+			t.Fatal("a failed insert changed the document")
+		}
+		if doc.Characters[0].ID != "start" || doc.Characters[len(doc.Characters)-1].ID != "end" {
+			t.Fatalf("insert at %d moved the bounds: %+v", position, doc.Characters)
+		}
+	})
+}
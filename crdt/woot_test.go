@@ -0,0 +1,225 @@
+package crdt
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// op is an insertion or deletion to repeat at the other sites.
+type op struct {
+	insert bool
+	char   Character
+}
+
+// replica is a site along with the operations it didn't integrate yet.
+type replica struct {
+	site  int
+	doc   Document
+	inbox []op
+}
+
+// generate runs f as the replica's site and sends the operation it returns
+// to the others.
+func generate(t *testing.T, r *replica, others []*replica, f func(d *Document) (op, error)) {
+	t.Helper()
+	SiteID = r.site
+	o, err := f(&r.doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, other := range others {
+		if other != r {
+			other.inbox = append(other.inbox, o)
+		}
+	}
+}
+
+func insert(pos int, value string) func(d *Document) (op, error) {
+	return func(d *Document) (op, error) {
+		char, err := d.InsertCharacter(pos, value)
+		return op{insert: true, char: char}, err
+	}
+}
+
+func remove(pos int) func(d *Document) (op, error) {
+	return func(d *Document) (op, error) {
+		char, err := d.DeleteCharacter(pos)
+		return op{char: char}, err
+	}
+}
+
+// deliver integrates the i-th waiting operation, false if the characters it
+// depends on aren't there yet.
+func (r *replica) deliver(t *testing.T, i int) bool {
+	t.Helper()
+	o := r.inbox[i]
+	if o.insert {
+		err := r.doc.IntegrateCharacter(o.char)
+		if errors.Is(err, ErrBoundsNotPresent) {
+			return false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		if !r.doc.Contains(o.char.ID) {
+			return false
+		}
+		r.doc.IntegrateDelete(o.char)
+	}
+	r.inbox = append(r.inbox[:i], r.inbox[i+1:]...)
+	return true
+}
+
+// flush delivers every waiting operation, in the order given by pick.
+func (r *replica) flush(t *testing.T, pick func(n int) int) {
+	t.Helper()
+	for len(r.inbox) > 0 {
+		start, delivered := pick(len(r.inbox)), false
+		for tries := 0; tries < len(r.inbox) && !delivered; tries++ {
+			delivered = r.deliver(t, (start+tries)%len(r.inbox))
+		}
+		if !delivered {
+			t.Fatalf("site %d can't integrate %d operations", r.site, len(r.inbox))
+		}
+	}
+}
+
+// checkConverged checks that the replicas hold the same characters in the
+// same order.
+func checkConverged(t *testing.T, replicas []*replica) {
+	t.Helper()
+	for _, r := range replicas[1:] {
+		a, b := replicas[0].doc.Characters, r.doc.Characters
+		same := len(a) == len(b)
+		for i := 0; same && i < len(a); i++ {
+			same = a[i].ID == b[i].ID && a[i].Visible == b[i].Visible
+		}
+		if !same {
+			t.Fatalf("site %d has %q, site %d has %q", replicas[0].site, Content(replicas[0].doc), r.site, Content(r.doc))
+		}
+	}
+}
+
+func newReplicas(t *testing.T, n int) []*replica {
+	site := SiteID
+	t.Cleanup(func() { SiteID = site })
+
+	var replicas []*replica
+	for i := 1; i <= n; i++ {
+		replicas = append(replicas, &replica{site: i, doc: New()})
+	}
+	return replicas
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+func TestIDsDontCollide(t *testing.T) {
+	// site 1 at clock 11 and site 11 at clock 1 used to both make "111"
+	ids := map[string]bool{}
+	d := New()
+	for _, site := range []int{1, 11} {
+		SiteID = site
+		for i := 0; i < 11; i++ {
+			char, err := d.InsertCharacter(1, "x")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids[char.ID] {
+				t.Fatalf("ID %s given out twice", char.ID)
+			}
+			ids[char.ID] = true
+		}
+	}
+}
+
+// Inserts between the same characters converge whatever the order they
+// arrive in, and so do inserts between those.
+func TestConcurrentInsertsConverge(t *testing.T) {
+	orders := [][]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+	for _, order := range orders {
+		replicas := newReplicas(t, 3)
+		generate(t, replicas[0], replicas, insert(1, "a"))
+		generate(t, replicas[0], replicas, insert(2, "b"))
+		for _, r := range replicas[1:] {
+			r.flush(t, func(int) int { return 0 })
+		}
+
+		// each site types between "a" and "b", the last one twice
+		generate(t, replicas[0], replicas, insert(2, "x"))
+		generate(t, replicas[1], replicas, insert(2, "y"))
+		generate(t, replicas[2], replicas, insert(2, "z"))
+		generate(t, replicas[2], replicas, insert(2, "w"))
+
+		for _, r := range replicas {
+			for _, i := range order {
+				for j := range r.inbox {
+					if r.inbox[j].char.ID[0]-'1' == byte(i) {
+						r.deliver(t, j)
+						break
+					}
+				}
+			}
+			r.flush(t, func(int) int { return 0 })
+		}
+		checkConverged(t, replicas)
+
+		if got := Content(replicas[0].doc); len(got) != 6 || got[0] != 'a' || got[5] != 'b' {
+			t.Fatalf("order %v: got %q", order, got)
+		}
+	}
+}
+
+// Sites typing and deleting at once converge once every operation arrived,
+// in any order that respects what each one depends on.
+func TestRandomEditsConverge(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		replicas := newReplicas(t, 3)
+
+		for step := 0; step < 200; step++ {
+			r := replicas[rnd.Intn(len(replicas))]
+			switch length := len([]rune(Content(r.doc))); {
+			case len(r.inbox) > 0 && rnd.Intn(3) == 0:
+				r.deliver(t, rnd.Intn(len(r.inbox)))
+			case length > 0 && rnd.Intn(4) == 0:
+				generate(t, r, replicas, remove(1+rnd.Intn(length)))
+			default:
+				generate(t, r, replicas, insert(1+rnd.Intn(length+1), string(rune('a'+rnd.Intn(26)))))
+			}
+		}
+
+		for _, r := range replicas {
+			r.flush(t, rnd.Intn)
+		}
+		checkConverged(t, replicas)
+	}
+}
+
+func TestDeleteOutOfRange(t *testing.T) {
+	d := New()
+	for i, r := range "ab" {
+		if _, err := d.InsertCharacter(i+1, string(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pos := range []int{-1, 0, 3} {
+		if _, err := IthVisible(d, pos); !errors.Is(err, ErrPositionOutOfBounds) {
+			t.Errorf("IthVisible(%d): got %v, want ErrPositionOutOfBounds", pos, err)
+		}
+		if _, err := d.DeleteCharacter(pos); !errors.Is(err, ErrPositionOutOfBounds) {
+			t.Errorf("DeleteCharacter(%d): got %v, want ErrPositionOutOfBounds", pos, err)
+		}
+	}
+	if got := Content(d); got != "ab" {
+		t.Fatalf("got %q after deleting outside of the text", got)
+	}
+
+	char, err := d.DeleteCharacter(2)
+	if err != nil || char.Value != "b" || Content(d) != "a" {
+		t.Fatalf("DeleteCharacter(2) = %+v, %v, text %q", char, err, Content(d))
+	}
+}
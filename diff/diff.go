@@ -0,0 +1,261 @@
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Edit is a line kept, inserted or deleted when going from a to b. A and B
+// are the indexes of the line in a and b, -1 where it doesn't exist.
+type Edit struct {
+	Op   Op
+	A, B int
+	Text string
+}
+
+// SplitLines splits text into lines without their newlines. A trailing
+// newline doesn't start another line.
+func SplitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Lines returns the shortest edit script turning a into b, using Myers'
+// algorithm in linear space: the middle snake of the shortest path splits
+// the texts in two, which are compared the same way.
+func Lines(a, b []string) []Edit {
+	l := lines{a: a, b: b}
+	l.compare(0, len(a), 0, len(b))
+
+	// the deletions of a change come before its insertions
+	for i := 0; i < len(l.edits); {
+		if l.edits[i].Op == Equal {
+			i++
+			continue
+		}
+		j := i
+		for j < len(l.edits) && l.edits[j].Op != Equal {
+			j++
+		}
+		sort.SliceStable(l.edits[i:j], func(x, y int) bool {
+			return l.edits[i+x].Op == Delete && l.edits[i+y].Op == Insert
+		})
+		i = j
+	}
+	return l.edits
+}
+
+// lines compares two texts, collecting the edits in order.
+type lines struct {
+	a, b  []string
+	edits []Edit
+}
+
+// compare adds the edits turning a[a0:a1] into b[b0:b1].
+func (l *lines) compare(a0, a1, b0, b1 int) {
+	for a0 < a1 && b0 < b1 && l.a[a0] == l.b[b0] {
+		l.edits = append(l.edits, Edit{Op: Equal, A: a0, B: b0, Text: l.a[a0]})
+		a0++
+		b0++
+	}
+	suffix := 0
+	for a1 > a0 && b1 > b0 && l.a[a1-1] == l.b[b1-1] {
+		a1--
+		b1--
+		suffix++
+	}
+
+	x, y := -1, -1
+	if a0 < a1 && b0 < b1 {
+		x, y = l.middle(a0, a1, b0, b1)
+	}
+	if x != -1 {
+		l.compare(a0, x, b0, y)
+		l.compare(x, a1, y, b1)
+	} else {
+		for ; a0 < a1; a0++ {
+			l.edits = append(l.edits, Edit{Op: Delete, A: a0, B: -1, Text: l.a[a0]})
+		}
+		for ; b0 < b1; b0++ {
+			l.edits = append(l.edits, Edit{Op: Insert, A: -1, B: b0, Text: l.b[b0]})
+		}
+	}
+
+	for i := 0; i < suffix; i++ {
+		l.edits = append(l.edits, Edit{Op: Equal, A: a1 + i, B: b1 + i, Text: l.a[a1+i]})
+	}
+}
+
+// middle returns where the shortest path from (a0, b0) to (a1, b1) meets
+// the one searched back from the end, or -1, -1 if the texts have no line
+// in common. The texts differ in their first and last lines.
+func (l *lines) middle(a0, a1, b0, b1 int) (int, int) {
+	n, m := a1-a0, b1-b0
+	maxD := (n + m + 1) / 2
+	offset := maxD
+
+	// forward[k+offset] is the furthest x on diagonal k from the start,
+	// backward[k+offset] the furthest from the end
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	// the paths meet going forward if delta is odd
+	odd := delta%2 != 0
+
+	// diagonals that went past the end of a text aren't searched further
+	var forwardStart, forwardEnd, backwardStart, backwardEnd int
+	for d := 0; d < maxD; d++ {
+		for k := -d + forwardStart; k <= d-forwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && forward[k-1+offset] < forward[k+1+offset]) {
+				x = forward[k+1+offset]
+			} else {
+				x = forward[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && l.a[a0+x] == l.b[b0+y] {
+				x++
+				y++
+			}
+			forward[k+offset] = x
+
+			switch {
+			case x > n:
+				forwardEnd += 2
+			case y > m:
+				forwardStart += 2
+			case odd:
+				i := delta - k + offset
+				if i >= 0 && i < len(backward) && backward[i] != -1 && x >= n-backward[i] {
+					return a0 + x, b0 + y
+				}
+			}
+		}
+
+		for k := -d + backwardStart; k <= d-backwardEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && backward[k-1+offset] < backward[k+1+offset]) {
+				x = backward[k+1+offset]
+			} else {
+				x = backward[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && l.a[a1-x-1] == l.b[b1-y-1] {
+				x++
+				y++
+			}
+			backward[k+offset] = x
+
+			switch {
+			case x > n:
+				backwardEnd += 2
+			case y > m:
+				backwardStart += 2
+			case !odd:
+				i := delta - k + offset
+				if i >= 0 && i < len(forward) && forward[i] != -1 && forward[i] >= n-x {
+					fx := forward[i]
+					return a0 + fx, b0 + fx - (i - offset)
+				}
+			}
+		}
+	}
+	return -1, -1
+}
+
+// Unified returns the differences between the texts a and b in unified
+// format with context lines around every change, or "" if they're equal.
+func Unified(nameA, nameB, a, b string, context int) string {
+	edits := Lines(SplitLines(a), SplitLines(b))
+
+	var out strings.Builder
+	for i := 0; i < len(edits); {
+		// find the next change
+		for i < len(edits) && edits[i].Op == Equal {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		// grow the hunk while changes are close to each other
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].Op != Equal {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end = min(end+context, len(edits))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		writeHunk(&out, edits[start:end])
+		i = end
+	}
+	return out.String()
+}
+
+func writeHunk(out *strings.Builder, edits []Edit) {
+	startA, startB := -1, -1
+	countA, countB := 0, 0
+	for _, e := range edits {
+		if e.Op != Insert {
+			if startA == -1 {
+				startA = e.A
+			}
+			countA++
+		}
+		if e.Op != Delete {
+			if startB == -1 {
+				startB = e.B
+			}
+			countB++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(startA, countA), hunkRange(startB, countB))
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			out.WriteString(" ")
+		case Insert:
+			out.WriteString("+")
+		case Delete:
+			out.WriteString("-")
+		}
+		out.WriteString(e.Text)
+		out.WriteString("\n")
+	}
+}
+
+// hunkRange formats the 1-based start and length of a hunk. An empty
+// range starts at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return "0,0"
+	}
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
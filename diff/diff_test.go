@@ -0,0 +1,141 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// check fails unless edits turn a into b with n changes.
+func check(t *testing.T, a, b []string, edits []Edit, n int) {
+	t.Helper()
+	x, y, changes := 0, 0, 0
+	for _, e := range edits {
+		switch {
+		case e.Op == Equal && e.A == x && e.B == y && x < len(a) && y < len(b) && a[x] == b[y] && e.Text == a[x]:
+			x++
+			y++
+		case e.Op == Delete && e.A == x && e.B == -1 && x < len(a) && e.Text == a[x]:
+			x++
+			changes++
+		case e.Op == Insert && e.A == -1 && e.B == y && y < len(b) && e.Text == b[y]:
+			y++
+			changes++
+		default:
+			t.Fatalf("%q to %q: bad edit %+v in %+v", a, b, e, edits)
+		}
+	}
+	if x != len(a) || y != len(b) {
+		t.Fatalf("%q to %q: the edits stop at %d, %d", a, b, x, y)
+	}
+	if n >= 0 && changes != n {
+		t.Fatalf("%q to %q: %d changes, want %d", a, b, changes, n)
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		n    int
+	}{
+		{"", "", 0},
+		{"a\nb\n", "a\nb\n", 0},
+		{"", "a\nb\n", 2},
+		{"a\nb\n", "", 2},
+		{"a\nb\nc\n", "a\nc\n", 1},
+		{"a\nb\nc\na\nb\nb\na\n", "c\nb\na\nb\na\nc\n", 5},
+		{"a\nb\n", "c\nd\n", 4},
+	}
+	for _, tt := range tests {
+		a, b := SplitLines(tt.a), SplitLines(tt.b)
+		check(t, a, b, Lines(a, b), tt.n)
+	}
+
+	// against the length of the longest common subsequence
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(r, 15, 3), randomLines(r, 15, 3)
+		check(t, a, b, Lines(a, b), len(a)+len(b)-2*lcs(a, b))
+	}
+}
+
+// The deletions of a change come first.
+func TestLinesOrder(t *testing.T) {
+	edits := Lines([]string{"x", "a", "b", "y"}, []string{"x", "c", "d", "y"})
+	var ops []Op
+	for _, e := range edits {
+		ops = append(ops, e.Op)
+	}
+	if fmt.Sprint(ops) != fmt.Sprint([]Op{Equal, Delete, Delete, Insert, Insert, Equal}) {
+		t.Fatalf("got %v", ops)
+	}
+}
+
+// Texts with nothing in common take memory in proportion to their length.
+func TestLinesUnrelated(t *testing.T) {
+	a, b := make([]string, 6000), make([]string, 6000)
+	for i := range a {
+		a[i], b[i] = fmt.Sprint("a", i), fmt.Sprint("b", i)
+	}
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	edits := Lines(a, b)
+	runtime.ReadMemStats(&after)
+
+	check(t, a, b, edits, len(a)+len(b))
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 16<<20 {
+		t.Fatalf("allocated %d MB", alloc>>20)
+	}
+}
+
+func BenchmarkLines(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{1000, 6000} {
+		// similar texts, and texts with nothing in common
+		similar, unrelated := make([]string, n), make([]string, n)
+		for i := range similar {
+			similar[i], unrelated[i] = fmt.Sprint(r.Intn(1000)), fmt.Sprint("other", i)
+		}
+		edited := append([]string(nil), similar...)
+		for i := 0; i < n/20; i++ {
+			edited[r.Intn(n)] = "changed"
+		}
+
+		b.Run(fmt.Sprintf("similar/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Lines(similar, edited)
+			}
+		})
+		b.Run(fmt.Sprintf("unrelated/%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Lines(similar, unrelated)
+			}
+		})
+	}
+}
+
+// randomLines returns up to n lines out of k different ones.
+func randomLines(r *rand.Rand, n, k int) []string {
+	lines := make([]string, r.Intn(n+1))
+	for i := range lines {
+		lines[i] = fmt.Sprint(r.Intn(k))
+	}
+	return lines
+}
+
+func lcs(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(prev[j+1], cur[j])
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
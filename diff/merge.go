@@ -0,0 +1,83 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+)
+
+// Merge3 merges the changes made to base in a and in b, line by line. Where
+// both changed the same lines differently, the result holds both versions
+// between conflict markers labelled nameA and nameB. It returns the merged
+// text along with the number of conflicts.
+func Merge3(base, a, b, nameA, nameB string) (string, int) {
+	baseLines := SplitLines(base)
+	aLines, bLines := SplitLines(a), SplitLines(b)
+
+	// the line of a and b each base line was kept as, or -1
+	inA := matches(baseLines, aLines)
+	inB := matches(baseLines, bLines)
+
+	var out []string
+	conflicts := 0
+
+	i, ia, ib := 0, 0, 0
+	for {
+		// the next base line both kept ends the chunk
+		next := i
+		for next < len(baseLines) && (inA[next] < 0 || inB[next] < 0) {
+			next++
+		}
+		ja, jb := len(aLines), len(bLines)
+		if next < len(baseLines) {
+			ja, jb = inA[next], inB[next]
+		}
+
+		chunkBase, chunkA, chunkB := baseLines[i:next], aLines[ia:ja], bLines[ib:jb]
+		switch {
+		case slices.Equal(chunkA, chunkBase):
+			out = append(out, chunkB...)
+		case slices.Equal(chunkB, chunkBase), slices.Equal(chunkA, chunkB):
+			out = append(out, chunkA...)
+		default:
+			conflicts++
+			out = append(out, "<<<<<<< "+nameA)
+			out = append(out, chunkA...)
+			out = append(out, "=======")
+			out = append(out, chunkB...)
+			out = append(out, ">>>>>>> "+nameB)
+		}
+
+		if next == len(baseLines) {
+			break
+		}
+		out = append(out, baseLines[next])
+		i, ia, ib = next+1, ja+1, jb+1
+	}
+
+	if len(out) == 0 {
+		return "", conflicts
+	}
+
+	// the missing newline at the end is a change like any other
+	newline := strings.HasSuffix(a, "\n") || a == ""
+	if newline == (strings.HasSuffix(base, "\n") || base == "") {
+		newline = strings.HasSuffix(b, "\n") || b == ""
+	}
+	if !newline {
+		return strings.Join(out, "\n"), conflicts
+	}
+	return strings.Join(out, "\n") + "\n", conflicts
+}
+
+func matches(base, other []string) []int {
+	in := make([]int, len(base))
+	for i := range in {
+		in[i] = -1
+	}
+	for _, e := range Lines(base, other) {
+		if e.Op == Equal {
+			in[e.A] = e.B
+		}
+	}
+	return in
+}
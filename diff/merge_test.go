@@ -0,0 +1,115 @@
+package diff
+
+import "testing"
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name          string
+		base, a, b    string
+		want          string
+		wantConflicts int
+	}{
+		{
+			name: "no changes",
+			base: "one\ntwo\n", a: "one\ntwo\n", b: "one\ntwo\n",
+			want: "one\ntwo\n",
+		},
+		{
+			name: "change in a",
+			base: "one\ntwo\nthree\n", a: "one\n2\nthree\n", b: "one\ntwo\nthree\n",
+			want: "one\n2\nthree\n",
+		},
+		{
+			name: "changes to different lines",
+			base: "one\ntwo\nthree\n", a: "1\ntwo\nthree\n", b: "one\ntwo\n3\n",
+			want: "1\ntwo\n3\n",
+		},
+		{
+			name: "identical changes",
+			base: "one\ntwo\nthree\n", a: "one\n2\nthree\n", b: "one\n2\nthree\n",
+			want: "one\n2\nthree\n",
+		},
+		{
+			name: "conflicting changes",
+			base: "one\ntwo\nthree\n", a: "one\nA\nthree\n", b: "one\nB\nthree\n",
+			want:          "one\n<<<<<<< a\nA\n=======\nB\n>>>>>>> b\nthree\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "two conflicts",
+			base: "one\ntwo\nthree\n", a: "1a\ntwo\n3a\n", b: "1b\ntwo\n3b\n",
+			want:          "<<<<<<< a\n1a\n=======\n1b\n>>>>>>> b\ntwo\n<<<<<<< a\n3a\n=======\n3b\n>>>>>>> b\n",
+			wantConflicts: 2,
+		},
+		{
+			name: "change against deletion",
+			base: "one\ntwo\nthree\n", a: "one\nA\nthree\n", b: "one\nthree\n",
+			want:          "one\n<<<<<<< a\nA\n=======\n>>>>>>> b\nthree\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "insertions at the start",
+			base: "one\n", a: "a\none\n", b: "one\n",
+			want: "a\none\n",
+		},
+		{
+			name: "different insertions at the start",
+			base: "one\n", a: "a\none\n", b: "b\none\n",
+			want:          "<<<<<<< a\na\n=======\nb\n>>>>>>> b\none\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "insertions at the start and at the end",
+			base: "one\n", a: "a\none\n", b: "one\nb\n",
+			want: "a\none\nb\n",
+		},
+		{
+			name: "different insertions at the end",
+			base: "one\n", a: "one\na\n", b: "one\nb\n",
+			want:          "one\n<<<<<<< a\na\n=======\nb\n>>>>>>> b\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "empty base",
+			base: "", a: "a\n", b: "",
+			want: "a\n",
+		},
+		{
+			name: "empty base, both added",
+			base: "", a: "a\n", b: "b\n",
+			want:          "<<<<<<< a\na\n=======\nb\n>>>>>>> b\n",
+			wantConflicts: 1,
+		},
+		{
+			name: "everything deleted",
+			base: "one\ntwo\n", a: "", b: "one\ntwo\n",
+			want: "",
+		},
+		{
+			name: "all empty",
+			want: "",
+		},
+		{
+			name: "no trailing newline anywhere",
+			base: "one\ntwo\nthree", a: "1\ntwo\nthree", b: "one\ntwo\n3",
+			want: "1\ntwo\n3",
+		},
+		{
+			name: "trailing newline removed in a",
+			base: "one\ntwo\n", a: "one\ntwo", b: "1\ntwo\n",
+			want: "1\ntwo",
+		},
+		{
+			name: "trailing newline added in b",
+			base: "one\ntwo", a: "1\ntwo", b: "one\ntwo\n",
+			want: "1\ntwo\n",
+		},
+	}
+
+	for _, tt := range tests {
+		got, conflicts := Merge3(tt.base, tt.a, tt.b, "a", "b")
+		if got != tt.want || conflicts != tt.wantConflicts {
+			t.Errorf("%s: got %q with %d conflicts, want %q with %d", tt.name, got, conflicts, tt.want, tt.wantConflicts)
+		}
+	}
+}
@@ -6,6 +6,7 @@ toolchain go1.22.5
 
 require (
 	github.com/Pallinder/go-randomdata v1.2.0
+	github.com/alecthomas/chroma/v2 v2.17.2
 	github.com/fatih/color v1.13.0
 	github.com/google/go-cmp v0.5.9
 	github.com/google/uuid v1.3.0
@@ -17,7 +18,6 @@ require (
 
 require (
 	github.com/alecthomas/chroma v0.10.0 // indirect
-	github.com/alecthomas/chroma/v2 v2.17.2 // indirect
 	github.com/dlclark/regexp2 v1.11.5 // indirect
 	github.com/mattn/go-colorable v0.1.9 // indirect
 	github.com/mattn/go-isatty v0.0.16 // indirect
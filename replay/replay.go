@@ -0,0 +1,301 @@
+// Package replay feeds a session recording to fresh replicas, one per
+// client, to find where they diverged.
+//
+// A replica sees what its client saw: the operations it sent and the
+// messages the server sent it. Documents received when joining replace the
+// replica's copy, as in the terminal client. Two replicas that integrated
+// the same characters must hold the same text, the first place where they
+// don't is the divergence.
+package replay
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"diploma/commons"
+	"diploma/crdt"
+	"diploma/diff"
+
+	"github.com/google/uuid"
+)
+
+// Replica is the state of a client rebuilt from the recording.
+type Replica struct {
+	Client   uuid.UUID
+	Site     string
+	Username string
+
+	// Live is false once the client left.
+	Live bool
+
+	docs    map[string]*crdt.Document
+	pending map[string]*commons.Pending
+}
+
+func (r *Replica) String() string {
+	if r.Username == "" {
+		return "site " + r.Site
+	}
+	return fmt.Sprintf("site %s (%s)", r.Site, r.Username)
+}
+
+// Paths returns the documents the replica has, sorted.
+func (r *Replica) Paths() []string {
+	var paths []string
+	for p := range r.docs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Content returns the text of the document at path, false if the replica
+// doesn't have it.
+func (r *Replica) Content(path string) (string, bool) {
+	d, ok := r.docs[path]
+	if !ok {
+		return "", false
+	}
+	return crdt.Content(*d), true
+}
+
+// Waiting returns the number of operations on the document at path that
+// wait for characters the replica never got.
+func (r *Replica) Waiting(path string) int {
+	if p, ok := r.pending[path]; ok {
+		return p.Len()
+	}
+	return 0
+}
+
+// Dropped returns the number of operations on the document at path that
+// the replica gave up on: malformed ones and ones that waited too long.
+func (r *Replica) Dropped(path string) int {
+	if p, ok := r.pending[path]; ok {
+		return p.Dropped()
+	}
+	return 0
+}
+
+// Divergence is a place where two replicas with the same characters hold
+// different documents.
+type Divergence struct {
+	Entry int
+	Time  time.Time
+	Path  string
+
+	A, B         *Replica
+	TextA, TextB string
+
+	// ID is the first character of A that B doesn't have at the same place
+	// or with the same value.
+	ID string
+}
+
+func (d *Divergence) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "entry %d (%s): %s and %s diverged on %q\n", d.Entry, d.Time.Format(time.StampMilli), d.A, d.B, d.Path)
+	if d.TextA == d.TextB {
+		fmt.Fprintf(&b, "same text, but not for character %s\n", d.ID)
+	} else {
+		b.WriteString(diff.Unified(d.A.String(), d.B.String(), d.TextA, d.TextB, 3))
+	}
+	return b.String()
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// Replayer applies the entries of a recording in order.
+type Replayer struct {
+	replicas map[uuid.UUID]*Replica
+	order    []*Replica
+	entries  int
+}
+
+func New() *Replayer {
+	return &Replayer{replicas: map[uuid.UUID]*Replica{}}
+}
+
+// Replicas returns every replica, in the order their clients showed up.
+func (p *Replayer) Replicas() []*Replica {
+	return p.order
+}
+
+// Step applies an entry and checks the documents it changed. It returns
+// the divergence it found, if any.
+func (p *Replayer) Step(e commons.RecordEntry) (*Divergence, error) {
+	changed, err := p.Apply(e)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range changed {
+		if d := p.Check(path); d != nil {
+			d.Entry, d.Time = p.entries-1, e.Time
+			return d, nil
+		}
+	}
+	return nil, nil
+}
+
+// Apply applies an entry to the replica of its client and returns the
+// paths of the documents it changed.
+func (p *Replayer) Apply(e commons.RecordEntry) ([]string, error) {
+	p.entries++
+
+	r, ok := p.replicas[e.Client]
+	if !ok {
+		// clients connected before the recording started show up with
+		// their first message
+		r = &Replica{Client: e.Client, Site: e.Site, Live: true, docs: map[string]*crdt.Document{}, pending: map[string]*commons.Pending{}}
+		p.replicas[e.Client] = r
+		p.order = append(p.order, r)
+	}
+
+	switch e.Dir {
+	case commons.RecordConnect:
+		r.Live = true
+		return nil, nil
+	case commons.RecordDisconnect:
+		r.Live = false
+		return nil, nil
+	}
+	if e.Message == nil {
+		return nil, nil
+	}
+	msg := *e.Message
+
+	switch msg.Type {
+	case commons.JoinMessage:
+		if e.Dir == commons.RecordIn {
+			r.Username = msg.Username
+		}
+
+	case "operation":
+		return r.integrate(msg.Username, msg.Path, []commons.Operation{msg.Operation})
+
+	case commons.BatchMessage:
+		return r.integrate(msg.Username, msg.Path, msg.Operations)
+
+	case commons.DocSyncMessage:
+		// the documents a client sends show what it started with, the ones
+		// it gets replace its own
+		if _, ok := r.docs[msg.Path]; ok && e.Dir == commons.RecordIn {
+			return nil, nil
+		}
+		d := crdt.Copy(msg.Document)
+		r.docs[msg.Path] = &d
+		if waiting, ok := r.pending[msg.Path]; ok {
+			// operations that fail are counted by Dropped, as in the client
+			_, _ = waiting.Retry(&d)
+		}
+		return []string{msg.Path}, nil
+
+	case commons.FileCreateMessage:
+		if _, ok := r.docs[msg.Path]; !ok {
+			d := crdt.New()
+			r.docs[msg.Path] = &d
+		}
+
+	case commons.FileRenameMessage:
+		if d, ok := r.docs[msg.Path]; ok {
+			delete(r.docs, msg.Path)
+			r.docs[msg.NewPath] = d
+		}
+		if waiting, ok := r.pending[msg.Path]; ok {
+			delete(r.pending, msg.Path)
+			r.pending[msg.NewPath] = waiting
+		}
+		return []string{msg.NewPath}, nil
+
+	case commons.FileDeleteMessage:
+		delete(r.docs, msg.Path)
+		delete(r.pending, msg.Path)
+	}
+	return nil, nil
+}
+
+// integrate applies operations to the document at path, the ones the
+// client sent as well as the ones it got.
+func (r *Replica) integrate(username, path string, ops []commons.Operation) ([]string, error) {
+	d, ok := r.docs[path]
+	if !ok {
+		doc := crdt.New()
+		d = &doc
+		r.docs[path] = d
+	}
+	waiting, ok := r.pending[path]
+	if !ok {
+		waiting = &commons.Pending{}
+		r.pending[path] = waiting
+	}
+
+	for _, op := range ops {
+		// operations of older clients only have a position
+		if op.ID == "" {
+			switch op.Type {
+			case "insert":
+				_, _ = d.Insert(op.Position, op.Value)
+			case "delete":
+				_ = d.Delete(op.Position)
+			}
+			continue
+		}
+
+		// operations that fail are counted by Dropped, as in the client
+		_, _ = waiting.Integrate(d, commons.RemoteOp{Username: username, Operation: op})
+	}
+	return []string{path}, nil
+}
+
+// Check compares the document at path between the live replicas that have
+// the same characters. Operations still waiting don't matter, whichever
+// replica integrates them has other characters.
+func (p *Replayer) Check(path string) *Divergence {
+	seen := map[string]*Replica{}
+	for _, r := range p.order {
+		d, ok := r.docs[path]
+		if !ok || !r.Live {
+			continue
+		}
+
+		key := characters(d)
+		other, ok := seen[key]
+		if !ok {
+			seen[key] = r
+			continue
+		}
+		if id, ok := firstDifference(*other.docs[path], *d); ok {
+			return &Divergence{Path: path, A: other, B: r, TextA: crdt.Content(*other.docs[path]), TextB: crdt.Content(*d), ID: id}
+		}
+	}
+	return nil
+}
+
+// characters returns a key for the characters of d and which of them are
+// deleted, regardless of their order.
+func characters(d *crdt.Document) string {
+	ids := make([]string, 0, len(d.Characters))
+	for _, c := range d.Characters {
+		if c.Visible {
+			ids = append(ids, c.ID)
+		} else {
+			ids = append(ids, "-"+c.ID)
+		}
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+// firstDifference returns the first character of a that b has elsewhere or
+// with another value. Both have the same characters.
+func firstDifference(a, b crdt.Document) (string, bool) {
+	for i := range a.Characters {
+		if a.Characters[i].ID != b.Characters[i].ID || a.Characters[i].Value != b.Characters[i].Value {
+			return a.Characters[i].ID, true
+		}
+	}
+	return "", false
+}
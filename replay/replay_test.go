@@ -0,0 +1,194 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/server/hub"
+)
+
+// lockedBuffer is written by the server and read by the test.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) entries(t *testing.T) []commons.RecordEntry {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entries, err := commons.ReadRecording(bytes.NewReader(b.buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// record runs a session of three users typing at once and returns its
+// recording along with the text they ended with.
+func record(t *testing.T) ([]commons.RecordEntry, string) {
+	var rec lockedBuffer
+	h := hub.New()
+	h.Record(commons.NewRecorder(&rec))
+	srv := httptest.NewServer(h)
+	defer func() {
+		srv.Close()
+		h.Close()
+	}()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	var sessions []*session.Session
+	for i := 0; i < 3; i++ {
+		s, err := session.Connect(url, session.Config{Name: fmt.Sprintf("user%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		sessions = append(sessions, s)
+	}
+	for _, s := range sessions[1:] {
+		<-s.Synced()
+	}
+
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s *session.Session) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < 50; j++ {
+				length := utf8.RuneCountInString(s.Content())
+				if length > 0 && r.Intn(4) == 0 {
+					_ = s.Delete(r.Intn(length), 1)
+				} else {
+					_ = s.Insert(r.Intn(length+1), string(rune('a'+i)))
+				}
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		a, b, c := sessions[0].Content(), sessions[1].Content(), sessions[2].Content()
+		if a == b && b == c {
+			return rec.entries(t), a
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions didn't converge: %q %q %q", a, b, c)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	entries, text := record(t)
+
+	p := New()
+	for _, e := range entries {
+		d, err := p.Step(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != nil {
+			t.Fatalf("replaying a good session diverged:\n%s", d)
+		}
+	}
+
+	if len(p.Replicas()) != 3 {
+		t.Fatalf("got %d replicas, want 3", len(p.Replicas()))
+	}
+	for _, r := range p.Replicas() {
+		if got, _ := r.Content(""); got != text || r.Waiting("") > 0 {
+			t.Fatalf("%s ended with %q and %d waiting, the session with %q", r, got, r.Waiting(""), text)
+		}
+	}
+}
+
+func TestReplayFindsDivergence(t *testing.T) {
+	entries, _ := record(t)
+
+	// the server hands a wrong character to one client
+	var tampered int
+	var id string
+	for i, e := range entries {
+		if e.Dir == commons.RecordOut && e.Message != nil && e.Message.Type == "operation" && e.Message.Operation.Type == "insert" {
+			e.Message.Operation.Value = "#"
+			tampered, id = i, e.Message.Operation.ID
+			break
+		}
+	}
+
+	p := New()
+	for _, e := range entries {
+		d, err := p.Step(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != nil {
+			// the character may be deleted by then, leaving the same text
+			if d.Entry < tampered || d.ID != id {
+				t.Fatalf("divergence at %d, tampered with %d:\n%s", d.Entry, tampered, d)
+			}
+			return
+		}
+	}
+	t.Fatal("the divergence wasn't found")
+}
+
+// A delete that can never be integrated is dropped and doesn't keep the
+// replica out of the comparison.
+func TestReplayDropsMalformed(t *testing.T) {
+	entries, _ := record(t)
+
+	var tampered int
+	var id string
+	for i, e := range entries {
+		if e.Dir == commons.RecordOut && e.Message != nil && e.Message.Type == "operation" && e.Message.Operation.Type == "insert" {
+			bad := *e.Message
+			bad.Operation = commons.Operation{Type: "delete", ID: "-1"}
+			entries = slices.Insert(entries, i, commons.RecordEntry{Time: e.Time, Client: e.Client, Site: e.Site, Dir: e.Dir, Message: &bad})
+
+			entries[i+1].Message.Operation.Value = "#"
+			tampered, id = i+1, entries[i+1].Message.Operation.ID
+			break
+		}
+	}
+
+	p := New()
+	for _, e := range entries {
+		d, err := p.Step(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d != nil {
+			if d.Entry < tampered || d.ID != id {
+				t.Fatalf("divergence at %d, tampered with %d:\n%s", d.Entry, tampered, d)
+			}
+			dropped := 0
+			for _, r := range p.Replicas() {
+				dropped += r.Dropped("")
+			}
+			if dropped != 1 {
+				t.Fatalf("%d operations dropped, want 1", dropped)
+			}
+			return
+		}
+	}
+	t.Fatal("the divergence wasn't found")
+}
@@ -0,0 +1,228 @@
+package hub
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+)
+
+// RoomInfo describes a room for the admin API.
+type RoomInfo struct {
+	Name      string         `json:"name"`
+	Clients   []ClientInfo   `json:"clients"`
+	Documents []DocumentInfo `json:"documents"`
+}
+
+// ClientInfo describes a connected client for the admin API.
+type ClientInfo struct {
+	ID       uuid.UUID `json:"id"`
+	Site     string    `json:"site"`
+	Username string    `json:"username"`
+	Addr     string    `json:"addr"`
+}
+
+// DocumentInfo describes a document of a room for the admin API.
+type DocumentInfo struct {
+	Path       string `json:"path"`
+	Runes      int    `json:"runes"`
+	Tombstones int    `json:"tombstones"`
+}
+
+// Admin returns the handler of the admin API, answering the requests that
+// carry token as their bearer token:
+//
+//	GET    /admin/rooms                          rooms with their clients and documents
+//	GET    /admin/document?room=&path=           text of a document
+//	POST   /admin/snapshots?room=&path=[&name=]  snapshot of a document, named after the time by default
+//	DELETE /admin/clients/{id}                   disconnect a client
+//	DELETE /admin/rooms?room=                    disconnect everyone and drop the room
+//
+// The room at "/" has the empty name. Without a token every request is
+// refused.
+func (rs *Rooms) Admin(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/rooms", rs.adminRooms)
+	mux.HandleFunc("GET /admin/document", rs.adminDocument)
+	mux.HandleFunc("POST /admin/snapshots", rs.adminSnapshot)
+	mux.HandleFunc("DELETE /admin/clients/{id}", rs.adminKick)
+	mux.HandleFunc("DELETE /admin/rooms", rs.adminShutdown)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (rs *Rooms) adminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := rs.list()
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	infos := make([]RoomInfo, 0, len(names))
+	for _, name := range names {
+		s := rooms[name]
+		info := RoomInfo{Name: name, Clients: s.clientInfos(), Documents: []DocumentInfo{}}
+		for _, size := range s.documentSizes() {
+			info.Documents = append(info.Documents, DocumentInfo{Path: size.path, Runes: size.runes, Tombstones: size.tombstones})
+		}
+		infos = append(infos, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(infos)
+}
+
+func (rs *Rooms) adminDocument(w http.ResponseWriter, r *http.Request) {
+	s, ok := rs.find(w, r)
+	if !ok {
+		return
+	}
+	text, ok := s.content(r.URL.Query().Get("path"))
+	if !ok {
+		http.Error(w, "no such document", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(text))
+}
+
+func (rs *Rooms) adminSnapshot(w http.ResponseWriter, r *http.Request) {
+	s, ok := rs.find(w, r)
+	if !ok {
+		return
+	}
+	path, name := r.URL.Query().Get("path"), r.URL.Query().Get("name")
+	if name == "" {
+		name = time.Now().Format(time.DateTime)
+	}
+
+	if !s.forceSnapshot(path, name) {
+		http.Error(w, "no such document", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (rs *Rooms) adminKick(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "bad client ID", http.StatusBadRequest)
+		return
+	}
+
+	for name, s := range rs.list() {
+		if client := <-s.clients.get(id); client != nil {
+			color.Red("admin >> kicking %s from room %q", id, name)
+			s.clients.delete(id)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	http.Error(w, "no such client", http.StatusNotFound)
+}
+
+func (rs *Rooms) adminShutdown(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("room")
+
+	rs.mu.Lock()
+	s, ok := rs.rooms[name]
+	delete(rs.rooms, name)
+	delete(rs.users, name)
+	rs.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "no such room", http.StatusNotFound)
+		return
+	}
+	color.Red("admin >> shutting down room %q", name)
+	s.Close()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// find returns the room of the request, answering it if there's none.
+func (rs *Rooms) find(w http.ResponseWriter, r *http.Request) (*Server, bool) {
+	rs.mu.Lock()
+	s, ok := rs.rooms[r.URL.Query().Get("room")]
+	rs.mu.Unlock()
+
+	if !ok {
+		http.Error(w, "no such room", http.StatusNotFound)
+	}
+	return s, ok
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// clientInfos returns the connected clients, by site.
+func (s *Server) clientInfos() []ClientInfo {
+	infos := []ClientInfo{}
+	for client := range s.clients.getAll() {
+		client.mu.Lock()
+		infos = append(infos, ClientInfo{ID: client.id, Site: client.SiteID, Username: client.Username, Addr: client.Conn.RemoteAddr().String()})
+		client.mu.Unlock()
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		a, _ := strconv.Atoi(infos[i].Site)
+		b, _ := strconv.Atoi(infos[j].Site)
+		return a < b
+	})
+	return infos
+}
+
+// content returns the text of the document at path, false if the session
+// doesn't have it.
+func (s *Server) content(path string) (string, bool) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+
+	d, ok := s.docs[path]
+	if !ok {
+		return "", false
+	}
+	return crdt.Content(*d), true
+}
+
+// forceSnapshot hands a snapshot of the document at path to handleMsg, as
+// if a client took it. It returns false if the session doesn't have the
+// document.
+func (s *Server) forceSnapshot(path, name string) bool {
+	s.docsMu.Lock()
+	d, ok := s.docs[path]
+	var doc crdt.Document
+	if ok {
+		doc = crdt.Copy(*d)
+	}
+	s.docsMu.Unlock()
+	if !ok {
+		return false
+	}
+
+	msg := commons.Message{Type: commons.SnapshotMessage, Username: "admin", Text: name, Path: path, Document: doc}
+	select {
+	case s.messageChan <- incoming{msg, time.Now()}:
+		return true
+	case <-s.done:
+		return false
+	}
+}
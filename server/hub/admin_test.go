@@ -0,0 +1,184 @@
+package hub_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/crdt"
+	"diploma/server/hub"
+)
+
+const token = "secret"
+
+// startAdmin serves rooms and their admin API on a local listener.
+func startAdmin(t *testing.T) (string, string) {
+	t.Helper()
+	rooms := hub.NewRooms()
+	mux := http.NewServeMux()
+	mux.Handle("/", rooms)
+	mux.Handle("/admin/", rooms.Admin(token))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		rooms.Close()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), srv.URL + "/admin"
+}
+
+// admin makes a request to the admin API and returns the status and body
+// of the response.
+func admin(t *testing.T, method, url, auth string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", "Bearer "+auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// rooms waits for the rooms of the admin API to satisfy ok and returns
+// them.
+func rooms(t *testing.T, url string, ok func([]hub.RoomInfo) bool) []hub.RoomInfo {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		status, body := admin(t, "GET", url+"/rooms", token)
+		if status != http.StatusOK {
+			t.Fatalf("listing the rooms: %d %s", status, body)
+		}
+		var infos []hub.RoomInfo
+		if err := json.Unmarshal([]byte(body), &infos); err != nil {
+			t.Fatal(err)
+		}
+		if ok(infos) {
+			return infos
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected rooms: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// receive waits for a message of type typ.
+func receive(t *testing.T, conn *session.Conn, typ commons.MessageType) commons.Message {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case msg, ok := <-conn.Messages():
+			if !ok {
+				t.Fatalf("connection closed waiting for %s", typ)
+			}
+			if msg.Type == typ {
+				return msg
+			}
+		case <-timeout:
+			t.Fatalf("no %s", typ)
+		}
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	url, api := startAdmin(t)
+	sessions := connect(t, url+"/team", 2)
+	<-sessions[1].Synced()
+
+	for _, auth := range []string{"", "wrong"} {
+		if status, _ := admin(t, "GET", api+"/rooms", auth); status != http.StatusUnauthorized {
+			t.Fatalf("token %q got %d, want %d", auth, status, http.StatusUnauthorized)
+		}
+	}
+
+	if err := sessions[0].Append("hello"); err != nil {
+		t.Fatal(err)
+	}
+	converge(t, sessions)
+
+	infos := rooms(t, api, func(infos []hub.RoomInfo) bool {
+		return len(infos) == 1 && len(infos[0].Clients) == 2 && infos[0].Clients[1].Username == "user1"
+	})
+	if infos[0].Name != "team" || infos[0].Clients[0].Username != "user0" {
+		t.Fatalf("unexpected rooms: %+v", infos)
+	}
+
+	if status, body := admin(t, "GET", api+"/document?room=team", token); status != http.StatusOK || body != "hello" {
+		t.Fatalf("document: %d %q", status, body)
+	}
+	if status, _ := admin(t, "GET", api+"/document?room=team&path=missing.txt", token); status != http.StatusNotFound {
+		t.Fatalf("missing document: %d", status)
+	}
+
+	// the snapshot has the comments too
+	raw, err := session.Dial(url+"/team", "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	comment := crdt.Comment{ID: "c1", Text: "nice", Author: "raw"}
+	if err := raw.Send(commons.Message{Username: "raw", Type: commons.CommentMessage, Comment: &comment}); err != nil {
+		t.Fatal(err)
+	}
+	if err := raw.Send(commons.Message{Type: commons.HistoryReqMessage}); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, raw, commons.HistoryMessage)
+
+	if status, body := admin(t, "POST", api+"/snapshots?room=team&name=backup", token); status != http.StatusCreated {
+		t.Fatalf("snapshot: %d %s", status, body)
+	}
+	if err := raw.Send(commons.Message{Type: commons.SnapshotsReqMessage}); err != nil {
+		t.Fatal(err)
+	}
+	snaps := receive(t, raw, commons.SnapshotsMessage).Snapshots
+	if len(snaps) != 1 || crdt.Content(snaps[0].Document) != "hello" || len(snaps[0].Document.Comments) != 1 || snaps[0].Document.Comments[0].Text != "nice" {
+		t.Fatalf("snapshots: %+v", snaps)
+	}
+	raw.Close()
+
+	// kicking
+	if status, body := admin(t, "DELETE", api+"/clients/"+infos[0].Clients[0].ID.String(), token); status != http.StatusNoContent {
+		t.Fatalf("kick: %d %s", status, body)
+	}
+	select {
+	case <-sessions[0].Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the kicked client is still connected")
+	}
+	rooms(t, api, func(infos []hub.RoomInfo) bool {
+		return len(infos) == 1 && len(infos[0].Clients) == 1
+	})
+
+	// shutting down
+	if status, body := admin(t, "DELETE", api+"/rooms?room=team", token); status != http.StatusNoContent {
+		t.Fatalf("shutdown: %d %s", status, body)
+	}
+	select {
+	case <-sessions[1].Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("the room's client is still connected")
+	}
+	rooms(t, api, func(infos []hub.RoomInfo) bool { return len(infos) == 0 })
+	if status, _ := admin(t, "DELETE", api+"/rooms?room=team", token); status != http.StatusNotFound {
+		t.Fatalf("shutting down again: %d", status)
+	}
+}
@@ -0,0 +1,289 @@
+package hub
+
+import (
+	"sync"
+
+	"diploma/commons"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+type client struct {
+	Conn     *websocket.Conn
+	SiteID   string
+	id       uuid.UUID
+	Username string
+
+	// rec records the messages sent to the client, if set.
+	rec *commons.Recorder
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+}
+
+type Clients struct {
+	list map[uuid.UUID]*client
+
+	mu sync.RWMutex
+
+	// server gets the list of users when it changes
+	server *Server
+
+	deleteRequests     chan deleteRequest
+	readRequests       chan readRequest
+	addRequests        chan *client
+	nameUpdateRequests chan nameUpdate
+}
+
+func NewClients(server *Server) *Clients {
+	return &Clients{
+		list:               make(map[uuid.UUID]*client),
+		mu:                 sync.RWMutex{},
+		server:             server,
+		deleteRequests:     make(chan deleteRequest),
+		readRequests:       make(chan readRequest, 10000),
+		addRequests:        make(chan *client),
+		nameUpdateRequests: make(chan nameUpdate),
+	}
+}
+
+type deleteRequest struct {
+	id   uuid.UUID
+	done chan int
+}
+
+type readRequest struct {
+	readAll bool
+	id      uuid.UUID
+	resp    chan *client
+}
+
+type nameUpdate struct {
+	id      uuid.UUID
+	newName string
+}
+
+func (c *Clients) handle() {
+	for {
+		select {
+		case req := <-c.deleteRequests:
+			c.close(req.id)
+			req.done <- 1
+			close(req.done)
+
+		case req := <-c.readRequests:
+			if req.readAll {
+				for _, client := range c.list {
+					req.resp <- client
+				}
+				close(req.resp)
+			} else {
+				req.resp <- c.list[req.id]
+				close(req.resp)
+			}
+
+		case client := <-c.addRequests:
+			c.mu.Lock()
+			c.list[client.id] = client
+			c.mu.Unlock()
+
+		case n := <-c.nameUpdateRequests:
+			if client, ok := c.list[n.id]; ok {
+				client.mu.Lock()
+				client.Username = n.newName
+				client.mu.Unlock()
+			}
+
+		case <-c.server.done:
+			c.mu.Lock()
+			for id, client := range c.list {
+				_ = client.Conn.Close()
+				delete(c.list, id)
+			}
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (c *Clients) close(id uuid.UUID) {
+	c.mu.RLock()
+	client, ok := c.list[id]
+	if ok {
+		if err := client.Conn.Close(); err != nil {
+			color.Red("Error closing connection: %s\n", err)
+		}
+	} else {
+		c.mu.RUnlock()
+		color.Red("Couldn't close connection: client not in list")
+		return
+	}
+	color.Red("Removing %v from client list.\n", c.list[id].Username)
+	c.mu.RUnlock()
+
+	c.mu.Lock()
+	delete(c.list, id)
+	c.mu.Unlock()
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (c *Clients) getAll() chan *client {
+	c.mu.RLock()
+	resp := make(chan *client, len(c.list))
+	c.mu.RUnlock()
+	c.request(readRequest{readAll: true, resp: resp})
+	return resp
+}
+
+// count returns the number of connected clients.
+func (c *Clients) count() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.list)
+}
+
+func (c *Clients) get(id uuid.UUID) chan *client {
+	resp := make(chan *client, 1)
+
+	c.request(readRequest{readAll: false, id: id, resp: resp})
+	return resp
+}
+
+// request sends req to handle, answering it with nothing once the server is
+// closed.
+func (c *Clients) request(req readRequest) {
+	select {
+	case c.readRequests <- req:
+	case <-c.server.done:
+		close(req.resp)
+	}
+}
+
+func (c *Clients) add(client *client) {
+	select {
+	case c.addRequests <- client:
+	case <-c.server.done:
+	}
+}
+
+func (c *Clients) delete(id uuid.UUID) {
+	req := deleteRequest{id, make(chan int)}
+	select {
+	case c.deleteRequests <- req:
+	case <-c.server.done:
+		return
+	}
+	<-req.done
+
+	// handleSync may be the one deleting, it can't wait for itself
+	go c.sendUsernames()
+}
+
+func (c *Clients) updateName(id uuid.UUID, newName string) {
+	select {
+	case c.nameUpdateRequests <- nameUpdate{id, newName}:
+	case <-c.server.done:
+	}
+}
+
+func (c *Clients) sendUsernames() {
+	var users string
+	for client := range c.getAll() {
+		client.mu.Lock()
+		users += client.Username + ","
+		client.mu.Unlock()
+	}
+
+	c.server.mu.Lock()
+	siteNames := make(map[string]string, len(c.server.sites))
+	for site, name := range c.server.sites {
+		siteNames[site] = name
+	}
+	c.server.mu.Unlock()
+
+	c.server.sync(commons.Message{Text: users, Type: commons.UsersMessage, Sites: siteNames})
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (c *Clients) broadcastAll(msg commons.Message) {
+	color.Blue("sending message to all users. Text: %s", msg.Text)
+	for client := range c.getAll() {
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+			c.server.metrics.failedSends.Add(1)
+			c.delete(client.id)
+		}
+	}
+}
+
+func (c *Clients) broadcastAllExcept(msg commons.Message, except uuid.UUID) {
+	for client := range c.getAll() {
+		if client.id == except {
+			continue
+		}
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+			c.server.metrics.failedSends.Add(1)
+			c.delete(client.id)
+		}
+	}
+}
+
+func (c *Clients) broadcastOne(msg commons.Message, dst uuid.UUID) {
+	client := <-c.get(dst)
+	if client == nil {
+		// left in the meantime
+		return
+	}
+	if err := client.send(msg); err != nil {
+		color.Red("ERROR: %s", err)
+		c.server.metrics.failedSends.Add(1)
+		c.delete(client.id)
+	}
+}
+
+func (c *Clients) broadcastOneExcept(msg commons.Message, except uuid.UUID) {
+	for client := range c.getAll() {
+		if client.id == except {
+			continue
+		}
+		if err := client.send(msg); err != nil {
+			color.Red("ERROR: %s", err)
+			c.server.metrics.failedSends.Add(1)
+			c.delete(client.id)
+			continue
+		}
+		break
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func (c *client) read(msg *commons.Message) error {
+	err := c.Conn.ReadJSON(msg)
+
+	c.mu.Lock()
+	name := c.Username
+	c.mu.Unlock()
+
+	if err != nil {
+		if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			color.Red("Failed to read message from client %s: %v", name, err)
+		}
+		color.Red("client %v disconnected", name)
+		return err
+	}
+	return nil
+}
+
+func (c *client) send(msg commons.Message) error {
+	c.writeMu.Lock()
+	c.rec.Record(commons.RecordOut, c.id, c.SiteID, &msg)
+	err := c.Conn.WriteJSON(msg)
+	c.writeMu.Unlock()
+	return err
+}
@@ -0,0 +1,143 @@
+package hub
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/fatih/color"
+)
+
+// documentSize is the size of a document of the session.
+type documentSize struct {
+	path       string
+	runes      int
+	tombstones int
+}
+
+// integrate applies the operations of a client to the mirror of the
+// document at path.
+func (s *Server) integrate(path, username string, ops []commons.Operation) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+
+	d := s.document(path)
+	waiting, ok := s.pending[path]
+	if !ok {
+		waiting = &commons.Pending{}
+		s.pending[path] = waiting
+	}
+
+	for _, op := range ops {
+		// operations of older clients only have a position
+		if op.ID == "" {
+			switch op.Type {
+			case "insert":
+				_, _ = d.Insert(op.Position, op.Value)
+			case "delete":
+				_ = d.Delete(op.Position)
+			}
+			continue
+		}
+
+		if _, err := waiting.Integrate(d, commons.RemoteOp{Username: username, Operation: op}); err != nil {
+			color.Red("Couldn't mirror %s %s on %q: %s", op.Type, op.ID, path, err)
+		}
+	}
+}
+
+// adopt merges a document a client sent into the mirror of the one at path.
+func (s *Server) adopt(path string, doc crdt.Document) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+
+	d := s.document(path)
+	merged, err := crdt.Merge(crdt.New(), *d, doc)
+	if err != nil {
+		color.Red("Couldn't mirror the document %q: %s", path, err)
+		return
+	}
+	*d = merged
+
+	if waiting, ok := s.pending[path]; ok {
+		if _, err := waiting.Retry(d); err != nil {
+			color.Red("Couldn't mirror an operation on %q: %s", path, err)
+		}
+	}
+}
+
+// comment applies the change of a comment to the mirror.
+func (s *Server) comment(msg commons.Message) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+
+	d := s.document(msg.Path)
+	if msg.Type == commons.CommentDeleteMessage {
+		d.DeleteComment(msg.Comment.ID)
+	} else {
+		d.PutComment(*msg.Comment)
+	}
+}
+
+// file applies the creation, renaming or deletion of a document to the
+// mirror.
+func (s *Server) file(msg commons.Message) {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+
+	switch msg.Type {
+	case commons.FileCreateMessage:
+		s.document(msg.Path)
+	case commons.FileRenameMessage:
+		if msg.NewPath == msg.Path {
+			return
+		}
+		if d, ok := s.docs[msg.Path]; ok {
+			s.docs[msg.NewPath] = d
+		}
+		if waiting, ok := s.pending[msg.Path]; ok {
+			s.pending[msg.NewPath] = waiting
+		}
+		fallthrough
+	case commons.FileDeleteMessage:
+		delete(s.docs, msg.Path)
+		delete(s.pending, msg.Path)
+	}
+}
+
+// document returns the mirror of the document at path, creating it if
+// needed. docsMu must be held.
+func (s *Server) document(path string) *crdt.Document {
+	d, ok := s.docs[path]
+	if !ok {
+		doc := crdt.New()
+		d = &doc
+		s.docs[path] = d
+	}
+	return d
+}
+
+// documentSizes returns the sizes of the documents of the session, sorted
+// by path.
+func (s *Server) documentSizes() []documentSize {
+	s.docsMu.Lock()
+	defer s.docsMu.Unlock()
+
+	sizes := make([]documentSize, 0, len(s.docs))
+	for path, d := range s.docs {
+		size := documentSize{path: path}
+		for _, c := range d.Characters {
+			switch {
+			case c.Visible:
+				size.runes += utf8.RuneCountInString(c.Value)
+			case c.ID != crdt.CharacterStart.ID && c.ID != crdt.CharacterEnd.ID:
+				size.tombstones++
+			}
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].path < sizes[j].path })
+	return sizes
+}
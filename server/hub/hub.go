@@ -0,0 +1,345 @@
+// Package hub is the collaboration server: it hands out site IDs, relays
+// operations between the clients of a session and keeps its chat, history
+// and snapshots.
+package hub
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"diploma/commons"
+	"diploma/crdt"
+
+	"github.com/fatih/color"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// maxChatHistory limits the chat kept for late joiners.
+	maxChatHistory = 1000
+
+	// maxHistory limits the edits kept per document.
+	maxHistory = 100000
+)
+
+// Server serves one session over WebSocket connections.
+type Server struct {
+	// room is the name of the session, empty for the server's only one.
+	room string
+
+	siteID int
+	mu     sync.Mutex
+
+	// sites maps the site IDs handed out so far to the names of their
+	// users, so that text keeps its author after the user left.
+	sites map[string]string
+
+	upgrader websocket.Upgrader
+
+	messageChan chan incoming
+	syncChan    chan commons.Message
+
+	clients *Clients
+
+	// chatHistory is the chat of the session, sent to users when they join.
+	// It's only used by handleMsg.
+	chatHistory []commons.ChatEntry
+
+	// history holds the edits of every document by path. It's only used by
+	// handleMsg.
+	history map[string][]commons.HistoryEntry
+
+	// snapshots holds the named snapshots of every document by path. It's
+	// only used by handleMsg.
+	snapshots map[string][]commons.Snapshot
+
+	// docs mirrors the documents of the session by path, from the
+	// operations and documents going through the server. pending holds the
+	// operations that came before the characters they need.
+	docsMu  sync.Mutex
+	docs    map[string]*crdt.Document
+	pending map[string]*commons.Pending
+
+	// rec records the messages of the session, if set.
+	rec *commons.Recorder
+
+	metrics *metrics
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// incoming is a message read from a client, with the time it was read.
+type incoming struct {
+	msg commons.Message
+	at  time.Time
+}
+
+// New returns a server that is ready to handle connections.
+func New() *Server {
+	return newServer("", newMetrics())
+}
+
+func newServer(room string, m *metrics) *Server {
+	s := &Server{
+		room:        room,
+		sites:       map[string]string{},
+		messageChan: make(chan incoming),
+		syncChan:    make(chan commons.Message),
+		history:     map[string][]commons.HistoryEntry{},
+		snapshots:   map[string][]commons.Snapshot{},
+		docs:        map[string]*crdt.Document{},
+		pending:     map[string]*commons.Pending{},
+		metrics:     m,
+		done:        make(chan struct{}),
+	}
+	s.clients = NewClients(s)
+
+	go s.clients.handle()
+	go s.handleMsg()
+	go s.handleSync()
+
+	return s
+}
+
+// Close disconnects every client and stops the server.
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// Record makes the server record every message it receives and sends to
+// rec, from the clients that connect next.
+func (s *Server) Record(rec *commons.Recorder) {
+	s.mu.Lock()
+	s.rec = rec
+	s.mu.Unlock()
+}
+
+// Metrics returns the handler serving the metrics of the server.
+func (s *Server) Metrics() http.Handler {
+	return metricsHandler(s.metrics, func() map[string]*Server {
+		return map[string]*Server{s.room: s}
+	})
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// ServeHTTP upgrades the request to a WebSocket and serves the client until
+// it leaves.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		color.Red("Error upgrading connection to websocket: %v\n", err)
+		return
+	}
+	defer conn.Close()
+
+	clientID := uuid.New()
+
+	// assign uuid
+	s.mu.Lock()
+	s.siteID++
+	client := &client{
+		Conn:    conn,
+		SiteID:  strconv.Itoa(s.siteID),
+		id:      clientID,
+		rec:     s.rec,
+		writeMu: sync.Mutex{},
+		mu:      sync.Mutex{},
+	}
+	s.mu.Unlock()
+	client.rec.Record(commons.RecordConnect, clientID, client.SiteID, nil)
+
+	// add new user to server's clients list
+	s.clients.add(client)
+
+	// send client his unique ID
+	siteIDMsg := commons.Message{
+		Type: commons.SiteIDMessage,
+		Text: client.SiteID,
+		ID:   clientID}
+	s.clients.broadcastOne(siteIDMsg, clientID)
+
+	// ask other users to provide document
+	docReq := commons.Message{
+		Type: commons.DocReqMessage,
+		ID:   clientID}
+	s.clients.broadcastOneExcept(docReq, clientID)
+
+	// send new list of users
+	s.clients.sendUsernames()
+
+	for {
+		var msg commons.Message
+
+		// read message
+		if err := client.read(&msg); err != nil {
+			color.Red("Failed to read message. closing client connection with %s. Error: %s", client.Username, err)
+			client.rec.Record(commons.RecordDisconnect, clientID, client.SiteID, nil)
+			s.clients.delete(clientID)
+			return
+		}
+		at := time.Now()
+		client.rec.Record(commons.RecordIn, clientID, client.SiteID, &msg)
+		s.metrics.message(msg)
+
+		// sync message
+		if msg.Type == commons.DocSyncMessage {
+			s.sync(msg)
+			continue
+		}
+
+		// join or operation message
+		msg.ID = clientID
+		select {
+		case s.messageChan <- incoming{msg, at}:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sync hands msg to handleSync.
+func (s *Server) sync(msg commons.Message) {
+	select {
+	case s.syncChan <- msg:
+	case <-s.done:
+	}
+}
+
+func (s *Server) handleMsg() {
+	clients := s.clients
+	for {
+		var in incoming
+		select {
+		case in = <-s.messageChan:
+		case <-s.done:
+			return
+		}
+		msg := in.msg
+
+		// get time and log message to server's stdout
+		t := time.Now().Format(time.ANSIC)
+		if msg.Type == commons.JoinMessage {
+			clients.updateName(msg.ID, msg.Username)
+			if client := <-clients.get(msg.ID); client != nil {
+				s.mu.Lock()
+				s.sites[client.SiteID] = msg.Username
+				s.mu.Unlock()
+			}
+			color.Green("%s >> %s %s (ID: %s)\n", t, msg.Username, msg.Text, msg.ID)
+			clients.sendUsernames()
+			if len(s.chatHistory) > 0 {
+				clients.broadcastOne(commons.Message{Type: commons.ChatHistoryMessage, Chat: s.chatHistory}, msg.ID)
+			}
+		} else if msg.Type == commons.ChatMessage {
+			color.Green("%s >> chat from %s: %s\n", t, msg.Username, msg.Text)
+
+			entry := commons.ChatEntry{Username: msg.Username, Text: msg.Text, Time: time.Now()}
+			s.chatHistory = append(s.chatHistory, entry)
+			if len(s.chatHistory) > maxChatHistory {
+				s.chatHistory = s.chatHistory[len(s.chatHistory)-maxChatHistory:]
+			}
+
+			// the sender gets its message back with the server's time
+			clients.broadcastAll(commons.Message{Type: commons.ChatMessage, Username: msg.Username, Chat: []commons.ChatEntry{entry}})
+			continue
+		} else if msg.Type == commons.HistoryReqMessage {
+			color.Green("%s >> history of %q requested by ID=%s\n", t, msg.Path, msg.ID)
+			reply := commons.Message{Type: commons.HistoryMessage, Path: msg.Path, History: s.history[msg.Path]}
+			clients.broadcastOne(reply, msg.ID)
+			continue
+		} else if msg.Type == commons.SnapshotMessage {
+			color.Green("%s >> snapshot %q of %q by %s\n", t, msg.Text, msg.Path, msg.Username)
+			s.snapshot(msg.Path, commons.Snapshot{Name: msg.Text, Username: msg.Username, Time: time.Now(), Document: msg.Document})
+
+			// others only get told about it
+			clients.broadcastAllExcept(commons.Message{Type: commons.SnapshotMessage, Username: msg.Username, Text: msg.Text, Path: msg.Path}, msg.ID)
+			continue
+		} else if msg.Type == commons.SnapshotsReqMessage {
+			color.Green("%s >> snapshots of %q requested by ID=%s\n", t, msg.Path, msg.ID)
+			reply := commons.Message{Type: commons.SnapshotsMessage, Path: msg.Path, Snapshots: s.snapshots[msg.Path]}
+			clients.broadcastOne(reply, msg.ID)
+			continue
+		} else if msg.Type == "operation" {
+			color.Green("operation >> %+v from ID=%s\n", msg.Operation, msg.ID)
+			s.record(msg.Path, msg.Username, []commons.Operation{msg.Operation})
+			s.integrate(msg.Path, msg.Username, []commons.Operation{msg.Operation})
+		} else if msg.Type == commons.BatchMessage {
+			color.Green("batch >> %d operations from ID=%s\n", len(msg.Operations), msg.ID)
+			s.record(msg.Path, msg.Username, msg.Operations)
+			s.integrate(msg.Path, msg.Username, msg.Operations)
+		} else if msg.Type == commons.FileCreateMessage || msg.Type == commons.FileRenameMessage || msg.Type == commons.FileDeleteMessage {
+			color.Green("%s >> %s %s %s from ID=%s\n", t, msg.Type, msg.Path, msg.NewPath, msg.ID)
+			if msg.Type == commons.FileRenameMessage {
+				s.history[msg.NewPath] = s.history[msg.Path]
+				s.snapshots[msg.NewPath] = s.snapshots[msg.Path]
+			}
+			if msg.Type != commons.FileCreateMessage {
+				delete(s.history, msg.Path)
+				delete(s.snapshots, msg.Path)
+			}
+			s.file(msg)
+		} else if (msg.Type == commons.CommentMessage || msg.Type == commons.CommentDeleteMessage) && msg.Comment != nil {
+			color.Green("%s >> %s on %s by %s: %s\n", t, msg.Type, msg.Path, msg.Username, msg.Comment.Text)
+			s.comment(msg)
+		} else {
+			color.Green("%s >> unknown message type:  %v\n", t, msg)
+			clients.sendUsernames()
+			continue
+		}
+
+		clients.broadcastAllExcept(msg, msg.ID)
+		if msg.Type == "operation" || msg.Type == commons.BatchMessage {
+			s.metrics.broadcast.observe(time.Since(in.at))
+		}
+	}
+}
+
+// record adds an edit to the history of the document at path.
+func (s *Server) record(path, username string, ops []commons.Operation) {
+	entries := append(s.history[path], commons.HistoryEntry{Time: time.Now(), Username: username, Operations: ops})
+	if len(entries) > maxHistory {
+		entries = entries[len(entries)-maxHistory:]
+	}
+	s.history[path] = entries
+}
+
+// snapshot adds a snapshot of the document at path, replacing the one with
+// the same name.
+func (s *Server) snapshot(path string, snap commons.Snapshot) {
+	list := s.snapshots[path]
+	for i := range list {
+		if list[i].Name == snap.Name {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	s.snapshots[path] = append(list, snap)
+}
+
+func (s *Server) handleSync() {
+	for {
+		var syncMsg commons.Message
+		select {
+		case syncMsg = <-s.syncChan:
+		case <-s.done:
+			return
+		}
+
+		switch syncMsg.Type {
+		case commons.DocSyncMessage:
+			s.adopt(syncMsg.Path, syncMsg.Document)
+			s.clients.broadcastOne(syncMsg, syncMsg.ID)
+
+		case commons.UsersMessage:
+			color.Blue("usernames: %s", syncMsg.Text)
+			s.clients.broadcastAll(syncMsg)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package hub_test
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"diploma/client/session"
+	"diploma/commons"
+	"diploma/server/hub"
+)
+
+// start serves a new hub on a local listener.
+func start(t *testing.T) string {
+	t.Helper()
+	h := hub.New()
+	srv := httptest.NewServer(h)
+	t.Cleanup(func() {
+		srv.Close()
+		h.Close()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func connect(t *testing.T, url string, n int) []*session.Session {
+	t.Helper()
+	var sessions []*session.Session
+	for i := 0; i < n; i++ {
+		s, err := session.Connect(url, session.Config{Name: fmt.Sprintf("user%d", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// converge waits for every session to have the same text and returns it.
+func converge(t *testing.T, sessions []*session.Session) string {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		contents := make([]string, len(sessions))
+		same := true
+		for i, s := range sessions {
+			contents[i] = s.Content()
+			same = same && contents[i] == contents[0]
+		}
+		if same {
+			return contents[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("sessions didn't converge: %q", contents)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+func TestConcurrentTyping(t *testing.T) {
+	url := start(t)
+	sessions := connect(t, url, 4)
+
+	const perSession = 100
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s *session.Session) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < perSession; j++ {
+				pos := r.Intn(utf8.RuneCountInString(s.Content()) + 1)
+				if err := s.Insert(pos, string(rune('a'+i))); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	text := converge(t, sessions)
+	if len(text) != len(sessions)*perSession {
+		t.Fatalf("got %d characters, want %d", len(text), len(sessions)*perSession)
+	}
+	for i := range sessions {
+		if n := strings.Count(text, string(rune('a'+i))); n != perSession {
+			t.Fatalf("user%d typed %d characters, %d made it", i, perSession, n)
+		}
+	}
+}
+
+func TestConcurrentEdits(t *testing.T) {
+	url := start(t)
+	sessions := connect(t, url, 3)
+
+	if err := sessions[0].Insert(0, "the quick brown fox jumps over the lazy dog"); err != nil {
+		t.Fatal(err)
+	}
+	converge(t, sessions)
+
+	var wg sync.WaitGroup
+	for i, s := range sessions {
+		wg.Add(1)
+		go func(i int, s *session.Session) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(i)))
+			for j := 0; j < 200; j++ {
+				length := utf8.RuneCountInString(s.Content())
+				var err error
+				if length > 0 && r.Intn(3) == 0 {
+					err = s.Delete(r.Intn(length), 1)
+				} else {
+					err = s.Insert(r.Intn(length+1), string([]rune("xyzé\n")[r.Intn(5)]))
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i, s)
+	}
+	wg.Wait()
+
+	converge(t, sessions)
+}
+
+func TestLateJoiner(t *testing.T) {
+	url := start(t)
+	sessions := connect(t, url, 2)
+
+	if err := sessions[0].Insert(0, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	converge(t, sessions)
+	if err := sessions[1].Insert(0, "well, "); err != nil {
+		t.Fatal(err)
+	}
+	text := converge(t, sessions)
+
+	// the newcomer gets the document from one of the others
+	sessions = append(sessions, connect(t, url, 1)...)
+	if got := converge(t, sessions); got != text {
+		t.Fatalf("late joiner got %q, want %q", got, text)
+	}
+
+	if err := sessions[2].Insert(len(text), " world"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions[0].Delete(0, len("well, ")); err != nil {
+		t.Fatal(err)
+	}
+	if got := converge(t, sessions); got != "hello world" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	url := start(t)
+	sessions := connect(t, url, 3)
+
+	if err := sessions[1].Insert(0, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	converge(t, sessions)
+
+	// the others keep working once one leaves
+	if err := sessions[1].Close(); err != nil {
+		t.Fatal(err)
+	}
+	sessions = []*session.Session{sessions[0], sessions[2]}
+	if err := sessions[0].Insert(3, "d"); err != nil {
+		t.Fatal(err)
+	}
+	if got := converge(t, sessions); got != "abcd" {
+		t.Fatalf("got %q, want %q", got, "abcd")
+	}
+}
+
+func TestRemoteOps(t *testing.T) {
+	url := start(t)
+	sessions := connect(t, url, 2)
+
+	ops := make(chan commons.RemoteOp, 10)
+	sessions[1].OnRemoteOp(func(op commons.RemoteOp) { ops <- op })
+
+	// what's typed before the document arrives comes with it instead
+	<-sessions[1].Synced()
+	if err := sessions[0].Append("hi"); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range "hi" {
+		select {
+		case op := <-ops:
+			if op.Username != "user0" || op.Value != string(want) || op.Position != i+1 {
+				t.Fatalf("got %+v", op)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no remote operation")
+		}
+	}
+
+	// the newcomer waits for the document before appending to it
+	late, err := session.Connect(url, session.Config{Name: "late"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer late.Close()
+	select {
+	case <-late.Synced():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no document sent to the newcomer")
+	}
+	if err := late.Append("!"); err != nil {
+		t.Fatal(err)
+	}
+	if got := converge(t, append(sessions, late)); got != "hi!" {
+		t.Fatalf("got %q, want %q", got, "hi!")
+	}
+}
+
+// An operation that can never be integrated is dropped, it doesn't end the
+// session nor hold up the ones after it.
+func TestMalformedOperation(t *testing.T) {
+	url := start(t)
+	sessions := connect(t, url, 1)
+
+	conn, err := session.Dial(url, "raw")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	bad := commons.Message{Username: "raw", Type: "operation", Operation: commons.Operation{Type: "delete", ID: "-1"}}
+	if err := conn.Send(bad); err != nil {
+		t.Fatal(err)
+	}
+	if err := sessions[0].Append("ok"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the raw connection gets the append once it's joined
+	for msg := range conn.Messages() {
+		if msg.Type == "operation" || msg.Type == commons.BatchMessage {
+			break
+		}
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for sessions[0].Dropped() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the malformed operation wasn't dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := sessions[0].Content(); got != "ok" {
+		t.Fatalf("got %q", got)
+	}
+	select {
+	case <-sessions[0].Done():
+		t.Fatal("the session ended")
+	default:
+	}
+}
@@ -0,0 +1,160 @@
+package hub
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"diploma/commons"
+)
+
+// metrics are counted by every room of a server and written in the
+// Prometheus text format.
+type metrics struct {
+	mu         sync.Mutex
+	messages   map[commons.MessageType]int64 // by message type
+	operations map[string]int64              // by operation type
+
+	failedSends atomic.Int64
+
+	// broadcast is the time from reading an edit to having sent it to
+	// everyone else, waiting in messageChan included.
+	broadcast *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		messages:   map[commons.MessageType]int64{},
+		operations: map[string]int64{},
+		broadcast:  newHistogram(0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10),
+	}
+}
+
+func (m *metrics) message(msg commons.Message) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages[msg.Type]++
+	switch msg.Type {
+	case "operation":
+		m.operations[msg.Operation.Type]++
+	case commons.BatchMessage:
+		for _, op := range msg.Operations {
+			m.operations[op.Type]++
+		}
+	}
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []int64 // counts[i] are the values up to bounds[i], the last one above them all
+	sum    float64
+	count  int64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]int64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	v := d.Seconds()
+	i := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// writeMetrics writes the metrics of the rooms by name.
+func writeMetrics(w io.Writer, m *metrics, rooms map[string]*Server) {
+	names := make([]string, 0, len(rooms))
+	for name := range rooms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	header(w, "pairpad_rooms", "gauge", "Rooms with a session.")
+	fmt.Fprintf(w, "pairpad_rooms %d\n", len(rooms))
+
+	header(w, "pairpad_clients", "gauge", "Connected clients.")
+	for _, name := range names {
+		fmt.Fprintf(w, "pairpad_clients{room=%s} %d\n", label(name), rooms[name].clients.count())
+	}
+
+	m.mu.Lock()
+	header(w, "pairpad_messages_total", "counter", "Messages received from clients, by type.")
+	for _, t := range sortedKeys(m.messages) {
+		fmt.Fprintf(w, "pairpad_messages_total{type=%s} %d\n", label(string(t)), m.messages[t])
+	}
+	header(w, "pairpad_operations_total", "counter", "Edit operations received from clients, by type.")
+	for _, t := range sortedKeys(m.operations) {
+		fmt.Fprintf(w, "pairpad_operations_total{type=%s} %d\n", label(t), m.operations[t])
+	}
+	m.mu.Unlock()
+
+	header(w, "pairpad_failed_sends_total", "counter", "Messages that couldn't be sent, dropping the client.")
+	fmt.Fprintf(w, "pairpad_failed_sends_total %d\n", m.failedSends.Load())
+
+	h := m.broadcast
+	h.mu.Lock()
+	header(w, "pairpad_broadcast_latency_seconds", "histogram", "Time from reading an edit to having sent it to the other clients.")
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "pairpad_broadcast_latency_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "pairpad_broadcast_latency_seconds_bucket{le=\"+Inf\"} %d\n", h.count)
+	fmt.Fprintf(w, "pairpad_broadcast_latency_seconds_sum %g\n", h.sum)
+	fmt.Fprintf(w, "pairpad_broadcast_latency_seconds_count %d\n", h.count)
+	h.mu.Unlock()
+
+	header(w, "pairpad_document_runes", "gauge", "Visible runes of a document.")
+	var tombstones []string
+	for _, name := range names {
+		for _, size := range rooms[name].documentSizes() {
+			fmt.Fprintf(w, "pairpad_document_runes{room=%s,path=%s} %d\n", label(name), label(size.path), size.runes)
+			tombstones = append(tombstones, fmt.Sprintf("pairpad_document_tombstones{room=%s,path=%s} %d\n", label(name), label(size.path), size.tombstones))
+		}
+	}
+	header(w, "pairpad_document_tombstones", "gauge", "Deleted characters a document still holds.")
+	for _, line := range tombstones {
+		io.WriteString(w, line)
+	}
+}
+
+func header(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// label quotes a label value, escaping as the text format wants.
+func label(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+func sortedKeys[K ~string, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// metricsHandler serves the metrics of the rooms returned by list.
+func metricsHandler(m *metrics, list func() map[string]*Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, m, list())
+	})
+}
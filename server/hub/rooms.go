@@ -0,0 +1,118 @@
+package hub
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"diploma/commons"
+
+	"github.com/fatih/color"
+	"github.com/gorilla/websocket"
+)
+
+// Rooms serves a separate session for every URL path, the room's name.
+// The room at "/" has the empty name. A room starts with its first client
+// and stops once its last client left.
+type Rooms struct {
+	mu    sync.Mutex
+	rooms map[string]*Server
+	// users counts the connections of every room.
+	users map[string]int
+
+	metrics *metrics
+	rec     *commons.Recorder
+}
+
+func NewRooms() *Rooms {
+	return &Rooms{rooms: map[string]*Server{}, users: map[string]int{}, metrics: newMetrics()}
+}
+
+// Record makes the rooms record their messages to rec, each with its name.
+func (rs *Rooms) Record(rec *commons.Recorder) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rs.rec = rec
+	for name, s := range rs.rooms {
+		s.Record(rec.Room(name))
+	}
+}
+
+// Metrics returns the handler serving the metrics of every room.
+func (rs *Rooms) Metrics() http.Handler {
+	return metricsHandler(rs.metrics, rs.list)
+}
+
+// Close stops every room.
+func (rs *Rooms) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for name, s := range rs.rooms {
+		s.Close()
+		delete(rs.rooms, name)
+		delete(rs.users, name)
+	}
+	return nil
+}
+
+func (rs *Rooms) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// other requests would start rooms nobody joins
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, "expected a WebSocket connection", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.Trim(r.URL.Path, "/")
+	s := rs.join(name)
+	defer rs.leave(name, s)
+	s.ServeHTTP(w, r)
+}
+
+// join returns the room with name, starting it if needed, and counts the
+// connection.
+func (rs *Rooms) join(name string) *Server {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	s, ok := rs.rooms[name]
+	if !ok {
+		color.Green("starting room %q", name)
+		s = newServer(name, rs.metrics)
+		s.Record(rs.rec.Room(name))
+		rs.rooms[name] = s
+	}
+	rs.users[name]++
+	return s
+}
+
+// leave uncounts a connection to s, stopping it if it was the last one.
+func (rs *Rooms) leave(name string, s *Server) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	// it was shut down meanwhile
+	if rs.rooms[name] != s {
+		return
+	}
+	rs.users[name]--
+	if rs.users[name] > 0 {
+		return
+	}
+	color.Green("stopping room %q", name)
+	s.Close()
+	delete(rs.rooms, name)
+	delete(rs.users, name)
+}
+
+func (rs *Rooms) list() map[string]*Server {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	rooms := make(map[string]*Server, len(rs.rooms))
+	for name, s := range rs.rooms {
+		rooms[name] = s
+	}
+	return rooms
+}
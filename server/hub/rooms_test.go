@@ -0,0 +1,138 @@
+package hub_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"diploma/server/hub"
+)
+
+// startRooms serves rooms and their metrics on a local listener.
+func startRooms(t *testing.T) (string, string) {
+	t.Helper()
+	rooms := hub.NewRooms()
+	mux := http.NewServeMux()
+	mux.Handle("/", rooms)
+	mux.Handle("/metrics", rooms.Metrics())
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+		rooms.Close()
+	})
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), srv.URL + "/metrics"
+}
+
+// waitMetrics waits for the metrics to have every line of want.
+func waitMetrics(t *testing.T, url string, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := strings.Split(string(body), "\n")
+		missing := ""
+		for _, w := range want {
+			found := false
+			for _, line := range lines {
+				found = found || line == w
+			}
+			if !found {
+				missing = w
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics don't have %q:\n%s", missing, body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	url, metrics := startRooms(t)
+	a := connect(t, url+"/a", 2)
+	b := connect(t, url+"/b", 2)
+	<-a[1].Synced()
+	<-b[1].Synced()
+
+	if err := a[0].Append("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a[0].Delete(0, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b[0].Append("hi"); err != nil {
+		t.Fatal(err)
+	}
+
+	if text := converge(t, a); text != "ello" {
+		t.Fatalf("room a has %q, want %q", text, "ello")
+	}
+	if text := converge(t, b); text != "hi" {
+		t.Fatalf("room b has %q, want %q", text, "hi")
+	}
+
+	waitMetrics(t, metrics,
+		`pairpad_rooms 2`,
+		`pairpad_clients{room="a"} 2`,
+		`pairpad_clients{room="b"} 2`,
+		`pairpad_operations_total{type="insert"} 7`,
+		`pairpad_operations_total{type="delete"} 1`,
+		`pairpad_broadcast_latency_seconds_count 3`,
+		`pairpad_failed_sends_total 0`,
+		`pairpad_document_runes{room="a",path=""} 4`,
+		`pairpad_document_tombstones{room="a",path=""} 1`,
+		`pairpad_document_runes{room="b",path=""} 2`,
+		`pairpad_document_tombstones{room="b",path=""} 0`,
+	)
+}
+
+// Requests that aren't WebSocket connections don't start rooms, and a room
+// stops once its last client left.
+func TestRoomsStop(t *testing.T) {
+	url, metrics := startRooms(t)
+
+	resp, err := http.Get(strings.Replace(url, "ws", "http", 1) + "/favicon.ico")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+	waitMetrics(t, metrics, `pairpad_rooms 0`)
+
+	a := connect(t, url+"/a", 2)
+	if err := a[0].Append("hello"); err != nil {
+		t.Fatal(err)
+	}
+	converge(t, a)
+	waitMetrics(t, metrics, `pairpad_rooms 1`)
+
+	a[0].Close()
+	waitMetrics(t, metrics, `pairpad_rooms 1`, `pairpad_clients{room="a"} 1`)
+	a[1].Close()
+	waitMetrics(t, metrics, `pairpad_rooms 0`)
+
+	// the room starts over
+	b := connect(t, url+"/a", 1)
+	if got := b[0].Content(); got != "" {
+		t.Fatalf("got %q in a new room", got)
+	}
+	waitMetrics(t, metrics, `pairpad_rooms 1`)
+}
@@ -0,0 +1,316 @@
+// Package sim runs replicas of a document in memory over a simulated network
+// that delays, reorders, duplicates and partitions their operations, to
+// check that they converge.
+//
+// A run is described by a Script, a list of steps generated from a seed.
+// Replaying a script gives the same run, and a failing script can be
+// shrunk to the few steps that still make it fail.
+package sim
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"unicode/utf8"
+
+	"diploma/commons"
+	"diploma/crdt"
+)
+
+type StepKind int
+
+const (
+	// Edit inserts Value at, or deletes the rune after, position At of a
+	// replica, modulo its length.
+	Edit StepKind = iota
+
+	// Deliver hands the message Pick, modulo the number of messages waiting
+	// for Replica, to it. Duplicate keeps a copy on the network.
+	Deliver
+
+	// Cut stops the messages from Replica to Peer until Heal.
+	Cut
+	Heal
+)
+
+type Step struct {
+	Kind    StepKind
+	Replica int
+
+	// Edit
+	Delete bool
+	At     int
+	Value  string
+
+	// Deliver
+	Pick      int
+	Duplicate bool
+
+	// Cut and Heal
+	Peer int
+}
+
+func (s Step) String() string {
+	switch s.Kind {
+	case Edit:
+		if s.Delete {
+			return fmt.Sprintf("r%d delete @%d", s.Replica, s.At)
+		}
+		return fmt.Sprintf("r%d insert %q @%d", s.Replica, s.Value, s.At)
+	case Deliver:
+		if s.Duplicate {
+			return fmt.Sprintf("r%d receive #%d twice", s.Replica, s.Pick)
+		}
+		return fmt.Sprintf("r%d receive #%d", s.Replica, s.Pick)
+	case Cut:
+		return fmt.Sprintf("cut r%d -> r%d", s.Replica, s.Peer)
+	case Heal:
+		return fmt.Sprintf("heal r%d -> r%d", s.Replica, s.Peer)
+	}
+	return "?"
+}
+
+type Script struct {
+	Replicas int
+	Steps    []Step
+}
+
+func (s Script) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d replicas, %d steps\n", s.Replicas, len(s.Steps))
+	for i, step := range s.Steps {
+		fmt.Fprintf(&b, "%4d  %s\n", i, step)
+	}
+	return b.String()
+}
+
+// Config sets how likely each kind of step is. The probabilities are
+// checked in order, the rest of the steps are deliveries.
+type Config struct {
+	Replicas int
+	Steps    int
+
+	Edit      float64
+	Delete    float64 // share of the edits that delete
+	Duplicate float64 // share of the deliveries that duplicate
+	Partition float64 // a link is cut or healed
+}
+
+func DefaultConfig() Config {
+	return Config{Replicas: 3, Steps: 300, Edit: 0.4, Delete: 0.3, Duplicate: 0.1, Partition: 0.03}
+}
+
+// Generate returns the script for a seed.
+func Generate(seed int64, conf Config) Script {
+	r := rand.New(rand.NewSource(seed))
+	script := Script{Replicas: conf.Replicas}
+
+	alphabet := []rune("abcdefghijklmnopqrstuvwxyz\n é")
+	for i := 0; i < conf.Steps; i++ {
+		step := Step{Replica: r.Intn(conf.Replicas)}
+
+		switch p := r.Float64(); {
+		case p < conf.Edit:
+			step.Kind = Edit
+			step.At = r.Intn(1 << 16)
+			step.Delete = r.Float64() < conf.Delete
+			if !step.Delete {
+				step.Value = string(alphabet[r.Intn(len(alphabet))])
+			}
+
+		case p < conf.Edit+conf.Partition:
+			step.Kind = Cut
+			if r.Intn(2) == 0 {
+				step.Kind = Heal
+			}
+			step.Peer = r.Intn(conf.Replicas)
+
+		default:
+			step.Kind = Deliver
+			step.Pick = r.Intn(1 << 16)
+			step.Duplicate = r.Float64() < conf.Duplicate
+		}
+
+		script.Steps = append(script.Steps, step)
+	}
+	return script
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// replica is a site with its copy of the document. Operations that depend
+// on characters it doesn't have yet wait in pending, as in the client.
+type replica struct {
+	site    int
+	doc     crdt.Document
+	pending commons.Pending
+}
+
+type message struct {
+	from int
+	op   commons.Operation
+}
+
+// network holds the messages sent to each replica and the cut links.
+type network struct {
+	inbox [][]message
+	cut   map[[2]int]bool
+}
+
+// deliverable returns the indexes of the messages that can reach to.
+func (n *network) deliverable(to int) []int {
+	var ready []int
+	for i, m := range n.inbox[to] {
+		if !n.cut[[2]int{m.from, to}] {
+			ready = append(ready, i)
+		}
+	}
+	return ready
+}
+
+var ErrDiverged = errors.New("replicas diverged")
+
+// Result is the text every replica ended up with.
+type Result struct {
+	Contents []string
+}
+
+// Run plays a script, then heals the network and delivers every message
+// left. It fails if any operation can't be integrated or the replicas
+// don't end with the same text.
+func Run(script Script) (Result, error) {
+	// the CRDT keeps the site and clock in globals, which the replicas take
+	// turns with
+	savedSite, savedClock := crdt.SiteID, crdt.LocalClock
+	defer func() { crdt.SiteID, crdt.LocalClock = savedSite, savedClock }()
+	crdt.LocalClock = 0
+
+	replicas := make([]*replica, script.Replicas)
+	for i := range replicas {
+		replicas[i] = &replica{site: i + 1, doc: crdt.New()}
+	}
+	net := &network{inbox: make([][]message, script.Replicas), cut: map[[2]int]bool{}}
+
+	for i, step := range script.Steps {
+		if step.Replica >= len(replicas) || step.Peer >= len(replicas) {
+			continue
+		}
+		if err := play(step, replicas, net); err != nil {
+			return Result{}, fmt.Errorf("step %d (%s): %w", i, step, err)
+		}
+	}
+
+	// let everything through
+	net.cut = map[[2]int]bool{}
+	for to := range replicas {
+		for len(net.inbox[to]) > 0 {
+			if err := play(Step{Kind: Deliver, Replica: to}, replicas, net); err != nil {
+				return Result{}, fmt.Errorf("flush: %w", err)
+			}
+		}
+	}
+
+	var result Result
+	for _, r := range replicas {
+		if r.pending.Len() > 0 {
+			return result, fmt.Errorf("replica %d has %d operations it can't integrate", r.site, r.pending.Len())
+		}
+		result.Contents = append(result.Contents, crdt.Content(r.doc))
+	}
+	for _, content := range result.Contents[1:] {
+		if content != result.Contents[0] {
+			return result, fmt.Errorf("%w: %q", ErrDiverged, result.Contents)
+		}
+	}
+	return result, nil
+}
+
+func play(step Step, replicas []*replica, net *network) error {
+	r := replicas[step.Replica]
+
+	switch step.Kind {
+	case Edit:
+		crdt.SiteID = r.site
+		length := utf8.RuneCountInString(crdt.Content(r.doc))
+
+		var op commons.Operation
+		if step.Delete {
+			if length == 0 {
+				return nil
+			}
+			var err error
+			if op, err = commons.Delete(&r.doc, step.At%length+1); err != nil {
+				return err
+			}
+		} else {
+			var err error
+			if op, err = commons.Insert(&r.doc, step.At%(length+1)+1, step.Value); err != nil {
+				return err
+			}
+		}
+
+		for to := range replicas {
+			if to != step.Replica {
+				net.inbox[to] = append(net.inbox[to], message{from: step.Replica, op: op})
+			}
+		}
+
+	case Deliver:
+		ready := net.deliverable(step.Replica)
+		if len(ready) == 0 {
+			return nil
+		}
+		i := ready[step.Pick%len(ready)]
+		m := net.inbox[step.Replica][i]
+		if !step.Duplicate {
+			net.inbox[step.Replica] = append(net.inbox[step.Replica][:i], net.inbox[step.Replica][i+1:]...)
+		}
+		_, err := r.pending.Integrate(&r.doc, commons.RemoteOp{Operation: m.op})
+		return err
+
+	case Cut, Heal:
+		if step.Replica != step.Peer {
+			net.cut[[2]int{step.Replica, step.Peer}] = step.Kind == Cut
+		}
+	}
+	return nil
+}
+
+// ////////////////////////////////////////////////////////////////////
+// ////////////////////////////////////////////////////////////////////
+
+// Shrink removes steps from a failing script for as long as it keeps
+// failing, using delta debugging: big chunks first, then smaller ones down
+// to single steps.
+func Shrink(script Script, fails func(Script) bool) Script {
+	steps := script.Steps
+	try := func(steps []Step) bool {
+		return fails(Script{Replicas: script.Replicas, Steps: steps})
+	}
+
+	for chunk := len(steps) / 2; chunk >= 1; {
+		removed := false
+		for start := 0; start+chunk <= len(steps); {
+			candidate := append(append([]Step(nil), steps[:start]...), steps[start+chunk:]...)
+			if try(candidate) {
+				steps = candidate
+				removed = true
+			} else {
+				start += chunk
+			}
+		}
+		if !removed {
+			chunk /= 2
+		}
+	}
+
+	// fewer replicas are easier to follow
+	for n := 1; n < script.Replicas; n++ {
+		if fails(Script{Replicas: n, Steps: steps}) {
+			return Script{Replicas: n, Steps: steps}
+		}
+	}
+	return Script{Replicas: script.Replicas, Steps: steps}
+}
@@ -0,0 +1,120 @@
+package sim
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+)
+
+var (
+	seeds = flag.Int("sim.seeds", 200, "number of seeds TestConvergence runs")
+	seed  = flag.Int64("sim.seed", -1, "run TestConvergence for this seed only")
+)
+
+func TestConvergence(t *testing.T) {
+	from, to := int64(1), int64(*seeds)
+	if *seed >= 0 {
+		from, to = *seed, *seed
+	}
+
+	for s := from; s <= to; s++ {
+		for _, conf := range []Config{
+			DefaultConfig(),
+			{Replicas: 5, Steps: 400, Edit: 0.3, Delete: 0.4, Duplicate: 0.3, Partition: 0.1},
+			{Replicas: 2, Steps: 200, Edit: 0.8, Delete: 0.2, Duplicate: 0.05, Partition: 0.01},
+		} {
+			script := Generate(s, conf)
+			if _, err := Run(script); err != nil {
+				small := Shrink(script, func(s Script) bool {
+					_, err := Run(s)
+					return err != nil
+				})
+				_, smallErr := Run(small)
+				t.Fatalf("seed %d with %+v: %v\nshrunk to: %v\n%s", s, conf, err, smallErr, small)
+			}
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	conf := DefaultConfig()
+	if !reflect.DeepEqual(Generate(42, conf), Generate(42, conf)) {
+		t.Fatal("the same seed generated different scripts")
+	}
+	if reflect.DeepEqual(Generate(42, conf), Generate(43, conf)) {
+		t.Fatal("different seeds generated the same script")
+	}
+
+	script := Generate(42, conf)
+	first, err := Run(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Run(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("replaying a script gave %q, then %q", first.Contents, second.Contents)
+	}
+}
+
+func TestConcurrentInsertsInPartition(t *testing.T) {
+	script := Script{Replicas: 3, Steps: []Step{
+		{Kind: Edit, Replica: 0, Value: "a"},
+		{Kind: Deliver, Replica: 1},
+		{Kind: Deliver, Replica: 2},
+
+		// everyone types after "a" while nobody hears the others
+		{Kind: Cut, Replica: 0, Peer: 1}, {Kind: Cut, Replica: 1, Peer: 0},
+		{Kind: Cut, Replica: 0, Peer: 2}, {Kind: Cut, Replica: 2, Peer: 0},
+		{Kind: Cut, Replica: 1, Peer: 2}, {Kind: Cut, Replica: 2, Peer: 1},
+		{Kind: Edit, Replica: 0, At: 1, Value: "x"},
+		{Kind: Edit, Replica: 1, At: 1, Value: "y"},
+		{Kind: Edit, Replica: 2, At: 1, Value: "z"},
+		{Kind: Edit, Replica: 2, At: 0, Delete: true},
+
+		// replica 1 hears about 2 twice before anything else
+		{Kind: Heal, Replica: 2, Peer: 1},
+		{Kind: Deliver, Replica: 1, Pick: 1, Duplicate: true},
+		{Kind: Deliver, Replica: 1, Pick: 0},
+		{Kind: Deliver, Replica: 1, Pick: 0},
+	}}
+
+	result, err := Run(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := result.Contents[0]; len([]rune(got)) != 3 {
+		t.Fatalf("got %q, want x, y and z in some order", got)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	// a made up bug: replica 1 deleting after replica 0 inserted "q"
+	fails := func(s Script) bool {
+		inserted := false
+		for _, step := range s.Steps {
+			switch {
+			case step.Kind == Edit && step.Replica == 0 && step.Value == "q":
+				inserted = true
+			case step.Kind == Edit && step.Replica == 1 && step.Delete && inserted:
+				return true
+			}
+		}
+		return false
+	}
+
+	script := Script{Replicas: 3}
+	for i := 0; i < 50; i++ {
+		script.Steps = append(script.Steps, Step{Kind: Deliver, Replica: i % 3})
+	}
+	script.Steps[10] = Step{Kind: Edit, Replica: 0, Value: "q"}
+	script.Steps[40] = Step{Kind: Edit, Replica: 1, Delete: true}
+
+	small := Shrink(script, fails)
+	want := []Step{script.Steps[10], script.Steps[40]}
+	if !reflect.DeepEqual(small.Steps, want) || small.Replicas != 1 {
+		t.Fatalf("shrunk to:\n%s", small)
+	}
+}